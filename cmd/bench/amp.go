@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+// ampSnapshot captures the byte and stall counters used for the
+// amplification report.
+type ampSnapshot struct {
+	userBytes    int64
+	walBytes     int64
+	flushBytes   int64
+	compactBytes int64
+	compactRead  int64
+	stallMicros  int64
+}
+
+func takeAmpSnapshot(m *metrics.Metrics) ampSnapshot {
+	return ampSnapshot{
+		userBytes:    m.BytesUserWritten.Value(),
+		walBytes:     m.WALBytes.Value(),
+		flushBytes:   m.BytesFlushed.Value(),
+		compactBytes: m.BytesCompacted.Value(),
+		compactRead:  m.BytesCompactRead.Value(),
+		stallMicros:  m.WriteStallMicros.Value(),
+	}
+}
+
+// reportAmplification logs write amplification, space amplification and
+// stall time for the interval between before and after.
+func reportAmplification(logger *testutil.Logger, store *tinyrocks.Store, m *metrics.Metrics, before, after ampSnapshot) {
+	user := after.userBytes - before.userBytes
+	wal := after.walBytes - before.walBytes
+	flushed := after.flushBytes - before.flushBytes
+	compacted := after.compactBytes - before.compactBytes
+	compactRead := after.compactRead - before.compactRead
+	stall := float64(after.stallMicros-before.stallMicros) / 1e6
+
+	logger.Info("Amplification:")
+	logger.Info("  User Bytes: %d", user)
+	logger.Info("  WAL Bytes: %d", wal)
+	logger.Info("  Flushed Bytes: %d", flushed)
+	logger.Info("  Compacted Bytes: %d (read %d)", compacted, compactRead)
+	if user > 0 {
+		logger.Info("  Write Amp: %.2f", float64(wal+flushed+compacted)/float64(user))
+	}
+
+	usage, err := store.EstimateDiskUsage()
+	if err != nil {
+		logger.Warn("  Disk usage unavailable: %v", err)
+	} else {
+		logger.Info("  Disk Bytes: %d (sst %d, wal %d, vlog %d)", usage.Total(), usage.SSTBytes, usage.WALBytes, usage.ValueLogBytes)
+		if sa := m.SpaceAmplification(); sa > 0 {
+			logger.Info("  Space Amp: %.2f", sa)
+		}
+	}
+	logger.Info("  Stall Seconds: %.3f", stall)
+}
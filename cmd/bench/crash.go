@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+// Crash mode runs the writes in a child process so they can be cut off by
+// SIGKILL, the way a machine or process failure would. The child writes
+// numbered keys with synced writes and prints each number once its write
+// is acknowledged; the parent kills it, reopens the store to time recovery,
+// and checks that every acknowledged key survived.
+
+// crashKey returns the key and value of write i.
+func crashKey(i int64) (key, val []byte) {
+	key = []byte(fmt.Sprintf("crash%012d", i))
+	return key, append(key, bytes.Repeat([]byte{'v'}, *valueSize)...)
+}
+
+// runCrashChild writes keys from start on until it is killed.
+func runCrashChild(start int64) {
+	store, err := openStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open: %v\n", err)
+		os.Exit(1)
+	}
+	opts := &tinyrocks.WriteOptions{Sync: true}
+	for i := start; ; i++ {
+		key, val := crashKey(i)
+		if err := store.Put(key, val, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "put: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stdout, i)
+	}
+}
+
+// crashCycle is the outcome of one kill and reopen.
+type crashCycle struct {
+	acked    int64 // writes acknowledged before the kill
+	recovery time.Duration
+	lost     int64 // acknowledged writes missing after recovery
+}
+
+// runCrash kills and reopens the store cycles times, interval apart.
+func runCrash(logger *testutil.Logger, cycles int, interval time.Duration) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var next int64 // first key the next child writes
+	var results []crashCycle
+	for c := 0; c < cycles; c++ {
+		args := append(append([]string(nil), os.Args[1:]...), fmt.Sprintf("-crash-child-start=%d", next))
+		cmd := exec.Command(self, args...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		acked := make(chan int64, 1)
+		go func() {
+			last := next - 1
+			sc := bufio.NewScanner(out)
+			for sc.Scan() {
+				if i, err := strconv.ParseInt(sc.Text(), 10, 64); err == nil {
+					last = i
+				}
+			}
+			acked <- last
+		}()
+		time.Sleep(interval)
+		_ = cmd.Process.Kill()
+		last := <-acked
+		_ = cmd.Wait()
+
+		r := crashCycle{acked: last - next + 1}
+		begin := time.Now()
+		store, err := openStore()
+		if err != nil {
+			return fmt.Errorf("cycle %d: reopen: %w", c+1, err)
+		}
+		r.recovery = time.Since(begin)
+		for i := int64(0); i <= last; i++ {
+			key, want := crashKey(i)
+			if got, ok, err := store.Get(key, nil); err != nil || !ok || !bytes.Equal(got, want) {
+				r.lost++
+			}
+		}
+		if err := store.Close(); err != nil {
+			return err
+		}
+		logger.Info("Crash %d: %d writes acknowledged, recovered in %v, %d lost", c+1, r.acked, r.recovery, r.lost)
+		results = append(results, r)
+		next = last + 1
+	}
+
+	var acked, lost int64
+	var total, worst time.Duration
+	for _, r := range results {
+		acked += r.acked
+		lost += r.lost
+		total += r.recovery
+		worst = max(worst, r.recovery)
+	}
+	logger.Info("Crash Results:")
+	logger.Info("  Cycles: %d", len(results))
+	logger.Info("  Acknowledged Writes: %d", acked)
+	logger.Info("  Lost Writes: %d", lost)
+	logger.Info("  Avg Recovery: %v", total/time.Duration(len(results)))
+	logger.Info("  Max Recovery: %v", worst)
+	if lost > 0 {
+		return fmt.Errorf("%d acknowledged writes lost", lost)
+	}
+	return nil
+}
@@ -4,10 +4,17 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/arthurzhang/kivi/internal/metrics"
 	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
 )
 
 var (
@@ -19,12 +26,45 @@ var (
 	duration    = flag.Duration("duration", 30*time.Second, "Benchmark duration")
 	seed        = flag.Int64("seed", 12345, "Random seed")
 	outDir      = flag.String("out", "runs", "Output directory")
+	configPath  = flag.String("config", "", "Store config JSON (defaults to a store under -out)")
+	drainTime   = flag.Duration("shutdown-timeout", 10*time.Second, "Time allowed to flush and close the store on exit")
+	tlInterval  = flag.Duration("timeline-interval", time.Second, "Bucket width of the timeline written to <out>/timeline.csv")
+	keySize     = flag.Int("key-size", 8, "Minimum key length in bytes")
+	keyFormat   = flag.String("key-format", "binary", "Key format (binary, string, hashed)")
+	keyDist     = flag.String("distribution", "zipfian", "Key distribution (zipfian, uniform, latest, hotspot)")
+	fieldCount  = flag.Int("field-count", 0, "Fields per value; with -field-length replaces -value-size")
+	fieldLength = flag.Int("field-length", 0, "Bytes per field")
+	mixFlag     = flag.String("mix", "", `Operation ratios overriding -workload, e.g. "read=0.7,update=0.2,insert=0.05,scan=0.05,rmw=0"`)
+	scanLength  = flag.Int("scan-length", 100, "Entries read by each SCAN")
+	warmupTime  = flag.Duration("warmup", 0, "Run the workload this long before measuring, excluded from the results")
+	cacheState  = flag.String("cache", "", `Block cache state before measuring: "cold" empties it, "warm" reads every key into it`)
+	settleTime  = flag.Duration("settle", 0, "Flush the memtable and idle this long before measuring, so background work from loading does not overlap the run")
+	crashEvery  = flag.Duration("crash-interval", 0, "Instead of the workload, kill a writing child process this often and time recovery")
+	crashCycles = flag.Int("crash-cycles", 5, "Kills in -crash-interval mode")
+	crashChild  = flag.Int64("crash-child-start", -1, "Internal: run as the crash mode writer, starting at this key")
+	targetOps   = flag.Float64("target-ops", 0, "Issue operations at this rate and measure latency from each one's intended start (0 runs flat out)")
 )
 
 func main() {
 	flag.Parse()
+	if *crashChild >= 0 {
+		runCrashChild(*crashChild)
+		return
+	}
 
 	workload := parseWorkload(*workloadStr)
+	mix := workload.Mix()
+	if *mixFlag != "" {
+		var err error
+		if mix, err = testutil.ParseOpMix(*mixFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *cacheState != "" && *cacheState != "cold" && *cacheState != "warm" {
+		fmt.Fprintf(os.Stderr, "unknown -cache state %q\n", *cacheState)
+		os.Exit(2)
+	}
 
 	logger, err := testutil.SetupLogging(fmt.Sprintf("%s/bench.log", *outDir), testutil.LevelInfo)
 	if err != nil {
@@ -32,24 +72,92 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *crashEvery > 0 {
+		logger.Info("Starting crash test: %d kills, %v apart", *crashCycles, *crashEvery)
+		if err := runCrash(logger, *crashCycles, *crashEvery); err != nil {
+			logger.Error("Crash test failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logger.Info("Starting benchmark")
-	logger.Info("  Workload: %s", *workloadStr)
+	logger.Info("  Workload: %s (%+v)", *workloadStr, mix)
 	logger.Info("  Num Keys: %d", *numKeys)
 	logger.Info("  Value Size: %d bytes", *valueSize)
 	logger.Info("  Num Ops: %d", *numOps)
 	logger.Info("  Skew: %.2f", *skew)
+	logger.Info("  Keys: %s, %s, >= %d bytes", *keyFormat, *keyDist, *keySize)
 	logger.Info("  Seed: %d", *seed)
+	if b := metrics.Build(); b.Revision != "" {
+		logger.Info("  Build: %s (%s)", b.Revision, b.GoVersion)
+	}
+	if *targetOps > 0 {
+		logger.Info("  Target: %.0f ops/sec", *targetOps)
+	}
+
+	store, err := openStore()
+	if err != nil {
+		logger.Error("Failed to open store: %v", err)
+		os.Exit(1)
+	}
+	defer shutdown(logger, store, *drainTime)
 
-	gen := testutil.NewWorkloadGenerator(workload, *seed, *numKeys, *valueSize, *skew)
-	gen.SetNumOps(*numOps)
+	newGenerator := func(seed int64, ops int) *testutil.WorkloadGenerator {
+		gen := testutil.NewWorkloadGenerator(workload, seed, *numKeys, *valueSize, *skew)
+		gen.SetNumOps(ops)
+		gen.SetMix(mix)
+		gen.SetOptions(testutil.WorkloadOptions{
+			KeySize:      *keySize,
+			KeyFormat:    parseKeyFormat(*keyFormat),
+			Distribution: parseDistribution(*keyDist),
+			FieldCount:   *fieldCount,
+			FieldLength:  *fieldLength,
+		})
+		return gen
+	}
+	gen := newGenerator(*seed, *numOps)
 
 	stats := testutil.NewBenchStats()
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	// SIGINT or SIGTERM ends the run early; the results so far are still
+	// reported and the store is flushed and closed on the way out.
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The warm-up uses its own generator, so the measured operations are
+	// the same with or without it.
+	if *warmupTime > 0 {
+		n := warmup(sigCtx, store, newGenerator(*seed+1, math.MaxInt), *warmupTime)
+		logger.Info("Warm-up: %d ops in %v", n, *warmupTime)
+	}
+	if *settleTime > 0 {
+		if err := store.Flush(true); err != nil {
+			logger.Warn("Flush before settling failed: %v", err)
+		}
+		time.Sleep(*settleTime)
+	}
+	switch *cacheState {
+	case "cold":
+		store.DropBlockCache()
+	case "warm":
+		if err := store.ScanCallback(nil, nil, func(_, _ []byte) error { return nil }); err != nil {
+			logger.Warn("Populating the block cache failed: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(sigCtx, *duration)
 	defer cancel()
 
 	timer := testutil.NewTimer("benchmark")
+	before := takeAmpSnapshot(store.Metrics())
+	timeline := testutil.NewTimeline(time.Now(), *tlInterval)
+	// With a target rate, stats keeps service time and intended keeps
+	// latency from the intended start, which is what the timeline shows.
+	var pacer *testutil.Pacer
+	intended := testutil.NewBenchStats()
+	if *targetOps > 0 {
+		pacer = testutil.NewPacer(time.Now(), *targetOps)
+	}
 
-	// Run benchmark (this will be connected to the actual store later)
 	for {
 		select {
 		case <-ctx.Done():
@@ -60,17 +168,41 @@ func main() {
 				goto done
 			}
 
+			var due time.Time
+			if pacer != nil {
+				due = pacer.Wait()
+			}
 			opStart := time.Now()
-			_ = simulateOp(op, key, val)
-			opLatency := time.Since(opStart)
+			if err := runOp(store, op, key, val); err != nil {
+				logger.Error("%s failed: %v", op, err)
+			}
+			opEnd := time.Now()
 
-			stats.Record(op, opLatency)
+			stats.Record(op, opEnd.Sub(opStart))
+			if pacer == nil {
+				timeline.Record(opEnd, opEnd.Sub(opStart))
+			} else {
+				intended.Record(op, opEnd.Sub(due))
+				timeline.Record(opEnd, opEnd.Sub(due))
+			}
 		}
 	}
 
 done:
+	if sigCtx.Err() != nil {
+		logger.Warn("Interrupted, stopping early")
+	}
+	stop() // a second signal kills the process
 	timer.Log(logger)
 	stats.Print(logger)
+	if pacer != nil {
+		logIntended(logger, intended)
+	}
+	if err := writeTimeline(timeline, filepath.Join(*outDir, "timeline.csv")); err != nil {
+		logger.Warn("Failed to write timeline: %v", err)
+	}
+	reportAmplification(logger, store, store.Metrics(), before, takeAmpSnapshot(store.Metrics()))
+	logStats(logger, store.Metrics())
 
 	logger.Info("Benchmark complete")
 }
@@ -92,9 +224,136 @@ func parseWorkload(s string) testutil.WorkloadType {
 	}
 }
 
-// simulateOp is a placeholder that will be replaced with actual store operations.
-func simulateOp(op string, key, val []byte) error {
-	// Simulate some work
-	time.Sleep(1 * time.Microsecond)
-	return nil
+func parseKeyFormat(s string) testutil.KeyFormat {
+	switch s {
+	case "string":
+		return testutil.KeyString
+	case "hashed":
+		return testutil.KeyHashed
+	default:
+		return testutil.KeyBinary
+	}
+}
+
+func parseDistribution(s string) testutil.KeyDistribution {
+	switch s {
+	case "uniform":
+		return testutil.DistUniform
+	case "latest":
+		return testutil.DistLatest
+	case "hotspot":
+		return testutil.DistHotspot
+	default:
+		return testutil.DistZipfian
+	}
+}
+
+// openStore opens the store from -config, or a default store under -out.
+func openStore() (*tinyrocks.Store, error) {
+	cfg := metrics.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		if cfg, err = metrics.LoadConfig(*configPath); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.DataDir = filepath.Join(*outDir, "data")
+		cfg.WALDir = filepath.Join(*outDir, "wal")
+	}
+	return tinyrocks.Open(cfg)
+}
+
+// warmup runs operations from gen against the store for d, discarding their
+// latencies, and returns how many it ran.
+func warmup(ctx context.Context, store *tinyrocks.Store, gen *testutil.WorkloadGenerator, d time.Duration) int {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	n := 0
+	for ctx.Err() == nil {
+		op, key, val, err := gen.Next()
+		if err != nil {
+			break
+		}
+		_ = runOp(store, op, key, val)
+		n++
+	}
+	return n
+}
+
+// logIntended reports latency measured from each operation's intended
+// start, corrected for coordinated omission.
+func logIntended(logger *testutil.Logger, bs *testutil.BenchStats) {
+	logger.Info("Latency From Intended Start:")
+	logger.Info("  P50: %v", bs.CalculatePercentile(50))
+	logger.Info("  P95: %v", bs.CalculatePercentile(95))
+	logger.Info("  P99: %v", bs.CalculatePercentile(99))
+	logger.Info("  Max: %v", bs.MaxLatency)
+}
+
+// logStats logs the store's stats dump, one line per subsystem.
+func logStats(logger *testutil.Logger, m *metrics.Metrics) {
+	var b strings.Builder
+	m.Dump(&b)
+	logger.Info("Stats:")
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		logger.Info("  %s", line)
+	}
+}
+
+// writeTimeline writes the per-interval throughput and latency of the run
+// as CSV.
+func writeTimeline(tl *testutil.Timeline, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := tl.WriteCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// shutdown flushes the memtable and closes the store, giving up after
+// timeout so a stuck disk cannot hang the process. Records already in the
+// WAL are recovered on the next open either way.
+func shutdown(logger *testutil.Logger, store *tinyrocks.Store, timeout time.Duration) {
+	done := make(chan error, 1)
+	go func() {
+		err := store.Flush(true)
+		if cerr := store.Close(); err == nil {
+			err = cerr
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Error("Failed to close store: %v", err)
+		}
+	case <-time.After(timeout):
+		logger.Error("Store did not close within %v", timeout)
+	}
+}
+
+// runOp executes a generated operation against the store.
+func runOp(store *tinyrocks.Store, op string, key, val []byte) error {
+	switch op {
+	case "PUT", "INSERT":
+		return store.Put(key, val, nil)
+	case "GET":
+		_, _, err := store.Get(key, nil)
+		return err
+	case "SCAN":
+		it := store.NewIterator(key, nil, nil)
+		for n := 0; n < *scanLength && it.Next(); n++ {
+		}
+		return it.Close()
+	case "RMW":
+		if _, _, err := store.Get(key, nil); err != nil {
+			return err
+		}
+		return store.Put(key, val, nil)
+	}
+	return fmt.Errorf("unknown op %q", op)
 }
@@ -0,0 +1,49 @@
+// Command kivi-upgrade brings a TinyRocks data directory to the on-disk
+// format of this build, so the upgrade can be done ahead of a deploy. The
+// store must not be open while it runs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+var (
+	configPath = flag.String("config", "", "Store config JSON")
+	dataDir    = flag.String("data", "", "Data directory (overrides the config)")
+)
+
+func main() {
+	flag.Parse()
+
+	cfg := metrics.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		if cfg, err = metrics.LoadConfig(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *dataDir != "" {
+		cfg.DataDir = *dataDir
+	}
+	if _, err := os.Stat(cfg.DataDir); err != nil {
+		fmt.Fprintf(os.Stderr, "No data directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	from, to, err := tinyrocks.Upgrade(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Upgrade failed at format %d: %v\n", to, err)
+		os.Exit(1)
+	}
+	if from == to {
+		fmt.Printf("%s is already at format %d\n", cfg.DataDir, to)
+		return
+	}
+	fmt.Printf("%s upgraded from format %d to %d\n", cfg.DataDir, from, to)
+}
@@ -0,0 +1,55 @@
+// Package bloom implements a fixed-size Bloom filter over byte keys.
+package bloom
+
+import "hash/fnv"
+
+// Filter is a Bloom filter. It is not safe for concurrent use; callers
+// provide their own locking.
+type Filter struct {
+	bits   []uint64
+	nbits  uint64
+	probes int
+}
+
+// New returns a filter with at least nbits bits and the given number of
+// probes per key. Non-positive arguments fall back to 1024 bits and 6 probes.
+func New(nbits, probes int) *Filter {
+	if nbits <= 0 {
+		nbits = 1024
+	}
+	if probes <= 0 {
+		probes = 6
+	}
+	words := (nbits + 63) / 64
+	return &Filter{bits: make([]uint64, words), nbits: uint64(words) * 64, probes: probes}
+}
+
+// Add inserts key into the filter.
+func (f *Filter) Add(key []byte) {
+	h1, h2 := hash(key)
+	for i := 0; i < f.probes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.nbits
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain reports whether key may have been added. False means it was
+// definitely not.
+func (f *Filter) MayContain(key []byte) bool {
+	h1, h2 := hash(key)
+	for i := 0; i < f.probes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.nbits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash derives the two hashes used for double hashing from one FNV-1a sum.
+func hash(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum, (sum>>33 | sum<<31) | 1 // odd, so probes spread over the table
+}
@@ -0,0 +1,29 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilterNoFalseNegatives(t *testing.T) {
+	f := New(10*1000, 6)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte("key" + strconv.Itoa(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.MayContain([]byte("key" + strconv.Itoa(i))) {
+			t.Fatalf("false negative for key%d", i)
+		}
+	}
+
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if f.MayContain([]byte("other" + strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	// 10 bits per key with 6 probes gives roughly 1% false positives.
+	if fp > 300 {
+		t.Fatalf("false positive rate too high: %d/10000", fp)
+	}
+}
@@ -0,0 +1,121 @@
+// Package cache implements a size-bounded LRU cache for table blocks.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Key identifies a block: the table it belongs to and its offset.
+type Key struct {
+	FileNum uint64
+	Offset  uint64
+}
+
+type entry struct {
+	key   Key
+	value []byte
+}
+
+// Cache is an LRU cache charging each block its length. It is safe for
+// concurrent use. Cached slices are shared and must not be modified.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List // front is most recently used
+	items    map[Key]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// New returns a cache holding up to capacity bytes of blocks.
+func New(capacity int64) *Cache {
+	return &Cache{capacity: capacity, ll: list.New(), items: make(map[Key]*list.Element)}
+}
+
+// Get returns the cached block for key.
+func (c *Cache) Get(key Key) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.hits.Add(1)
+		return el.Value.(*entry).value, true
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Set caches value under key, evicting least recently used blocks to stay
+// within capacity. Blocks larger than the whole cache are not kept.
+func (c *Cache) Set(key Key, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(value)) > c.capacity {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		c.size += int64(len(value) - len(e.value))
+		e.value = value
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+		c.size += int64(len(value))
+	}
+	for c.size > c.capacity {
+		el := c.ll.Back()
+		e := el.Value.(*entry)
+		c.ll.Remove(el)
+		delete(c.items, e.key)
+		c.size -= int64(len(e.value))
+	}
+}
+
+// EvictFile drops every block of the given table, e.g. after it is deleted.
+func (c *Cache) EvictFile(fileNum uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if key.FileNum == fileNum {
+			c.ll.Remove(el)
+			delete(c.items, key)
+			c.size -= int64(len(el.Value.(*entry).value))
+		}
+	}
+}
+
+// Clear drops every block. Hit and miss counts are kept.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+	c.size = 0
+}
+
+// Keys returns the keys of the cached blocks, most recently used first.
+func (c *Cache) Keys() []Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]Key, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*entry).key)
+	}
+	return keys
+}
+
+// Size returns the bytes currently cached.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Stats returns the number of hits and misses so far.
+func (c *Cache) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
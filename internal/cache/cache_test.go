@@ -0,0 +1,41 @@
+package cache
+
+import "testing"
+
+func TestCacheLRU(t *testing.T) {
+	c := New(10)
+	c.Set(Key{1, 0}, make([]byte, 4))
+	c.Set(Key{1, 4}, make([]byte, 4))
+	if _, ok := c.Get(Key{1, 0}); !ok { // now most recently used
+		t.Fatalf("expected hit")
+	}
+	c.Set(Key{2, 0}, make([]byte, 4)) // evicts {1, 4}
+
+	if _, ok := c.Get(Key{1, 4}); ok {
+		t.Fatalf("least recently used block should be evicted")
+	}
+	if _, ok := c.Get(Key{1, 0}); !ok {
+		t.Fatalf("recently used block evicted")
+	}
+	if c.Size() != 8 {
+		t.Fatalf("size %d, want 8", c.Size())
+	}
+
+	c.Set(Key{3, 0}, make([]byte, 11)) // larger than the cache
+	if _, ok := c.Get(Key{3, 0}); ok {
+		t.Fatalf("oversized block cached")
+	}
+
+	c.EvictFile(1)
+	if _, ok := c.Get(Key{1, 0}); ok || c.Size() != 4 {
+		t.Fatalf("EvictFile left blocks behind, size %d", c.Size())
+	}
+	if hits, misses := c.Stats(); hits != 2 || misses != 3 {
+		t.Fatalf("stats hits=%d misses=%d", hits, misses)
+	}
+
+	c.Clear()
+	if _, ok := c.Get(Key{2, 0}); ok || c.Size() != 0 || len(c.Keys()) != 0 {
+		t.Fatalf("Clear left blocks behind, size %d", c.Size())
+	}
+}
@@ -0,0 +1,113 @@
+// Package clock abstracts the passage of time so time-based behavior
+// (group commit windows, TTL expiry, periodic sampling) can be tested
+// without sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates tickers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// Real returns the wall clock.
+func Real() Clock { return realClock{} }
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+func (t realTicker) Stop()                 { t.t.Stop() }
+
+// Manual is a Clock that only moves when Advance is called. It is safe for
+// concurrent use.
+type Manual struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers map[*manualTicker]struct{}
+}
+
+// NewManual returns a manual clock reading start.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start, tickers: make(map[*manualTicker]struct{})}
+}
+
+// Now returns the clock's current time.
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// NewTicker returns a ticker firing every d of manual time.
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &manualTicker{m: m, c: make(chan time.Time, 1), period: d, next: m.now.Add(d)}
+	m.tickers[t] = struct{}{}
+	return t
+}
+
+// Advance moves the clock forward by d, firing every ticker whose next tick
+// falls within it. Like time.Ticker, a ticker whose last tick has not been
+// received drops further ticks.
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+	for t := range m.tickers {
+		for !t.next.After(m.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type manualTicker struct {
+	m      *Manual
+	c      chan time.Time
+	period time.Duration
+	next   time.Time // guarded by m.mu
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+func (t *manualTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Reset")
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	t.period = d
+	t.next = t.m.now.Add(d)
+	t.m.tickers[t] = struct{}{}
+}
+
+func (t *manualTicker) Stop() {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	delete(t.m.tickers, t)
+}
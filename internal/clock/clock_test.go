@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualTicker(t *testing.T) {
+	start := time.Unix(100, 0)
+	m := NewManual(start)
+	tk := m.NewTicker(10 * time.Second)
+
+	m.Advance(9 * time.Second)
+	select {
+	case <-tk.C():
+		t.Fatalf("ticked early")
+	default:
+	}
+
+	m.Advance(25 * time.Second) // crosses two ticks; the second is dropped
+	if got := <-tk.C(); !got.Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("tick at %v", got)
+	}
+	select {
+	case <-tk.C():
+		t.Fatalf("undrained tick not dropped")
+	default:
+	}
+	if !m.Now().Equal(start.Add(34 * time.Second)) {
+		t.Fatalf("now %v", m.Now())
+	}
+
+	tk.Reset(time.Second)
+	m.Advance(time.Second)
+	if got := <-tk.C(); !got.Equal(start.Add(35 * time.Second)) {
+		t.Fatalf("tick after reset at %v", got)
+	}
+
+	tk.Stop()
+	m.Advance(time.Minute)
+	select {
+	case <-tk.C():
+		t.Fatalf("stopped ticker ticked")
+	default:
+	}
+}
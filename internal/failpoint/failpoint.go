@@ -0,0 +1,92 @@
+// Package failpoint provides named fault-injection points for tests.
+//
+// Code under test calls Inject at interesting places (before an fsync, while
+// finishing a file, ...). Tests enable a failpoint with Enable or EnableFunc,
+// or by listing names in the KIVI_FAILPOINTS environment variable
+// (comma-separated), so error branches can be exercised deterministically.
+// When nothing is enabled Inject is a single atomic load.
+package failpoint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of failpoints compiled into the engine.
+const (
+	WALSync   = "wal/sync"
+	WALWrite  = "wal/write"
+	WALOpen   = "wal/open"   // opening the log file, also after a rewrite
+	WALRename = "wal/rename" // renaming a rewritten log over the old one
+)
+
+// EnvVar lists failpoints to enable at process start.
+const EnvVar = "KIVI_FAILPOINTS"
+
+// ErrInjected is returned by failpoints enabled without a custom error.
+var ErrInjected = errors.New("failpoint: injected error")
+
+var (
+	active atomic.Bool
+	mu     sync.RWMutex
+	points = map[string]func() error{}
+)
+
+func init() {
+	for _, name := range strings.Split(os.Getenv(EnvVar), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			Enable(name, nil)
+		}
+	}
+}
+
+// Enable makes Inject(name) return err (ErrInjected if err is nil).
+func Enable(name string, err error) {
+	if err == nil {
+		err = fmt.Errorf("%w: %s", ErrInjected, name)
+	}
+	EnableFunc(name, func() error { return err })
+}
+
+// EnableFunc makes Inject(name) return whatever fn returns, letting tests
+// fail only the n-th call or block until released.
+func EnableFunc(name string, fn func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+	points[name] = fn
+	active.Store(true)
+}
+
+// Disable turns off a single failpoint.
+func Disable(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(points, name)
+	active.Store(len(points) > 0)
+}
+
+// Reset turns off every failpoint.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	points = map[string]func() error{}
+	active.Store(false)
+}
+
+// Inject returns the error configured for name, or nil if it is disabled.
+func Inject(name string) error {
+	if !active.Load() {
+		return nil
+	}
+	mu.RLock()
+	fn, ok := points[name]
+	mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return fn()
+}
@@ -0,0 +1,48 @@
+package failpoint
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEnableDisable(t *testing.T) {
+	defer Reset()
+
+	if err := Inject("x"); err != nil {
+		t.Fatalf("disabled failpoint returned %v", err)
+	}
+
+	Enable("x", nil)
+	if err := Inject("x"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected ErrInjected, got %v", err)
+	}
+	if err := Inject("y"); err != nil {
+		t.Fatalf("other failpoint affected: %v", err)
+	}
+
+	Disable("x")
+	if err := Inject("x"); err != nil {
+		t.Fatalf("failpoint still active after Disable: %v", err)
+	}
+}
+
+func TestEnableFuncNthCall(t *testing.T) {
+	defer Reset()
+
+	boom := errors.New("boom")
+	calls := 0
+	EnableFunc("n", func() error {
+		calls++
+		if calls == 3 {
+			return boom
+		}
+		return nil
+	})
+
+	for i := 1; i <= 4; i++ {
+		err := Inject("n")
+		if (i == 3) != (err == boom) {
+			t.Fatalf("call %d: unexpected err %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package fsutil
+
+import "syscall"
+
+// FreeSpace returns the bytes available to an unprivileged user on the
+// filesystem holding dir.
+func FreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
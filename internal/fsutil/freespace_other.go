@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package fsutil
+
+import "errors"
+
+// FreeSpace is only implemented on Linux and macOS: elsewhere Statfs_t
+// differs or is missing, and Windows needs GetDiskFreeSpaceEx from outside
+// the standard library. Callers treat the free space as unknown.
+func FreeSpace(dir string) (uint64, error) { return 0, errors.ErrUnsupported }
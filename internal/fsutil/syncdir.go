@@ -0,0 +1,17 @@
+//go:build !windows
+
+// Package fsutil holds small filesystem helpers shared by the WAL and the
+// store.
+package fsutil
+
+import "os"
+
+// SyncDir fsyncs a directory so a rename or create inside it is durable.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
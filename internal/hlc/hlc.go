@@ -0,0 +1,149 @@
+// Package hlc implements a hybrid logical clock: timestamps pair a physical
+// wall time with a logical counter, so they follow real time closely yet
+// still order causally related events on nodes whose clocks disagree. It
+// generates commit timestamps for user-timestamped writes and replication.
+package hlc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/clock"
+)
+
+// EncodedSize is the length of an encoded Timestamp.
+const EncodedSize = 12
+
+var (
+	// ErrClockOffset is returned by Update for a remote timestamp further
+	// ahead of the local physical clock than the configured maximum offset.
+	ErrClockOffset = errors.New("hlc: remote clock too far ahead")
+
+	errShort = errors.New("hlc: encoded timestamp too short")
+)
+
+// Timestamp is a point in hybrid logical time. WallTime is in nanoseconds
+// since the Unix epoch; Logical orders events within one WallTime.
+type Timestamp struct {
+	WallTime int64
+	Logical  int32
+}
+
+// Compare returns -1, 0 or +1 as t is before, equal to or after u.
+func (t Timestamp) Compare(u Timestamp) int {
+	switch {
+	case t.WallTime < u.WallTime:
+		return -1
+	case t.WallTime > u.WallTime:
+		return 1
+	case t.Logical < u.Logical:
+		return -1
+	case t.Logical > u.Logical:
+		return 1
+	}
+	return 0
+}
+
+// Less reports whether t is before u.
+func (t Timestamp) Less(u Timestamp) bool { return t.Compare(u) < 0 }
+
+// IsZero reports whether t is the zero timestamp.
+func (t Timestamp) IsZero() bool { return t == Timestamp{} }
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%09d,%d", t.WallTime/1e9, t.WallTime%1e9, t.Logical)
+}
+
+// Append appends the encoding of t to dst. Encoded timestamps of the same
+// sign compare bytewise in timestamp order, so they can suffix keys.
+func (t Timestamp) Append(dst []byte) []byte {
+	dst = binary.BigEndian.AppendUint64(dst, uint64(t.WallTime))
+	return binary.BigEndian.AppendUint32(dst, uint32(t.Logical))
+}
+
+// Decode decodes a timestamp from the front of b and returns the rest.
+func Decode(b []byte) (Timestamp, []byte, error) {
+	if len(b) < EncodedSize {
+		return Timestamp{}, b, errShort
+	}
+	t := Timestamp{
+		WallTime: int64(binary.BigEndian.Uint64(b)),
+		Logical:  int32(binary.BigEndian.Uint32(b[8:])),
+	}
+	return t, b[EncodedSize:], nil
+}
+
+// Clock issues hybrid logical timestamps from a physical clock. It is safe
+// for concurrent use.
+type Clock struct {
+	phys      clock.Clock
+	maxOffset time.Duration
+
+	mu   sync.Mutex
+	last Timestamp // latest timestamp issued or observed
+}
+
+// New returns a clock reading physical time from phys. Update rejects
+// remote timestamps more than maxOffset ahead of phys; 0 accepts any.
+func New(phys clock.Clock, maxOffset time.Duration) *Clock {
+	return &Clock{phys: phys, maxOffset: maxOffset}
+}
+
+// Now returns a timestamp after every timestamp previously returned by Now
+// or passed to Update.
+func (c *Clock) Now() Timestamp {
+	wall := c.phys.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wall > c.last.WallTime {
+		c.last = Timestamp{WallTime: wall}
+	} else {
+		c.tick()
+	}
+	return c.last
+}
+
+// Update folds in a timestamp received from another node, so later local
+// timestamps order after it, and returns the resulting clock reading. A
+// remote clock too far ahead is rejected with ErrClockOffset and leaves
+// the clock unchanged.
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+	wall := c.phys.Now().UnixNano()
+	if c.maxOffset > 0 && remote.WallTime-wall > int64(c.maxOffset) {
+		return Timestamp{}, fmt.Errorf("%w: %v ahead, max %v", ErrClockOffset, time.Duration(remote.WallTime-wall), c.maxOffset)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case wall > c.last.WallTime && wall > remote.WallTime:
+		c.last = Timestamp{WallTime: wall}
+	case remote.Compare(c.last) > 0:
+		c.last = remote
+		c.tick()
+	default:
+		c.tick()
+	}
+	return c.last, nil
+}
+
+// Last returns the latest timestamp issued or observed, without advancing
+// the clock.
+func (c *Clock) Last() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// tick advances the logical counter, carrying into the wall time if it
+// would overflow. Callers hold c.mu.
+func (c *Clock) tick() {
+	if c.last.Logical == math.MaxInt32 {
+		c.last = Timestamp{WallTime: c.last.WallTime + 1}
+		return
+	}
+	c.last.Logical++
+}
@@ -0,0 +1,94 @@
+package hlc
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/clock"
+)
+
+func TestClockNow(t *testing.T) {
+	phys := clock.NewManual(time.Unix(100, 0))
+	c := New(phys, 0)
+
+	a := c.Now()
+	if a != (Timestamp{WallTime: 100e9}) {
+		t.Fatalf("first timestamp %v", a)
+	}
+	// A stalled physical clock still yields increasing timestamps.
+	b := c.Now()
+	if !a.Less(b) || b.WallTime != a.WallTime || b.Logical != 1 {
+		t.Fatalf("second timestamp %v after %v", b, a)
+	}
+	phys.Advance(time.Millisecond)
+	if d := c.Now(); d != (Timestamp{WallTime: 100e9 + 1e6}) {
+		t.Fatalf("timestamp after the clock moved: %v", d)
+	}
+}
+
+func TestClockUpdate(t *testing.T) {
+	phys := clock.NewManual(time.Unix(100, 0))
+	c := New(phys, time.Second)
+	local := c.Now()
+
+	// A remote node half a second ahead pulls the clock forward.
+	remote := Timestamp{WallTime: 100e9 + 5e8, Logical: 3}
+	got, err := c.Update(remote)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if want := (Timestamp{WallTime: remote.WallTime, Logical: 4}); got != want {
+		t.Fatalf("after update %v, want %v", got, want)
+	}
+	if next := c.Now(); !got.Less(next) || !local.Less(next) {
+		t.Fatalf("timestamp %v not after the remote %v", next, got)
+	}
+
+	// One further ahead than the maximum offset is rejected.
+	before := c.Last()
+	if _, err := c.Update(Timestamp{WallTime: 102e9}); !errors.Is(err, ErrClockOffset) {
+		t.Fatalf("update 2s ahead: %v, want ErrClockOffset", err)
+	}
+	if c.Last() != before {
+		t.Fatalf("rejected update moved the clock")
+	}
+
+	// Once physical time passes both, it takes over again.
+	phys.Advance(time.Second)
+	if got, _ := c.Update(remote); got != (Timestamp{WallTime: 101e9}) {
+		t.Fatalf("update behind physical time: %v", got)
+	}
+}
+
+func TestClockLogicalOverflow(t *testing.T) {
+	c := New(clock.NewManual(time.Unix(0, 0)), 0)
+	c.last = Timestamp{WallTime: 5, Logical: math.MaxInt32}
+	if got := c.Now(); got != (Timestamp{WallTime: 6}) {
+		t.Fatalf("overflowing logical counter gave %v", got)
+	}
+}
+
+func TestTimestampEncoding(t *testing.T) {
+	ts := []Timestamp{{1, 0}, {1, 7}, {2, 0}, {1 << 40, 1}}
+	var prev []byte
+	for _, x := range ts {
+		enc := x.Append([]byte("k"))[1:]
+		if len(enc) != EncodedSize {
+			t.Fatalf("encoded %v to %d bytes", x, len(enc))
+		}
+		got, rest, err := Decode(append(enc, 'r'))
+		if err != nil || got != x || string(rest) != "r" {
+			t.Fatalf("decode %v: %v rest=%q err=%v", x, got, rest, err)
+		}
+		if prev != nil && bytes.Compare(prev, enc) >= 0 {
+			t.Fatalf("encoding of %v does not sort after its predecessor", x)
+		}
+		prev = enc
+	}
+	if _, _, err := Decode(make([]byte, EncodedSize-1)); err == nil {
+		t.Fatalf("decoded a short timestamp")
+	}
+}
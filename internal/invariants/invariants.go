@@ -0,0 +1,19 @@
+// Package invariants holds assertions that are compiled in only when
+// building with -tags invariants. They check orderings the engine relies on
+// (iterator output, sequence application, table key order) and panic with
+// context when one is violated, which is far easier to debug than the
+// corruption that would surface later.
+//
+// Callers guard expensive checks with Enabled so release builds pay nothing:
+//
+//	if invariants.Enabled && bytes.Compare(key, prev) <= 0 {
+//		invariants.Violation("iterator: key %q after %q", key, prev)
+//	}
+package invariants
+
+import "fmt"
+
+// Violation panics with a formatted description of a broken invariant.
+func Violation(format string, args ...any) {
+	panic(fmt.Sprintf("invariant violated: "+format, args...))
+}
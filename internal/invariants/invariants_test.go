@@ -0,0 +1,16 @@
+package invariants
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestViolationPanics(t *testing.T) {
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, `key "b" after "c"`) {
+			t.Fatalf("unexpected panic %q", msg)
+		}
+	}()
+	Violation("key %q after %q", "b", "c")
+}
@@ -1,6 +1,9 @@
 package memtable
 
-import "sync"
+import (
+	"math"
+	"sync"
+)
 
 // Arena is a simple bump-pointer allocator for byte slices.
 // It reduces GC pressure by allocating from a contiguous buffer.
@@ -20,13 +23,20 @@ func (a *Arena) ensure(n int) {
 	if len(a.buf)-a.off >= n {
 		return
 	}
-	// grow: double until enough
+	// grow: double until enough, without letting int overflow on 32-bit
 	need := a.off + n
+	if n < 0 || need < a.off {
+		panic("arena: allocation size overflows int")
+	}
 	cap := len(a.buf)
 	if cap == 0 {
 		cap = 1024
 	}
 	for cap < need {
+		if cap > math.MaxInt/2 {
+			cap = need
+			break
+		}
 		cap *= 2
 	}
 	nb := make([]byte, cap)
@@ -1,22 +1,94 @@
 package memtable
 
-import "sync"
+import (
+	"sort"
+	"sync"
+	"time"
 
-// Memtable wraps a mutable skiplist plus a single immutable skiplist created on flip.
-// It provides merged reads across current and immutable, and exposes an iterator
-// that merges the two.
+	"github.com/arthurzhang/kivi/internal/bloom"
+)
+
+// Options configure a Memtable.
+type Options struct {
+	// Threshold is the approximate size in bytes of the current table that
+	// triggers a flip to immutable. Zero disables flipping.
+	Threshold int
+	// MaxImmutable bounds the queue of immutable tables awaiting flush.
+	// Defaults to 1.
+	MaxImmutable int
+	// StallWhenFull makes writes that would flip into a full queue block
+	// until PopImmutable frees a slot. Without it, current keeps growing
+	// past Threshold instead. Only enable it when something pops tables.
+	StallWhenFull bool
+	// OnStall, if set, is called with the time a write spent stalled.
+	OnStall func(time.Duration)
+	// BloomBits, when > 0, gives every table a Bloom filter of that many
+	// bits over its keys so lookups of absent keys skip the table.
+	BloomBits int
+	// Observer, if set, receives bloom filter and flush queue accounting.
+	Observer Observer
+}
+
+// Observer receives memtable accounting. metrics.Metrics implements it.
+type Observer interface {
+	// RecordMemtableBloom records a bloom filter check; mayContain is false
+	// when the filter let a lookup skip a table.
+	RecordMemtableBloom(mayContain bool)
+	// RecordMemtableGet records a Get that consulted tables skiplists, of
+	// which falsePositives passed their bloom filter without holding the key.
+	RecordMemtableGet(tables, falsePositives int)
+	// RecordFlushQueued records a table flipped to immutable, which waits
+	// in the queue until its flush starts.
+	RecordFlushQueued()
+}
+
+// nopObserver discards accounting when no Observer is configured.
+type nopObserver struct{}
+
+func (nopObserver) RecordMemtableBloom(bool)   {}
+func (nopObserver) RecordMemtableGet(int, int) {}
+func (nopObserver) RecordFlushQueued()         {}
+
+// Memtable wraps a mutable skiplist plus a bounded queue of immutable
+// skiplists created on flip. It provides merged reads across all of them,
+// newest first, and an iterator over the merged view.
 type Memtable struct {
 	mu        sync.RWMutex
+	notFull   *sync.Cond // signalled by PopImmutable; uses mu
 	current   *Skiplist
-	imm       *Skiplist
-	threshold int // approximate threshold in bytes to trigger flip
+	imms      []*Skiplist // oldest first
+	opts      Options
 	arenaCap  int
 	sizeBytes int // rough accounting: key+val sizes of current
 }
 
 // NewMemtable creates a new memtable with a size threshold in bytes.
 func NewMemtable(threshold int) *Memtable {
-	return &Memtable{current: NewSkiplist(NewArena(1 << 20)), threshold: threshold, arenaCap: 1 << 20}
+	return NewMemtableWithOptions(Options{Threshold: threshold})
+}
+
+// NewMemtableWithOptions creates a memtable with custom options.
+func NewMemtableWithOptions(opts Options) *Memtable {
+	if opts.MaxImmutable <= 0 {
+		opts.MaxImmutable = 1
+	}
+	if opts.Observer == nil {
+		opts.Observer = nopObserver{}
+	}
+	m := &Memtable{opts: opts, arenaCap: 1 << 20}
+	m.current = m.newTable()
+	m.notFull = sync.NewCond(&m.mu)
+	return m
+}
+
+// newTable returns an empty skiplist configured from m.opts.
+func (m *Memtable) newTable() *Skiplist {
+	s := NewSkiplist(NewArena(m.arenaCap))
+	if m.opts.BloomBits > 0 {
+		s.bloom = bloom.New(m.opts.BloomBits, 0)
+		s.obs = m.opts.Observer
+	}
+	return s
 }
 
 func (m *Memtable) Put(key, val []byte, seq uint64) error {
@@ -24,10 +96,8 @@ func (m *Memtable) Put(key, val []byte, seq uint64) error {
 	defer m.mu.Unlock()
 	// Flip if exceeding threshold (simple heuristic)
 	projected := m.sizeBytes + len(key) + len(val)
-	if m.imm == nil && m.threshold > 0 && projected > m.threshold {
-		m.imm = m.current
-		m.current = NewSkiplist(NewArena(m.arenaCap))
-		m.sizeBytes = 0
+	if m.opts.Threshold > 0 && projected > m.opts.Threshold {
+		m.maybeFlip()
 	}
 	if err := m.current.Put(key, val, seq); err == nil {
 		m.sizeBytes += len(key) + len(val)
@@ -35,6 +105,47 @@ func (m *Memtable) Put(key, val []byte, seq uint64) error {
 	return nil
 }
 
+// Flip moves a non-empty current table to the immutable queue regardless of
+// its size, for flushes triggered by something other than memtable bytes.
+// It reports whether a flip happened; a full queue without StallWhenFull
+// prevents it.
+func (m *Memtable) Flip() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current.empty() {
+		return false
+	}
+	n := len(m.imms)
+	m.maybeFlip()
+	return len(m.imms) > n
+}
+
+// maybeFlip moves current to the immutable queue, stalling for a free slot
+// if configured to. Callers hold m.mu.
+func (m *Memtable) maybeFlip() {
+	if len(m.imms) >= m.opts.MaxImmutable {
+		if !m.opts.StallWhenFull {
+			return
+		}
+		start := time.Now()
+		for len(m.imms) >= m.opts.MaxImmutable && m.opts.StallWhenFull {
+			m.notFull.Wait()
+		}
+		if m.opts.OnStall != nil {
+			m.opts.OnStall(time.Since(start))
+		}
+		// SetStallWhenFull may have ended the stall with the queue full.
+		if len(m.imms) >= m.opts.MaxImmutable {
+			return
+		}
+	}
+	m.current.sealed = time.Now()
+	m.imms = append(m.imms, m.current)
+	m.opts.Observer.RecordFlushQueued()
+	m.current = m.newTable()
+	m.sizeBytes = 0
+}
+
 func (m *Memtable) Delete(key []byte, seq uint64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -42,133 +153,155 @@ func (m *Memtable) Delete(key []byte, seq uint64) error {
 	return m.current.Delete(key, seq)
 }
 
+// DeleteRange deletes every key in [start, end) written before seq. Get also
+// hides matching keys of the immutable tables, since it consults current first.
+func (m *Memtable) DeleteRange(start, end []byte, seq uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Like point deletes, range deletes go to current; Get stops there.
+	return m.current.DeleteRange(start, end, seq)
+}
+
 func (m *Memtable) Get(key []byte) ([]byte, bool) {
-	m.mu.RLock()
-	cur := m.current
-	imm := m.imm
-	m.mu.RUnlock()
+	v, deleted, found := m.Lookup(key)
+	return v, found && !deleted
+}
 
-	if v, ok := cur.Get(key); ok {
-		return v, true
+// Lookup returns the newest state of key across all tables. deleted is set
+// when that state is a tombstone, which must hide older values stored
+// elsewhere, e.g. in SSTables.
+func (m *Memtable) Lookup(key []byte) (val []byte, deleted, found bool) {
+	v, deleted, found, st := lookupAll(m.tables(), key)
+	m.opts.Observer.RecordMemtableGet(st.tables, st.falsePositives)
+	return v, deleted, found
+}
+
+// tables returns current followed by the immutable tables, newest first.
+func (m *Memtable) tables() []*Skiplist {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tables := make([]*Skiplist, 0, 1+len(m.imms))
+	tables = append(tables, m.current)
+	for i := len(m.imms) - 1; i >= 0; i-- {
+		tables = append(tables, m.imms[i])
 	}
-	if imm != nil {
-		return imm.Get(key)
+	return tables
+}
+
+// lookupStats describes the work done by one lookupAll.
+type lookupStats struct {
+	tables         int // skiplists consulted
+	falsePositives int // bloom filters that passed for an absent key
+}
+
+// lookupAll returns the newest state of key across tables, which must be
+// ordered newest first. A tombstone in a newer table hides older values.
+func lookupAll(tables []*Skiplist, key []byte) (val []byte, deleted bool, found bool, st lookupStats) {
+	for _, t := range tables {
+		v, deleted, found, fp := t.probe(key)
+		st.tables++
+		if fp {
+			st.falsePositives++
+		}
+		if found {
+			return v, deleted, true, st
+		}
 	}
-	return nil, false
+	return nil, false, false, st
 }
 
 // HasImmutable reports whether an immutable memtable exists.
 func (m *Memtable) HasImmutable() bool {
+	return m.ImmutableCount() > 0
+}
+
+// ImmutableCount returns the number of immutable tables awaiting flush.
+func (m *Memtable) ImmutableCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.imms)
+}
+
+// OldestImmutable returns the oldest immutable skiplist without removing
+// it, or nil if there is none. A flush writes it out and then calls
+// PopImmutable, so reads see its data throughout.
+func (m *Memtable) OldestImmutable() *Skiplist {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.imm != nil
+	if len(m.imms) == 0 {
+		return nil
+	}
+	return m.imms[0]
 }
 
-// PopImmutable returns the immutable skiplist and clears it.
+// PopImmutable removes and returns the oldest immutable skiplist, or nil if
+// there is none, and wakes writers stalled on a full queue.
 func (m *Memtable) PopImmutable() *Skiplist {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	imm := m.imm
-	m.imm = nil
+	if len(m.imms) == 0 {
+		return nil
+	}
+	imm := m.imms[0]
+	m.imms[0] = nil
+	m.imms = m.imms[1:]
+	m.notFull.Broadcast()
 	return imm
 }
 
-// Merged iterator of current and immutable, choosing the newest value by presence in current first.
-type mergedIterator struct {
-	curIt *Iterator
-	immIt *Iterator
-	curK  []byte
-	immK  []byte
-	curV  []byte
-	immV  []byte
-	valid bool
-}
-
-func (m *Memtable) NewIterator() *mergedIterator {
-	m.mu.RLock()
-	cur := m.current.NewIterator()
-	var imm *Iterator
-	if m.imm != nil {
-		imm = m.imm.NewIterator()
-	}
-	m.mu.RUnlock()
-	return &mergedIterator{curIt: cur, immIt: imm}
+// SetStallWhenFull overrides Options.StallWhenFull, so the owner can stall
+// writes only while something is popping tables. Turning it off wakes
+// stalled writers, which then leave current growing.
+func (m *Memtable) SetStallWhenFull(stall bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opts.StallWhenFull = stall
+	m.notFull.Broadcast()
 }
 
-func (it *mergedIterator) SeekGE(key []byte) {
-	if it.curIt != nil {
-		it.curIt.SeekGE(key)
-	}
-	if it.immIt != nil {
-		it.immIt.SeekGE(key)
-	}
-	it.advance()
+// NewIterator returns a snapshot iterator over the merged view of current
+// and every immutable table. Point and range tombstones in newer tables hide
+// older values.
+func (m *Memtable) NewIterator() *Iterator {
+	return m.newIterator(false)
 }
 
-func (it *mergedIterator) Valid() bool { return it.valid }
-func (it *mergedIterator) Key() []byte {
-	if it.curK != nil {
-		return it.curK
-	}
-	return it.immK
-}
-func (it *mergedIterator) Value() []byte {
-	if it.curK != nil {
-		return it.curV
-	}
-	return it.immV
+// NewIteratorWithTombstones is NewIterator that also yields deleted keys,
+// flagged by Iterator.Deleted, so the caller can merge the memtable over
+// older data.
+func (m *Memtable) NewIteratorWithTombstones() *Iterator {
+	return m.newIterator(true)
 }
 
-func (it *mergedIterator) Next() { it.advanceFromCurrentKey() }
-
-func (it *mergedIterator) advance() {
-	// Choose smallest user key among current/imm, but prefer current on equality
-	if it.curIt != nil && it.curIt.Valid() {
-		it.curK, it.curV = it.curIt.Key(), it.curIt.Value()
-	} else {
-		it.curK, it.curV = nil, nil
-	}
-	if it.immIt != nil && it.immIt.Valid() {
-		it.immK, it.immV = it.immIt.Key(), it.immIt.Value()
-	} else {
-		it.immK, it.immV = nil, nil
-	}
+func (m *Memtable) newIterator(tombstones bool) *Iterator {
+	tables := m.tables()
 
-	if it.curK == nil && it.immK == nil {
-		it.valid = false
-		return
-	}
-	if it.curK == nil {
-		it.valid = true
-		return
-	}
-	if it.immK == nil {
-		it.valid = true
-		return
+	seen := make(map[string]struct{})
+	var all []string
+	for _, t := range tables {
+		t.mu.RLock()
+		for _, k := range t.keys {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				all = append(all, k)
+			}
+		}
+		t.mu.RUnlock()
 	}
-	// both valid: keep as is, but ensure selection via accessors prefers curK when equal
-	it.valid = true
-}
+	sort.Strings(all)
 
-func (it *mergedIterator) advanceFromCurrentKey() {
-	if !it.valid {
-		return
-	}
-	// Determine current chosen key
-	var chosen []byte
-	if it.curK != nil && (it.immK == nil || string(it.curK) <= string(it.immK)) {
-		chosen = it.curK
-		it.curIt.Next()
-	} else {
-		chosen = it.immK
-		it.immIt.Next()
-	}
-	// Skip duplicate key on the other iterator if equal
-	if it.curIt != nil && it.curIt.Valid() && string(it.curIt.Key()) == string(chosen) {
-		it.curIt.Next()
-	}
-	if it.immIt != nil && it.immIt.Valid() && string(it.immIt.Key()) == string(chosen) {
-		it.immIt.Next()
+	it := &Iterator{keys: make([][]byte, 0, len(all)), vals: make([][]byte, 0, len(all)), idx: -1}
+	for _, k := range all {
+		key := []byte(k)
+		v, deleted, found, _ := lookupAll(tables, key)
+		if !found || (deleted && !tombstones) {
+			continue
+		}
+		it.keys = append(it.keys, key)
+		it.vals = append(it.vals, v)
+		if tombstones {
+			it.dels = append(it.dels, deleted)
+		}
 	}
-	it.advance()
+	return it
 }
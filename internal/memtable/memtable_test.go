@@ -263,10 +263,46 @@ func TestRandomOpsPropertyAgainstMap(t *testing.T) {
 	}
 }
 
+func TestInsertHintKeepsOrder(t *testing.T) {
+	sl := NewSkiplist(nil)
+	var want []string
+	seq := uint64(0)
+	put := func(k string) {
+		seq++
+		_ = sl.Put(b(k), b("v"), seq)
+		if !contains(want, k) {
+			want = append(want, k)
+		}
+	}
+	// Ascending runs that use the hint, interleaved with runs that do not:
+	// descending keys, keys before the hint and rewrites of existing keys.
+	for i := 100; i < 200; i++ {
+		put(keyOf(i))
+	}
+	for i := 99; i >= 50; i-- {
+		put(keyOf(i))
+	}
+	for i := 300; i < 320; i++ {
+		put(keyOf(i))
+		put(keyOf(i - 200))
+	}
+	_ = sl.Delete(b("a"), seq+1)
+	want = append(want, "a")
+	sort.Strings(want)
+
+	if !sort.StringsAreSorted(sl.keys) || len(sl.keys) != len(want) {
+		t.Fatalf("keys not sorted or duplicated: %d keys, want %d", len(sl.keys), len(want))
+	}
+	for i, k := range want {
+		if sl.keys[i] != k {
+			t.Fatalf("keys[%d] = %s, want %s", i, sl.keys[i], k)
+		}
+	}
+}
+
 // helpers
-func keyOf(i int) string     { return "k" + strconv.Itoa(i) }
-func valOf(i int) string     { return "v" + strconv.Itoa(i) }
-func clone(bz []byte) []byte { cp := make([]byte, len(bz)); copy(cp, bz); return cp }
+func keyOf(i int) string { return "k" + strconv.Itoa(i) }
+func valOf(i int) string { return "v" + strconv.Itoa(i) }
 func contains(ss []string, s string) bool {
 	for _, x := range ss {
 		if x == s {
@@ -275,3 +311,27 @@ func contains(ss []string, s string) bool {
 	}
 	return false
 }
+
+func BenchmarkSkiplistInsert(b *testing.B) {
+	const perTable = 1000
+	for _, size := range []int{16, 256, 4 << 10, 64 << 10} {
+		b.Run("value="+strconv.Itoa(size), func(b *testing.B) {
+			keys := make([][]byte, perTable)
+			for i := range keys {
+				keys[i] = []byte("key" + strconv.Itoa(100000+i))
+			}
+			val := make([]byte, size)
+			var s *Skiplist
+			b.SetBytes(int64(len(keys[0]) + size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if i%perTable == 0 {
+					b.StopTimer()
+					s = NewSkiplist(NewArena(1 << 20))
+					b.StartTimer()
+				}
+				_ = s.Put(keys[i%perTable], val, uint64(i+1))
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package memtable
 
 import (
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestMemtableFlipOnThreshold(t *testing.T) {
@@ -95,3 +97,199 @@ func TestMemtableConcurrentFlipAndGet(t *testing.T) {
 		t.Fatalf("post-concurrency get mismatch: %q ok=%v", string(v), ok)
 	}
 }
+
+func TestMemtableDeleteHidesImmAfterFlip(t *testing.T) {
+	mt := NewMemtable(4)
+	_ = mt.Put(b("a"), b("1"), 1)
+	_ = mt.Put(b("bb"), b("22"), 2) // flips "a" into imm
+	if !mt.HasImmutable() {
+		t.Fatalf("expected immutable after flip")
+	}
+	_ = mt.Delete(b("a"), 3)
+	if _, ok := mt.Get(b("a")); ok {
+		t.Fatalf("tombstone in current did not hide immutable value")
+	}
+}
+
+func TestMemtableImmutableQueue(t *testing.T) {
+	mt := NewMemtableWithOptions(Options{Threshold: 4, MaxImmutable: 2})
+	_ = mt.Put(b("a"), b("1"), 1)
+	_ = mt.Put(b("b"), b("22"), 2)  // flip: [a]
+	_ = mt.Put(b("c"), b("333"), 3) // flip: [a] [b]
+	_ = mt.Delete(b("a"), 4)
+	_ = mt.Put(b("d"), b("4444"), 5) // queue full: stays in current
+	if n := mt.ImmutableCount(); n != 2 {
+		t.Fatalf("immutable count %d, want 2", n)
+	}
+
+	if _, ok := mt.Get(b("a")); ok {
+		t.Fatalf("tombstone in current did not hide the oldest table")
+	}
+	if v, ok := mt.Get(b("b")); !ok || string(v) != "22" {
+		t.Fatalf("get from middle table: %q ok=%v", v, ok)
+	}
+
+	// Keys from older tables sort before keys in current and must still be
+	// returned in order.
+	it := mt.NewIterator()
+	var keys []string
+	for it.SeekGE(nil); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key())+"="+string(it.Value()))
+	}
+	if fmt.Sprint(keys) != "[b=22 c=333 d=4444]" {
+		t.Fatalf("merged iterator %v", keys)
+	}
+
+	if imm := mt.PopImmutable(); imm == nil {
+		t.Fatalf("expected oldest immutable")
+	} else if v, ok := imm.Get(b("a")); !ok || string(v) != "1" {
+		t.Fatalf("PopImmutable must return the oldest table")
+	}
+}
+
+func TestMemtableStallWhenFull(t *testing.T) {
+	var stalled time.Duration
+	mt := NewMemtableWithOptions(Options{
+		Threshold:     4,
+		MaxImmutable:  1,
+		StallWhenFull: true,
+		OnStall:       func(d time.Duration) { stalled = d },
+	})
+	_ = mt.Put(b("a"), b("1"), 1)
+	_ = mt.Put(b("b"), b("22"), 2) // fills the queue
+
+	done := make(chan struct{})
+	go func() {
+		_ = mt.Put(b("c"), b("333"), 3) // needs a flip, must stall
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("write should stall while the immutable queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	mt.PopImmutable()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("write still stalled after PopImmutable")
+	}
+	if stalled < 20*time.Millisecond {
+		t.Fatalf("OnStall reported %v", stalled)
+	}
+	if v, ok := mt.Get(b("c")); !ok || string(v) != "333" {
+		t.Fatalf("stalled write lost: %q ok=%v", v, ok)
+	}
+}
+
+func TestMemtableStallTurnedOff(t *testing.T) {
+	mt := NewMemtableWithOptions(Options{Threshold: 4, MaxImmutable: 1, StallWhenFull: true})
+	_ = mt.Put(b("a"), b("1"), 1)
+	_ = mt.Put(b("b"), b("22"), 2) // fills the queue
+
+	done := make(chan struct{})
+	go func() {
+		_ = mt.Put(b("c"), b("333"), 3)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("write should stall while the immutable queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	mt.SetStallWhenFull(false)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("write still stalled after SetStallWhenFull(false)")
+	}
+	if n := mt.ImmutableCount(); n != 1 {
+		t.Fatalf("%d immutable tables, want the queue left at 1", n)
+	}
+	if v, ok := mt.Get(b("c")); !ok || string(v) != "333" {
+		t.Fatalf("stalled write lost: %q ok=%v", v, ok)
+	}
+}
+
+type bloomCounter struct{ hits, misses, gets, tables, falsePositives, queued int }
+
+func (c *bloomCounter) RecordMemtableBloom(mayContain bool) {
+	if mayContain {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+func (c *bloomCounter) RecordMemtableGet(tables, falsePositives int) {
+	c.gets++
+	c.tables += tables
+	c.falsePositives += falsePositives
+}
+
+func (c *bloomCounter) RecordFlushQueued() { c.queued++ }
+
+func TestMemtableBloom(t *testing.T) {
+	obs := &bloomCounter{}
+	mt := NewMemtableWithOptions(Options{BloomBits: 8192, Observer: obs})
+	for i := 0; i < 100; i++ {
+		_ = mt.Put(b(keyOf(i)), b(valOf(i)), uint64(i+1))
+	}
+	_ = mt.Delete(b(keyOf(7)), 101)
+	_ = mt.DeleteRange(b("z"), b("zz"), 102)
+
+	for i := 0; i < 100; i++ {
+		_, ok := mt.Get(b(keyOf(i)))
+		if ok != (i != 7) {
+			t.Fatalf("%s: ok=%v", keyOf(i), ok)
+		}
+	}
+	if obs.hits != 100 || obs.misses != 0 {
+		t.Fatalf("present keys: hits=%d misses=%d", obs.hits, obs.misses)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, ok := mt.Get(b("absent" + keyOf(i))); ok {
+			t.Fatalf("absent key found")
+		}
+	}
+	if obs.misses < 90 {
+		t.Fatalf("bloom skipped only %d of 100 absent lookups", obs.misses)
+	}
+	if obs.falsePositives != 100-obs.misses {
+		t.Fatalf("%d false positives, want %d", obs.falsePositives, 100-obs.misses)
+	}
+	if obs.gets != 200 || obs.tables != 200 {
+		t.Fatalf("gets=%d tables=%d, want 200 each", obs.gets, obs.tables)
+	}
+	// Range tombstones are checked even when the bloom rules out a point entry.
+	if v, deleted, found := mt.current.lookup(b("zebra")); !found || !deleted {
+		t.Fatalf("range tombstone missed behind bloom: %q deleted=%v found=%v", v, deleted, found)
+	}
+}
+
+func TestMemtableGetTablesTouched(t *testing.T) {
+	obs := &bloomCounter{}
+	mt := NewMemtableWithOptions(Options{MaxImmutable: 2, Observer: obs})
+	_ = mt.Put(b("old"), b("1"), 1)
+	mt.Flip()
+	_ = mt.Put(b("mid"), b("2"), 2)
+	mt.Flip()
+	_ = mt.Put(b("new"), b("3"), 3)
+
+	for _, k := range []string{"new", "mid", "old", "none"} {
+		mt.Get(b(k))
+	}
+	// current only, then current+newer imm, then all three twice.
+	if obs.gets != 4 || obs.tables != 1+2+3+3 {
+		t.Fatalf("gets=%d tables=%d", obs.gets, obs.tables)
+	}
+	if obs.falsePositives != 0 {
+		t.Fatalf("false positives without bloom filters: %d", obs.falsePositives)
+	}
+	if obs.queued != 2 || mt.OldestImmutable().Sealed().IsZero() {
+		t.Fatalf("queued=%d, want 2 sealed tables", obs.queued)
+	}
+}
@@ -0,0 +1,85 @@
+package memtable
+
+import (
+	"bytes"
+	"sort"
+)
+
+// RangeTombstone deletes every key in [Start, End) written with a sequence
+// number lower than Seq.
+type RangeTombstone struct {
+	Start []byte
+	End   []byte
+	Seq   uint64
+}
+
+// rangeDels is the range-tombstone sidecar of a skiplist. Tombstones are kept
+// as written and re-fragmented on every add, which keeps lookups a binary
+// search; range deletes are expected to be rare. It relies on the owning
+// skiplist's lock.
+type rangeDels struct {
+	tombs []RangeTombstone
+	frags []RangeTombstone // Fragment(tombs)
+}
+
+func (r *rangeDels) add(t RangeTombstone) {
+	if bytes.Compare(t.Start, t.End) >= 0 {
+		return
+	}
+	r.tombs = append(r.tombs, RangeTombstone{Start: clone(t.Start), End: clone(t.End), Seq: t.Seq})
+	r.frags = Fragment(r.tombs)
+}
+
+// maxSeq returns the highest sequence number of a tombstone covering key,
+// or 0 if none does.
+func (r *rangeDels) maxSeq(key []byte) uint64 {
+	frags := r.frags
+	// First fragment ending after key; fragments are sorted and disjoint.
+	i := sort.Search(len(frags), func(i int) bool { return bytes.Compare(frags[i].End, key) > 0 })
+	if i < len(frags) && bytes.Compare(frags[i].Start, key) <= 0 {
+		return frags[i].Seq
+	}
+	return 0
+}
+
+// covers reports whether a write of key at seq is deleted by a tombstone.
+func (r *rangeDels) covers(key []byte, seq uint64) bool {
+	return len(r.frags) > 0 && seq < r.maxSeq(key)
+}
+
+// Fragment splits possibly overlapping tombstones into sorted, disjoint
+// fragments. Each fragment carries the highest sequence number among the
+// tombstones covering it, which is all a reader needs to decide whether a
+// key is deleted. Adjacent fragments with the same sequence are merged.
+func Fragment(tombs []RangeTombstone) []RangeTombstone {
+	bounds := make([][]byte, 0, 2*len(tombs))
+	for _, t := range tombs {
+		if bytes.Compare(t.Start, t.End) < 0 {
+			bounds = append(bounds, t.Start, t.End)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bytes.Compare(bounds[i], bounds[j]) < 0 })
+
+	var out []RangeTombstone
+	for i := 0; i+1 < len(bounds); i++ {
+		lo, hi := bounds[i], bounds[i+1]
+		if bytes.Equal(lo, hi) {
+			continue
+		}
+		var seq uint64
+		for _, t := range tombs {
+			if bytes.Compare(t.Start, lo) <= 0 && bytes.Compare(t.End, hi) >= 0 && t.Seq > seq {
+				seq = t.Seq
+			}
+		}
+		if seq == 0 {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Seq == seq && bytes.Equal(out[n-1].End, lo) {
+			out[n-1].End = hi
+			continue
+		}
+		out = append(out, RangeTombstone{Start: lo, End: hi, Seq: seq})
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package memtable
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFragment(t *testing.T) {
+	frags := Fragment([]RangeTombstone{
+		{Start: b("a"), End: b("e"), Seq: 5},
+		{Start: b("c"), End: b("g"), Seq: 9},
+		{Start: b("x"), End: b("x"), Seq: 3}, // empty, dropped
+		{Start: b("e"), End: b("f"), Seq: 9}, // fully under the seq 9 tombstone
+	})
+	var got []string
+	for _, f := range frags {
+		got = append(got, fmt.Sprintf("[%s,%s)@%d", f.Start, f.End, f.Seq))
+	}
+	want := "[[a,c)@5 [c,g)@9]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("fragments %v, want %s", got, want)
+	}
+}
+
+func TestSkiplistRangeDelete(t *testing.T) {
+	sl := NewSkiplist(nil)
+	_ = sl.Put(b("a"), b("1"), 1)
+	_ = sl.Put(b("b"), b("2"), 2)
+	_ = sl.Put(b("c"), b("3"), 3)
+	_ = sl.DeleteRange(b("a"), b("c"), 4)
+	_ = sl.Put(b("b"), b("22"), 5) // newer than the tombstone
+
+	if _, ok := sl.Get(b("a")); ok {
+		t.Fatalf("a should be covered by the range tombstone")
+	}
+	if v, ok := sl.Get(b("b")); !ok || string(v) != "22" {
+		t.Fatalf("b written after the tombstone: %q ok=%v", v, ok)
+	}
+	if _, ok := sl.Get(b("c")); !ok {
+		t.Fatalf("end key is exclusive")
+	}
+
+	it := sl.NewIterator()
+	var keys []string
+	for it.SeekGE(nil); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if fmt.Sprint(keys) != "[b c]" {
+		t.Fatalf("iterator keys %v", keys)
+	}
+
+	if got := sl.RangeTombstones(); len(got) != 1 || string(got[0].Start) != "a" || got[0].Seq != 4 {
+		t.Fatalf("range tombstones %+v", got)
+	}
+}
+
+func TestMemtableRangeDeleteHidesImm(t *testing.T) {
+	mt := NewMemtable(4)
+	_ = mt.Put(b("k1"), b("v1"), 1)
+	_ = mt.Put(b("k2"), b("v2"), 2) // flips k1 into imm
+	if !mt.HasImmutable() {
+		t.Fatalf("expected flip")
+	}
+	_ = mt.DeleteRange(b("k"), b("k9"), 3)
+	for _, k := range []string{"k1", "k2"} {
+		if _, ok := mt.Get(b(k)); ok {
+			t.Fatalf("%s should be hidden by the range tombstone", k)
+		}
+	}
+}
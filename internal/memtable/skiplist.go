@@ -1,8 +1,12 @@
 package memtable
 
 import (
+	"slices"
 	"sort"
 	"sync"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/bloom"
 )
 
 // Arena is a placeholder for a future arena allocator.
@@ -25,6 +29,17 @@ type Skiplist struct {
 	entries map[string]entry
 	keys    []string // sorted ascending; contains keys that may be deleted
 	arena   *Arena
+	// last is the index in keys of the most recently inserted key, the
+	// hint for the next insert; -1 before the first.
+	last   int
+	ranges rangeDels
+
+	// bloom, if set, holds every key with a point entry; obs counts checks.
+	bloom *bloom.Filter
+	obs   Observer
+
+	// sealed is when a Memtable flipped the skiplist to immutable.
+	sealed time.Time
 }
 
 // NewSkiplist creates a new Skiplist. The arena parameter is reserved
@@ -34,6 +49,7 @@ func NewSkiplist(arena *Arena) *Skiplist {
 		entries: make(map[string]entry),
 		keys:    make([]string, 0, 1024),
 		arena:   arena,
+		last:    -1,
 	}
 }
 
@@ -45,10 +61,9 @@ func (s *Skiplist) Put(key, val []byte, seq uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if cur, ok := s.entries[k]; ok {
-		if seq <= cur.seq {
-			return nil
-		}
+	cur, present := s.entries[k]
+	if present && seq <= cur.seq {
+		return nil
 	}
 
 	var stored []byte
@@ -58,10 +73,11 @@ func (s *Skiplist) Put(key, val []byte, seq uint64) error {
 		stored = clone(val)
 	}
 	s.entries[k] = entry{seq: seq, value: stored, deleted: false}
-	// Ensure key is tracked in keys slice
-	if !s.keyPresent(k) {
-		s.keys = append(s.keys, k)
-		s.sortKeys()
+	if s.bloom != nil {
+		s.bloom.Add(key)
+	}
+	if !present {
+		s.insertKey(k)
 	}
 	return nil
 }
@@ -73,38 +89,97 @@ func (s *Skiplist) Delete(key []byte, seq uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if cur, ok := s.entries[k]; ok {
-		if seq <= cur.seq {
-			return nil
-		}
+	cur, present := s.entries[k]
+	if present && seq <= cur.seq {
+		return nil
 	}
 
 	s.entries[k] = entry{seq: seq, value: nil, deleted: true}
-	if !s.keyPresent(k) {
-		s.keys = append(s.keys, k)
-		s.sortKeys()
+	if s.bloom != nil {
+		s.bloom.Add(key)
 	}
+	if !present {
+		s.insertKey(k)
+	}
+	return nil
+}
+
+// DeleteRange deletes every key in [start, end) written before seq. An empty
+// range is ignored.
+func (s *Skiplist) DeleteRange(start, end []byte, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ranges.add(RangeTombstone{Start: start, End: end, Seq: seq})
 	return nil
 }
 
+// RangeTombstones returns the range tombstones of the skiplist, fragmented
+// into sorted, disjoint ranges.
+func (s *Skiplist) RangeTombstones() []RangeTombstone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]RangeTombstone, len(s.ranges.frags))
+	copy(out, s.ranges.frags)
+	return out
+}
+
+// Sealed returns when the skiplist was flipped to immutable, or the zero
+// time if it never was.
+func (s *Skiplist) Sealed() time.Time { return s.sealed }
+
 // Get returns the visible value for a key, if present and not deleted.
 func (s *Skiplist) Get(key []byte) ([]byte, bool) {
-	k := string(key)
+	val, deleted, found := s.lookup(key)
+	if !found || deleted {
+		return nil, false
+	}
+	return val, true
+}
+
+// lookup returns the latest entry for key, including tombstones, so callers
+// merging several skiplists can stop at a newer delete. A key covered by a
+// range tombstone is reported as deleted even without a point entry.
+func (s *Skiplist) lookup(key []byte) (val []byte, deleted bool, found bool) {
+	val, deleted, found, _ = s.probe(key)
+	return val, deleted, found
+}
 
+// probe is lookup that also reports a bloom filter false positive: the
+// filter passed but the skiplist holds no point entry for key.
+func (s *Skiplist) probe(key []byte) (val []byte, deleted, found, falsePositive bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	e, ok := s.entries[k]
-	if !ok || e.deleted {
-		return nil, false
+	if s.bloom != nil {
+		may := s.bloom.MayContain(key)
+		s.obs.RecordMemtableBloom(may)
+		if !may {
+			if s.ranges.maxSeq(key) > 0 {
+				return nil, true, true, false
+			}
+			return nil, false, false, false
+		}
+	}
+
+	e, ok := s.entries[string(key)]
+	if !ok {
+		falsePositive = s.bloom != nil
+		if s.ranges.maxSeq(key) > 0 {
+			return nil, true, true, falsePositive
+		}
+		return nil, false, false, falsePositive
 	}
-	return clone(e.value), true
+	if e.deleted || s.ranges.covers(key, e.seq) {
+		return nil, true, true, false
+	}
+	return clone(e.value), false, true, false
 }
 
 // Iterator provides forward iteration over visible keys in ascending order.
 type Iterator struct {
 	keys [][]byte
 	vals [][]byte
+	dels []bool // set only for iterators that yield tombstones
 	idx  int
 }
 
@@ -116,7 +191,7 @@ func (s *Skiplist) NewIterator() *Iterator {
 	keys := make([][]byte, 0, len(s.keys))
 	vals := make([][]byte, 0, len(s.keys))
 	for _, k := range s.keys {
-		if e, ok := s.entries[k]; ok && !e.deleted {
+		if e, ok := s.entries[k]; ok && !e.deleted && !s.ranges.covers([]byte(k), e.seq) {
 			keys = append(keys, []byte(k))
 			vals = append(vals, clone(e.value))
 		}
@@ -152,11 +227,60 @@ func (it *Iterator) Value() []byte { return it.vals[it.idx] }
 // Next advances the iterator.
 func (it *Iterator) Next() { it.idx++ }
 
-// keyPresent checks if k is present in the sorted keys slice.
-func (s *Skiplist) keyPresent(k string) bool {
-	// binary search
-	i := sort.SearchStrings(s.keys, k)
-	return i < len(s.keys) && s.keys[i] == k
+// Deleted reports whether the current key is a tombstone. Only iterators
+// from Memtable.NewIteratorWithTombstones yield tombstones.
+func (it *Iterator) Deleted() bool { return it.dels != nil && it.dels[it.idx] }
+
+// Entry is the newest state of a key in a skiplist.
+type Entry struct {
+	Key     []byte
+	Value   []byte
+	Seq     uint64
+	Deleted bool
+}
+
+// Entries returns the newest state of every key in ascending key order, for
+// flushing. Keys covered by a range tombstone are reported as deleted, but
+// the tombstones themselves are not included: writing only the entries
+// would drop their effect on older data, which flushes must apply from
+// RangeTombstones.
+func (s *Skiplist) Entries() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Entry, 0, len(s.keys))
+	for _, k := range s.keys {
+		e, ok := s.entries[k]
+		if !ok {
+			continue
+		}
+		out = append(out, Entry{
+			Key:     []byte(k),
+			Value:   e.value,
+			Seq:     e.seq,
+			Deleted: e.deleted || s.ranges.covers([]byte(k), e.seq),
+		})
+	}
+	return out
+}
+
+// empty reports whether the skiplist holds no entries or range tombstones.
+func (s *Skiplist) empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) == 0 && len(s.ranges.tombs) == 0
+}
+
+// insertKey adds k, which keys does not hold yet, in order. The slot right
+// after the previous insert is tried before searching, so keys written in
+// increasing order, as sequential inserts and time series are, skip the
+// binary search and are appended. Callers hold s.mu for writing.
+func (s *Skiplist) insertKey(k string) {
+	i := s.last + 1
+	if (i > 0 && s.keys[i-1] >= k) || (i < len(s.keys) && s.keys[i] <= k) {
+		i = sort.SearchStrings(s.keys, k)
+	}
+	s.keys = slices.Insert(s.keys, i, k)
+	s.last = i
 }
 
-func (s *Skiplist) sortKeys() { sort.Strings(s.keys) }
+func clone(bz []byte) []byte { cp := make([]byte, len(bz)); copy(cp, bz); return cp }
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// BuildInfo identifies the binary a store runs in.
+type BuildInfo struct {
+	Version   string `json:"version"`    // main module version, "(devel)" for local builds
+	Revision  string `json:"revision"`   // VCS revision, empty if not stamped
+	Modified  bool   `json:"modified"`   // built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"` // toolchain that built the binary
+}
+
+var (
+	buildOnce sync.Once
+	build     BuildInfo
+)
+
+// Build returns the build information embedded by the Go toolchain. Fields
+// the binary was not stamped with are empty.
+func Build() BuildInfo {
+	buildOnce.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		build.Version = bi.Main.Version
+		build.GoVersion = bi.GoVersion
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				build.Revision = s.Value
+			case "vcs.modified":
+				build.Modified = s.Value == "true"
+			}
+		}
+	})
+	return build
+}
@@ -11,9 +11,24 @@ type Config struct {
 	// WAL configuration
 	WALDir           string `json:"wal_dir"`
 	WALGroupCommitMS int    `json:"wal_group_commit_ms"`
+	// WALTargetP99US, when > 0, tunes the group commit window (starting at
+	// WALGroupCommitMS) to keep p99 commit latency under this many µs.
+	WALTargetP99US int `json:"wal_target_p99_us"`
+	// MaxWALSizeMB, when > 0, flips the memtable for flush every time the
+	// WAL grows by this much, even if the memtable itself is small.
+	MaxWALSizeMB int `json:"max_wal_size_mb"`
 
 	// Memtable configuration
 	MemtableMB int `json:"memtable_mb"`
+	// MemtableBloomBitsPerMB sizes an optional per-memtable bloom filter;
+	// 0 disables it.
+	MemtableBloomBitsPerMB int `json:"memtable_bloom_bits_per_mb"`
+	// MaxImmutableMemtables bounds the full memtables waiting for flush.
+	// With StallWritesWhenFull, a write that would fill one more waits for
+	// a flush to make room; otherwise the active memtable grows past
+	// MemtableMB.
+	MaxImmutableMemtables int  `json:"max_immutable_memtables"`
+	StallWritesWhenFull   bool `json:"stall_writes_when_full"`
 
 	// SSTable configuration
 	BlockSizeKB     int `json:"block_size_kb"`
@@ -33,6 +48,46 @@ type Config struct {
 	// Cache configuration
 	BlockCacheMB   int  `json:"block_cache_mb"`
 	PrefetchOnSeek bool `json:"prefetch_on_seek"`
+	MaxOpenFiles   int  `json:"max_open_files"` // table files kept open by the table cache
+	// WarmBlockCache records the cached blocks on Close and reloads them in
+	// the background on the next Open.
+	WarmBlockCache bool `json:"warm_block_cache"`
+	// BigScanBlocks, when > 0, treats an iterator that has read more than
+	// this many table blocks as a big scan: it stops filling the block
+	// cache so it does not evict the blocks point reads depend on.
+	BigScanBlocks int `json:"big_scan_blocks"`
+
+	// ReservedDiskMB, when > 0, is free space kept on the data and WAL
+	// filesystems: writes fail and flushes pause rather than eat into it.
+	ReservedDiskMB int `json:"reserved_disk_mb"`
+
+	// Value log (key-value separation) configuration. With EnableValueLog,
+	// flushes move values into a log under DataDir/vlog and tables keep
+	// pointers; its files rotate every ValueLogFileMB. Values shorter than
+	// MinBlobSizeBytes stay in the tables. After a flush, files with at
+	// least ValueLogGCRatio of their bytes overwritten or deleted since
+	// the store opened are collected; 0 disables collection.
+	EnableValueLog   bool    `json:"enable_value_log"`
+	ValueLogFileMB   int     `json:"value_log_file_mb"`
+	MinBlobSizeBytes int     `json:"min_blob_size_bytes"`
+	ValueLogGCRatio  float64 `json:"value_log_gc_ratio"`
+
+	// TTL configuration; when > 0 every key expires TTLSeconds after write.
+	// The data directory records whether TTL is enabled, and Open refuses
+	// a config that enables or disables it otherwise; TTLSeconds itself
+	// may change.
+	TTLSeconds int `json:"ttl_seconds"`
+
+	// Profiling configuration; profiles are written under DataDir/profiles
+	PprofAddr                  string `json:"pprof_addr"`
+	ProfileOnStallMS           int    `json:"profile_on_stall_ms"`
+	ProfileOnCompactionBacklog int    `json:"profile_on_compaction_backlog"`
+
+	// Metrics are kept per store; PublishMetrics, off by default, also
+	// registers them with expvar, each name prefixed by MetricsPrefix.
+	// Stores published in the same process need distinct prefixes.
+	PublishMetrics bool   `json:"publish_metrics"`
+	MetricsPrefix  string `json:"metrics_prefix"`
 
 	// Data directory
 	DataDir string `json:"data_dir"`
@@ -41,21 +96,40 @@ type Config struct {
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		WALDir:                   "wal",
-		WALGroupCommitMS:         10,
-		MemtableMB:               64,
-		BlockSizeKB:              16,
-		RestartInterval:          16,
-		BloomBitsPerKey:          10,
-		Fanout:                   10,
-		MaxBackgroundCompactions: 2,
-		L0Slowdown:               8,
-		L0Stop:                   12,
-		CompactionRateLimitMBps:  512,
-		FlushParallelism:         2,
-		BlockCacheMB:             256,
-		PrefetchOnSeek:           false,
-		DataDir:                  "data",
+		WALDir:                     "wal",
+		WALGroupCommitMS:           10,
+		WALTargetP99US:             0,
+		MaxWALSizeMB:               0,
+		MemtableMB:                 64,
+		MemtableBloomBitsPerMB:     0,
+		MaxImmutableMemtables:      2,
+		StallWritesWhenFull:        true,
+		BlockSizeKB:                16,
+		RestartInterval:            16,
+		BloomBitsPerKey:            10,
+		Fanout:                     10,
+		MaxBackgroundCompactions:   2,
+		L0Slowdown:                 8,
+		L0Stop:                     12,
+		CompactionRateLimitMBps:    512,
+		FlushParallelism:           2,
+		BlockCacheMB:               256,
+		PrefetchOnSeek:             false,
+		MaxOpenFiles:               1000,
+		WarmBlockCache:             false,
+		BigScanBlocks:              0,
+		ReservedDiskMB:             0,
+		EnableValueLog:             false,
+		ValueLogFileMB:             256,
+		MinBlobSizeBytes:           256,
+		ValueLogGCRatio:            0.5,
+		TTLSeconds:                 0,
+		PprofAddr:                  "",
+		ProfileOnStallMS:           0,
+		ProfileOnCompactionBacklog: 0,
+		PublishMetrics:             false,
+		MetricsPrefix:              "",
+		DataDir:                    "data",
 	}
 }
 
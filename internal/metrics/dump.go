@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Dump writes a human-readable summary of the metrics to w.
+func (m *Metrics) Dump(w io.Writer) {
+	b := Build()
+	fmt.Fprintf(w, "build: version=%s revision=%s go=%s uptime_s=%d\n",
+		b.Version, b.Revision, b.GoVersion, int64(m.Uptime().Seconds()))
+	fmt.Fprintf(w, "ops: get=%d put=%d del=%d scan=%d write=%d\n",
+		m.GetCount.Value(), m.PutCount.Value(), m.DelCount.Value(), m.ScanCount.Value(), m.WriteCount.Value())
+	fmt.Fprintf(w, "wal: bytes=%d bytes/s=%d group_commits=%d fsync_us=%.0f\n",
+		m.WALBytes.Value(), m.WALThroughput.Rate(), m.WALGroupCommits.Value(), m.WALFsyncLatency.Value())
+	fmt.Fprintf(w, "flush: count=%d bytes=%d queue=%d\n",
+		m.FlushCount.Value(), m.BytesFlushed.Value(), m.FlushQueueDepth.Load())
+	fmt.Fprintf(w, "compaction: count=%d read=%d written=%d queue=%d\n",
+		m.CompactionCount.Value(), m.BytesCompactRead.Value(), m.BytesCompacted.Value(), m.CompactionQueueDepth.Load())
+	fmt.Fprintf(w, "amp: write=%.2f space=%.2f stall_us=%d\n",
+		m.WriteAmplification(), m.SpaceAmplification(), m.WriteStallMicros.Value())
+}
+
+// Snapshot returns every metric by its published name, without a prefix,
+// as the JSON expvar would serve for it.
+func (m *Metrics) Snapshot() map[string]json.RawMessage {
+	out := make(map[string]json.RawMessage, len(m.vars))
+	for _, nv := range m.vars {
+		out[nv.name] = json.RawMessage(nv.v.String())
+	}
+	return out
+}
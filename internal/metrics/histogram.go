@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+)
+
+// Histogram counts observations in fixed buckets. It implements expvar.Var
+// so it can be published next to the other metrics.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []int64 // inclusive upper bound of each bucket; last bucket is +inf
+	counts []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// NewHistogram creates a histogram with the given ascending bucket bounds.
+func NewHistogram(bounds []int64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+		min:    math.MaxInt64,
+	}
+}
+
+// ExponentialBounds returns n bounds starting at start, each factor times
+// the previous.
+func ExponentialBounds(start int64, factor float64, n int) []int64 {
+	bounds := make([]int64, n)
+	v := float64(start)
+	for i := range bounds {
+		bounds[i] = int64(v)
+		v *= factor
+	}
+	return bounds
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Mean returns the average observation, or 0 if empty.
+func (h *Histogram) Mean() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th
+// percentile (0-100), capped at the largest observation.
+func (h *Histogram) Percentile(p float64) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.percentileLocked(p)
+}
+
+func (h *Histogram) percentileLocked(p float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(float64(h.count) * p / 100.0))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i < len(h.bounds) && h.bounds[i] < h.max {
+				return h.bounds[i]
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// String returns a JSON summary, satisfying expvar.Var.
+func (h *Histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	summary := struct {
+		Count int64   `json:"count"`
+		Mean  float64 `json:"mean"`
+		Min   int64   `json:"min"`
+		Max   int64   `json:"max"`
+		P50   int64   `json:"p50"`
+		P99   int64   `json:"p99"`
+	}{Count: h.count, Max: h.max}
+	if h.count > 0 {
+		summary.Mean = float64(h.sum) / float64(h.count)
+		summary.Min = h.min
+		summary.P50 = h.percentileLocked(50)
+		summary.P99 = h.percentileLocked(99)
+	}
+	b, _ := json.Marshal(summary)
+	return string(b)
+}
@@ -1,7 +1,10 @@
 package metrics
 
 import (
+	"encoding/json"
 	"expvar"
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -9,16 +12,18 @@ import (
 // Metrics tracks TinyRocks performance metrics.
 type Metrics struct {
 	// Operations
-	GetCount  *expvar.Int
-	PutCount  *expvar.Int
-	DelCount  *expvar.Int
-	ScanCount *expvar.Int
+	GetCount   *expvar.Int
+	PutCount   *expvar.Int
+	DelCount   *expvar.Int
+	ScanCount  *expvar.Int
+	WriteCount *expvar.Int // WriteBatch writes
 
 	// Latencies (microseconds)
-	GetLatency  *expvar.Float
-	PutLatency  *expvar.Float
-	DelLatency  *expvar.Float
-	ScanLatency *expvar.Float
+	GetLatency   *expvar.Float
+	PutLatency   *expvar.Float
+	DelLatency   *expvar.Float
+	ScanLatency  *expvar.Float
+	WriteLatency *expvar.Float
 
 	// Compaction metrics
 	FlushCount        *expvar.Int
@@ -27,6 +32,14 @@ type Metrics struct {
 	CompactionLatency *expvar.Float
 	BytesFlushed      *expvar.Int
 	BytesCompacted    *expvar.Int
+	BytesCompactRead  *expvar.Int
+	BytesUserWritten  *expvar.Int
+
+	// Disk usage (bytes)
+	DiskSSTBytes  *expvar.Int
+	DiskWALBytes  *expvar.Int
+	DiskVLogBytes *expvar.Int
+	DiskLiveBytes *expvar.Int
 
 	// Level metrics
 	L0Count    *expvar.Int
@@ -37,53 +50,236 @@ type Metrics struct {
 	WALBytes        *expvar.Int
 	WALGroupCommits *expvar.Int
 	WALFsyncLatency *expvar.Float
+	WALThroughput   *RateMeter  // bytes per second
+	WALCommitWindow *expvar.Int // current group commit window, microseconds
+
+	// Write stalls
+	WriteStallMicros *expvar.Int
+
+	// Memtable bloom filter: checks that passed vs. lookups skipped, and
+	// checks that passed for a key the table did not hold
+	MemtableBloomHits           *expvar.Int
+	MemtableBloomMisses         *expvar.Int
+	MemtableBloomFalsePositives *expvar.Int
+
+	// Read path: Gets served by the memtable and the skiplists they consulted
+	MemtableGets          *expvar.Int
+	MemtableTablesTouched *expvar.Int
+
+	// Iterators that crossed Config.BigScanBlocks and stopped filling the
+	// block cache
+	BigScans *expvar.Int
 
-	// Queue depths
+	// Value-log GC: files collected, bytes reclaimed and collections that
+	// failed
+	VLogGCFiles  *expvar.Int
+	VLogGCBytes  *expvar.Int
+	VLogGCErrors *expvar.Int
+
+	// Queue depths and time spent queued (microseconds). Nothing queues
+	// compactions yet; the depth is there for the profiling backlog trigger.
 	FlushQueueDepth      atomic.Int64
 	CompactionQueueDepth atomic.Int64
+	FlushQueueWait       *Histogram
+
+	// Write path shape: records and key+value bytes per write call (a
+	// single Put is a batch of one), and the size of each value written
+	WriteBatchOps   *Histogram
+	WriteBatchBytes *Histogram
+	ValueSizes      *Histogram
 
 	// Cache metrics
 	CacheHits   atomic.Int64
 	CacheMisses atomic.Int64
 	CacheBytes  atomic.Int64
+
+	// Started is when the metrics were created, i.e. when the store opened.
+	Started time.Time
+
+	vars   []namedVar // every expvar above, in Publish order
+	prefix string     // set by Publish
 }
 
-var GlobalMetrics *Metrics
+// namedVar is a metric and the expvar name it is published under.
+type namedVar struct {
+	name string
+	v    expvar.Var
+}
 
-func init() {
-	GlobalMetrics = NewMetrics()
+// registry collects the vars of a Metrics being constructed.
+type registry struct{ vars []namedVar }
+
+func (r *registry) add(name string, v expvar.Var) { r.vars = append(r.vars, namedVar{name, v}) }
+
+func (r *registry) newInt(name string) *expvar.Int {
+	v := new(expvar.Int)
+	r.add(name, v)
+	return v
 }
 
+func (r *registry) newFloat(name string) *expvar.Float {
+	v := new(expvar.Float)
+	r.add(name, v)
+	return v
+}
+
+func (r *registry) newMap(name string) *expvar.Map {
+	v := new(expvar.Map).Init()
+	r.add(name, v)
+	return v
+}
+
+// queueWaitBounds buckets time-in-queue from 100us to ~100s.
+var queueWaitBounds = ExponentialBounds(100, 2, 20)
+
+// Write batches are bucketed from 1 to ~1M records and 16B to ~1GB; values
+// from 1B to ~16MB.
+var (
+	batchOpsBounds   = ExponentialBounds(1, 2, 21)
+	batchBytesBounds = ExponentialBounds(16, 2, 27)
+	valueSizeBounds  = ExponentialBounds(1, 2, 25)
+)
+
+// NewMetrics returns a set of metrics owned by one store. Nothing is
+// published to expvar until Publish is called, so any number of instances
+// can coexist in one process.
 func NewMetrics() *Metrics {
+	r := &registry{}
 	m := &Metrics{
-		GetCount:  expvar.NewInt("ops_get"),
-		PutCount:  expvar.NewInt("ops_put"),
-		DelCount:  expvar.NewInt("ops_del"),
-		ScanCount: expvar.NewInt("ops_scan"),
-
-		GetLatency:  expvar.NewFloat("lat_get_us"),
-		PutLatency:  expvar.NewFloat("lat_put_us"),
-		DelLatency:  expvar.NewFloat("lat_del_us"),
-		ScanLatency: expvar.NewFloat("lat_scan_us"),
-
-		FlushCount:        expvar.NewInt("flush_count"),
-		CompactionCount:   expvar.NewInt("compaction_count"),
-		FlushLatency:      expvar.NewFloat("flush_lat_us"),
-		CompactionLatency: expvar.NewFloat("compaction_lat_us"),
-		BytesFlushed:      expvar.NewInt("bytes_flushed"),
-		BytesCompacted:    expvar.NewInt("bytes_compacted"),
-
-		L0Count:    expvar.NewInt("level0_count"),
-		L0Size:     expvar.NewInt("level0_size_bytes"),
-		LevelSizes: expvar.NewMap("level_sizes"),
-
-		WALBytes:        expvar.NewInt("wal_bytes"),
-		WALGroupCommits: expvar.NewInt("wal_group_commits"),
-		WALFsyncLatency: expvar.NewFloat("wal_fsync_lat_us"),
+		GetCount:   r.newInt("ops_get"),
+		PutCount:   r.newInt("ops_put"),
+		DelCount:   r.newInt("ops_del"),
+		ScanCount:  r.newInt("ops_scan"),
+		WriteCount: r.newInt("ops_write"),
+
+		GetLatency:   r.newFloat("lat_get_us"),
+		PutLatency:   r.newFloat("lat_put_us"),
+		DelLatency:   r.newFloat("lat_del_us"),
+		ScanLatency:  r.newFloat("lat_scan_us"),
+		WriteLatency: r.newFloat("lat_write_us"),
+
+		FlushCount:        r.newInt("flush_count"),
+		CompactionCount:   r.newInt("compaction_count"),
+		FlushLatency:      r.newFloat("flush_lat_us"),
+		CompactionLatency: r.newFloat("compaction_lat_us"),
+		BytesFlushed:      r.newInt("bytes_flushed"),
+		BytesCompacted:    r.newInt("bytes_compacted"),
+		BytesCompactRead:  r.newInt("bytes_compaction_read"),
+		BytesUserWritten:  r.newInt("bytes_user_written"),
+
+		DiskSSTBytes:  r.newInt("disk_sst_bytes"),
+		DiskWALBytes:  r.newInt("disk_wal_bytes"),
+		DiskVLogBytes: r.newInt("disk_vlog_bytes"),
+		DiskLiveBytes: r.newInt("disk_live_bytes"),
+
+		L0Count:    r.newInt("level0_count"),
+		L0Size:     r.newInt("level0_size_bytes"),
+		LevelSizes: r.newMap("level_sizes"),
+
+		WALBytes:        r.newInt("wal_bytes"),
+		WALGroupCommits: r.newInt("wal_group_commits"),
+		WALFsyncLatency: r.newFloat("wal_fsync_lat_us"),
+		WALThroughput:   NewRateMeter(),
+		WALCommitWindow: r.newInt("wal_commit_window_us"),
+
+		WriteStallMicros: r.newInt("write_stall_us"),
+
+		MemtableBloomHits:           r.newInt("memtable_bloom_hits"),
+		MemtableBloomMisses:         r.newInt("memtable_bloom_misses"),
+		MemtableBloomFalsePositives: r.newInt("memtable_bloom_false_positives"),
+
+		MemtableGets:          r.newInt("memtable_gets"),
+		MemtableTablesTouched: r.newInt("memtable_tables_touched"),
+
+		BigScans: r.newInt("big_scans"),
+
+		VLogGCFiles:  r.newInt("vlog_gc_files"),
+		VLogGCBytes:  r.newInt("vlog_gc_bytes"),
+		VLogGCErrors: r.newInt("vlog_gc_errors"),
+
+		FlushQueueWait: NewHistogram(queueWaitBounds),
+
+		WriteBatchOps:   NewHistogram(batchOpsBounds),
+		WriteBatchBytes: NewHistogram(batchBytesBounds),
+		ValueSizes:      NewHistogram(valueSizeBounds),
+
+		Started: time.Now(),
 	}
+	r.add("wal_bytes_per_sec", expvar.Func(func() any { return m.WALThroughput.Rate() }))
+	r.add("flush_queue_depth", expvar.Func(func() any { return m.FlushQueueDepth.Load() }))
+	r.add("compaction_queue_depth", expvar.Func(func() any { return m.CompactionQueueDepth.Load() }))
+	r.add("memtable_tables_per_get", expvar.Func(func() any { return m.MemtablesPerGet() }))
+	r.add("flush_queue_wait_us", m.FlushQueueWait)
+	r.add("write_batch_ops", m.WriteBatchOps)
+	r.add("write_batch_bytes", m.WriteBatchBytes)
+	r.add("value_size_bytes", m.ValueSizes)
+	r.add("uptime_seconds", expvar.Func(func() any { return int64(m.Uptime().Seconds()) }))
+	r.add("build_info", expvar.Func(func() any { return Build() }))
+	m.vars = r.vars
 	return m
 }
 
+// Published names are process-wide and expvar cannot unregister them, so
+// each prefix is registered once with funcs that read whichever Metrics is
+// currently bound to it. Closing a store unbinds it so the prefix can be
+// reused, e.g. when the store is reopened.
+var (
+	publishMu sync.Mutex
+	published = map[string]*Metrics{} // prefix -> bound metrics, nil if unbound
+)
+
+// Publish exposes every metric through expvar as prefix+name, for example
+// "db1_ops_get" for prefix "db1_". It fails if another Metrics is bound to
+// prefix.
+func (m *Metrics) Publish(prefix string) error {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	if cur, ok := published[prefix]; ok {
+		if cur != nil && cur != m {
+			return fmt.Errorf("metrics: prefix %q already published", prefix)
+		}
+		published[prefix] = m
+		m.prefix = prefix
+		return nil
+	}
+	for _, nv := range m.vars {
+		if expvar.Get(prefix+nv.name) != nil {
+			return fmt.Errorf("metrics: %q already published", prefix+nv.name)
+		}
+	}
+	for i, nv := range m.vars {
+		i := i
+		expvar.Publish(prefix+nv.name, expvar.Func(func() any { return boundValue(prefix, i) }))
+	}
+	published[prefix] = m
+	m.prefix = prefix
+	return nil
+}
+
+// Unpublish unbinds m from its prefix; the published names read as null
+// until another Metrics is published under it.
+func (m *Metrics) Unpublish() {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	if published[m.prefix] == m {
+		published[m.prefix] = nil
+	}
+}
+
+// boundValue returns the JSON value of var i of the Metrics bound to prefix.
+func boundValue(prefix string, i int) any {
+	publishMu.Lock()
+	m := published[prefix]
+	publishMu.Unlock()
+	if m == nil {
+		return nil
+	}
+	return json.RawMessage(m.vars[i].v.String())
+}
+
+// Uptime returns how long ago the metrics were created.
+func (m *Metrics) Uptime() time.Duration { return time.Since(m.Started) }
+
 // RecordOp records an operation with latency.
 func (m *Metrics) RecordOp(op string, latency time.Duration) {
 	latencyUs := float64(latency.Microseconds())
@@ -101,6 +297,9 @@ func (m *Metrics) RecordOp(op string, latency time.Duration) {
 	case "scan":
 		m.ScanCount.Add(1)
 		m.ScanLatency.Set(latencyUs)
+	case "write":
+		m.WriteCount.Add(1)
+		m.WriteLatency.Set(latencyUs)
 	}
 }
 
@@ -111,11 +310,106 @@ func (m *Metrics) RecordFlush(latency time.Duration, bytes int64) {
 	m.BytesFlushed.Add(bytes)
 }
 
-// RecordCompaction records a compaction operation.
-func (m *Metrics) RecordCompaction(latency time.Duration, bytes int64) {
+// RecordCompaction records a compaction operation that read bytesRead
+// input bytes and wrote bytesWritten output bytes.
+func (m *Metrics) RecordCompaction(latency time.Duration, bytesRead, bytesWritten int64) {
 	m.CompactionCount.Add(1)
 	m.CompactionLatency.Set(float64(latency.Microseconds()))
-	m.BytesCompacted.Add(bytes)
+	m.BytesCompactRead.Add(bytesRead)
+	m.BytesCompacted.Add(bytesWritten)
+}
+
+// RecordUserWrite records bytes of key and value accepted from the user.
+func (m *Metrics) RecordUserWrite(bytes int64) {
+	m.BytesUserWritten.Add(bytes)
+}
+
+// RecordWriteBatch records the shape of one write call: ops records
+// totalling bytes of key and value.
+func (m *Metrics) RecordWriteBatch(ops int, bytes int64) {
+	m.WriteBatchOps.Observe(int64(ops))
+	m.WriteBatchBytes.Observe(bytes)
+}
+
+// WriteAmplification returns bytes written to disk (WAL, flush and
+// compaction output) per byte written by the user, or 0 before any write.
+func (m *Metrics) WriteAmplification() float64 {
+	user := m.BytesUserWritten.Value()
+	if user == 0 {
+		return 0
+	}
+	disk := m.WALBytes.Value() + m.BytesFlushed.Value() + m.BytesCompacted.Value()
+	return float64(disk) / float64(user)
+}
+
+// SpaceAmplification returns total on-disk bytes per byte of live data, or 0
+// while live data size is unknown.
+func (m *Metrics) SpaceAmplification() float64 {
+	live := m.DiskLiveBytes.Value()
+	if live == 0 {
+		return 0
+	}
+	total := m.DiskSSTBytes.Value() + m.DiskWALBytes.Value() + m.DiskVLogBytes.Value()
+	return float64(total) / float64(live)
+}
+
+// RecordWALWrite records bytes written to the WAL.
+func (m *Metrics) RecordWALWrite(bytes int64) {
+	m.WALBytes.Add(bytes)
+	m.WALThroughput.Add(bytes)
+}
+
+// RecordWALSync records the latency of a WAL fsync.
+func (m *Metrics) RecordWALSync(latency time.Duration) {
+	m.WALFsyncLatency.Set(float64(latency.Microseconds()))
+}
+
+// RecordWALGroupCommit records a group commit of records WAL records.
+func (m *Metrics) RecordWALGroupCommit(records int) {
+	m.WALGroupCommits.Add(1)
+}
+
+// RecordWALWindow records the group commit window chosen by the WAL.
+func (m *Metrics) RecordWALWindow(window time.Duration) {
+	m.WALCommitWindow.Set(window.Microseconds())
+}
+
+// RecordMemtableBloom records a memtable bloom filter check.
+func (m *Metrics) RecordMemtableBloom(mayContain bool) {
+	if mayContain {
+		m.MemtableBloomHits.Add(1)
+	} else {
+		m.MemtableBloomMisses.Add(1)
+	}
+}
+
+// RecordMemtableGet records a memtable Get that consulted tables skiplists,
+// falsePositives of which passed their bloom filter without holding the key.
+func (m *Metrics) RecordMemtableGet(tables, falsePositives int) {
+	m.MemtableGets.Add(1)
+	m.MemtableTablesTouched.Add(int64(tables))
+	m.MemtableBloomFalsePositives.Add(int64(falsePositives))
+}
+
+// MemtablesPerGet returns the mean number of memtables consulted per Get, or
+// 0 before any Get.
+func (m *Metrics) MemtablesPerGet() float64 {
+	gets := m.MemtableGets.Value()
+	if gets == 0 {
+		return 0
+	}
+	return float64(m.MemtableTablesTouched.Value()) / float64(gets)
+}
+
+// RecordFlushQueued records a flush job entering the flush queue.
+func (m *Metrics) RecordFlushQueued() {
+	m.FlushQueueDepth.Add(1)
+}
+
+// RecordFlushDequeued records a flush job leaving the queue after wait.
+func (m *Metrics) RecordFlushDequeued(wait time.Duration) {
+	m.FlushQueueDepth.Add(-1)
+	m.FlushQueueWait.Observe(wait.Microseconds())
 }
 
 // RecordCacheHit records a cache hit.
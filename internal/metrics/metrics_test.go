@@ -1,18 +1,20 @@
 package metrics
 
 import (
+	"expvar"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestMetrics(t *testing.T) {
-	// Use global metrics instance
-	m := GlobalMetrics
+	m := NewMetrics()
 
 	// Test operation recording
 	m.RecordOp("get", 100*time.Microsecond)
 	m.RecordOp("put", 150*time.Microsecond)
 	m.RecordOp("del", 120*time.Microsecond)
+	m.RecordOp("write", 200*time.Microsecond)
 
 	if m.GetCount.String() != "1" {
 		t.Errorf("Expected GetCount=1, got %s", m.GetCount.String())
@@ -23,6 +25,9 @@ func TestMetrics(t *testing.T) {
 	if m.DelCount.String() != "1" {
 		t.Errorf("Expected DelCount=1, got %s", m.DelCount.String())
 	}
+	if m.WriteCount.String() != "1" || m.PutCount.String() != "1" {
+		t.Errorf("Expected WriteCount=1 apart from puts, got %s", m.WriteCount.String())
+	}
 }
 
 func TestConfig(t *testing.T) {
@@ -35,3 +40,169 @@ func TestConfig(t *testing.T) {
 		t.Errorf("Expected BlockSizeKB=16, got %d", cfg.BlockSizeKB)
 	}
 }
+
+func TestAmplification(t *testing.T) {
+	m := NewMetrics()
+
+	if m.SpaceAmplification() != 0 {
+		t.Errorf("Expected SpaceAmplification=0 with unknown live bytes")
+	}
+
+	m.RecordUserWrite(100)
+	m.WALBytes.Add(120)
+	m.RecordFlush(time.Millisecond, 100)
+	m.RecordCompaction(time.Millisecond, 100, 80)
+
+	if wa := m.WriteAmplification(); wa != 3.0 {
+		t.Errorf("Expected WriteAmplification=3.0, got %.2f", wa)
+	}
+
+	m.DiskLiveBytes.Set(100)
+	m.DiskSSTBytes.Set(150)
+	m.DiskWALBytes.Set(50)
+	if sa := m.SpaceAmplification(); sa != 2.0 {
+		t.Errorf("Expected SpaceAmplification=2.0, got %.2f", sa)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(ExponentialBounds(1, 2, 10))
+	for i := int64(1); i <= 100; i++ {
+		h.Observe(i)
+	}
+
+	if h.Count() != 100 {
+		t.Errorf("Expected Count=100, got %d", h.Count())
+	}
+	if h.Mean() != 50.5 {
+		t.Errorf("Expected Mean=50.5, got %.2f", h.Mean())
+	}
+	// Bucket bounds are 1,2,4,...,512; the 50th value falls in (32,64].
+	if p50 := h.Percentile(50); p50 != 64 {
+		t.Errorf("Expected P50 bucket bound 64, got %d", p50)
+	}
+	if p100 := h.Percentile(100); p100 != 100 {
+		t.Errorf("Expected P100 capped at max 100, got %d", p100)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	m := NewMetrics()
+
+	m.RecordFlushQueued()
+	m.RecordFlushQueued()
+	m.RecordFlushDequeued(2 * time.Millisecond)
+
+	if d := m.FlushQueueDepth.Load(); d != 1 {
+		t.Errorf("Expected FlushQueueDepth=1, got %d", d)
+	}
+	if c := m.FlushQueueWait.Count(); c != 1 {
+		t.Errorf("Expected 1 queue wait sample, got %d", c)
+	}
+	m.RecordFlushDequeued(0)
+}
+
+func TestWriteBatchHistograms(t *testing.T) {
+	m := NewMetrics()
+	m.RecordWriteBatch(1, 100)
+	m.RecordWriteBatch(1000, 64<<10)
+
+	if c := m.WriteBatchOps.Count(); c != 2 {
+		t.Errorf("Expected 2 batch samples, got %d", c)
+	}
+	if p := m.WriteBatchOps.Percentile(100); p != 1000 {
+		t.Errorf("Expected largest batch of 1000 ops, got %d", p)
+	}
+	if p := m.WriteBatchBytes.Percentile(50); p != 128 {
+		t.Errorf("Expected P50 batch bucket bound 128, got %d", p)
+	}
+}
+
+func TestRateMeter(t *testing.T) {
+	r := NewRateMeter()
+	now := time.Unix(100, 0)
+	r.now = func() time.Time { return now }
+
+	r.Add(10)
+	r.Add(5)
+	if got := r.Rate(); got != 0 {
+		t.Errorf("Expected Rate=0 within the first second, got %d", got)
+	}
+
+	now = now.Add(time.Second)
+	if got := r.Rate(); got != 15 {
+		t.Errorf("Expected Rate=15 for the previous second, got %d", got)
+	}
+
+	now = now.Add(3 * time.Second)
+	if got := r.Rate(); got != 0 {
+		t.Errorf("Expected Rate=0 after an idle gap, got %d", got)
+	}
+}
+
+func TestWALObserver(t *testing.T) {
+	m := NewMetrics()
+	before := m.WALBytes.Value()
+
+	m.RecordWALWrite(64)
+	m.RecordWALSync(250 * time.Microsecond)
+
+	if got := m.WALBytes.Value() - before; got != 64 {
+		t.Errorf("Expected WALBytes +64, got %d", got)
+	}
+	if got := m.WALFsyncLatency.Value(); got != 250 {
+		t.Errorf("Expected WALFsyncLatency=250, got %.0f", got)
+	}
+}
+
+func TestMetricsPublish(t *testing.T) {
+	a, b := NewMetrics(), NewMetrics()
+	if err := a.Publish("test_publish_"); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := b.Publish("test_publish_"); err == nil {
+		t.Fatalf("expected error publishing a bound prefix")
+	}
+	a.PutCount.Add(3)
+	if v := expvar.Get("test_publish_ops_put").String(); v != "3" {
+		t.Fatalf("published %s, want 3", v)
+	}
+
+	a.Unpublish()
+	if v := expvar.Get("test_publish_ops_put").String(); v != "null" {
+		t.Fatalf("unbound prefix reads %s, want null", v)
+	}
+	if err := b.Publish("test_publish_"); err != nil {
+		t.Fatalf("republish: %v", err)
+	}
+	if v := expvar.Get("test_publish_ops_put").String(); v != "0" {
+		t.Fatalf("rebound prefix reads %s, want 0", v)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := NewMetrics()
+	m.PutCount.Add(3)
+	snap := m.Snapshot()
+	if got := string(snap["ops_put"]); got != "3" {
+		t.Fatalf("ops_put %s, want 3", got)
+	}
+	if _, ok := snap["uptime_seconds"]; !ok {
+		t.Fatalf("snapshot misses uptime_seconds")
+	}
+}
+
+func TestDumpWAL(t *testing.T) {
+	m := NewMetrics()
+	now := time.Unix(100, 0)
+	m.WALThroughput.now = func() time.Time { return now }
+	m.RecordWALWrite(4096)
+	m.RecordWALSync(250 * time.Microsecond)
+	now = now.Add(time.Second)
+
+	var b strings.Builder
+	m.Dump(&b)
+	if !strings.Contains(b.String(), "wal: bytes=4096 bytes/s=4096 group_commits=0 fsync_us=250\n") {
+		t.Fatalf("dump misses the WAL line:\n%s", b.String())
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// RateMeter reports how much was added during the last complete second.
+type RateMeter struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	sec     int64 // unix second being accumulated
+	current int64 // amount added during sec
+	last    int64 // amount added during sec-1
+}
+
+// NewRateMeter creates a meter using the wall clock.
+func NewRateMeter() *RateMeter {
+	return &RateMeter{now: time.Now}
+}
+
+// Add records n units at the current time.
+func (r *RateMeter) Add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roll()
+	r.current += n
+}
+
+// Rate returns the units added during the previous full second.
+func (r *RateMeter) Rate() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roll()
+	return r.last
+}
+
+// roll advances the window to the current second. Callers hold r.mu.
+func (r *RateMeter) roll() {
+	sec := r.now().Unix()
+	switch {
+	case sec == r.sec:
+		return
+	case sec == r.sec+1:
+		r.last = r.current
+	default:
+		r.last = 0
+	}
+	r.sec = sec
+	r.current = 0
+}
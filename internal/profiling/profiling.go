@@ -0,0 +1,180 @@
+package profiling
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/clock"
+	"github.com/arthurzhang/kivi/internal/metrics"
+)
+
+// Serve starts a pprof HTTP endpoint on addr and returns the server. The
+// server's Addr holds the bound address, which matters for ":0". routes
+// adds handlers by pattern beside the pprof ones.
+func Serve(addr string, routes map[string]http.Handler) (*http.Server, error) {
+	mux := http.NewServeMux()
+	for pattern, h := range routes {
+		mux.Handle(pattern, h)
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// CaptureHeap writes a heap profile into dir and returns its path.
+func CaptureHeap(dir, reason string) (string, error) {
+	path, f, err := create(dir, "heap", reason)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(f); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// CaptureCPU records a CPU profile for d into dir and returns its path.
+func CaptureCPU(dir, reason string, d time.Duration) (string, error) {
+	path, f, err := create(dir, "cpu", reason)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := rpprof.StartCPUProfile(f); err != nil {
+		return "", err
+	}
+	time.Sleep(d)
+	rpprof.StopCPUProfile()
+	return path, nil
+}
+
+func create(dir, kind, reason string) (string, *os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, err
+	}
+	name := fmt.Sprintf("%s-%s-%s.pprof", kind, reason, time.Now().Format("20060102-150405.000"))
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, f, nil
+}
+
+// WatcherOptions configure automatic profile capture.
+type WatcherOptions struct {
+	Dir              string        // where profiles are written
+	Interval         time.Duration // how often metrics are sampled
+	StallThreshold   time.Duration // stall time per interval that triggers capture
+	BacklogThreshold int64         // compaction queue depth that triggers capture
+	CPUDuration      time.Duration // length of captured CPU profiles
+	Cooldown         time.Duration // minimum time between captures
+	Clock            clock.Clock   // drives sampling; defaults to the wall clock
+}
+
+// Watcher samples metrics and captures CPU and heap profiles when write
+// stalls or the compaction backlog cross their thresholds.
+type Watcher struct {
+	m    *metrics.Metrics
+	opts WatcherOptions
+
+	lastStall   int64
+	lastCapture time.Time
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewWatcher creates a watcher over m. Zero thresholds disable that trigger.
+func NewWatcher(m *metrics.Metrics, opts WatcherOptions) *Watcher {
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	if opts.CPUDuration == 0 {
+		opts.CPUDuration = 5 * time.Second
+	}
+	if opts.Cooldown == 0 {
+		opts.Cooldown = time.Minute
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Watcher{m: m, opts: opts, stopCh: make(chan struct{})}
+}
+
+// Start begins sampling in the background.
+func (w *Watcher) Start() {
+	w.lastStall = w.m.WriteStallMicros.Value()
+	w.wg.Add(1)
+	go w.loop()
+}
+
+// Stop stops sampling and waits for any capture in progress.
+func (w *Watcher) Stop() {
+	close(w.stopCh)
+	w.wg.Wait()
+}
+
+func (w *Watcher) loop() {
+	defer w.wg.Done()
+
+	ticker := w.opts.Clock.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C():
+			if reason := w.check(); reason != "" {
+				w.capture(reason)
+			}
+		}
+	}
+}
+
+// check returns the trigger that fired since the last sample, if any.
+func (w *Watcher) check() string {
+	stall := w.m.WriteStallMicros.Value()
+	delta := time.Duration(stall-w.lastStall) * time.Microsecond
+	w.lastStall = stall
+
+	if !w.lastCapture.IsZero() && w.opts.Clock.Now().Sub(w.lastCapture) < w.opts.Cooldown {
+		return ""
+	}
+	if w.opts.StallThreshold > 0 && delta >= w.opts.StallThreshold {
+		return "stall"
+	}
+	if w.opts.BacklogThreshold > 0 && w.m.CompactionQueueDepth.Load() >= w.opts.BacklogThreshold {
+		return "backlog"
+	}
+	return ""
+}
+
+func (w *Watcher) capture(reason string) {
+	w.lastCapture = w.opts.Clock.Now()
+	_, _ = CaptureHeap(w.opts.Dir, reason)
+	_, _ = CaptureCPU(w.opts.Dir, reason, w.opts.CPUDuration)
+}
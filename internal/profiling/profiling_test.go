@@ -0,0 +1,69 @@
+package profiling
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func TestServe(t *testing.T) {
+	srv, err := Serve("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + srv.Addr + "/debug/pprof/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestCaptureHeap(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	path, err := CaptureHeap(filepath.Join(dir, "profiles"), "test")
+	if err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	if st, err := os.Stat(path); err != nil || st.Size() == 0 {
+		t.Fatalf("expected non-empty profile at %s: %v", path, err)
+	}
+}
+
+func TestWatcherStallTrigger(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	m := metrics.NewMetrics()
+	w := NewWatcher(m, WatcherOptions{
+		Dir:            dir,
+		Interval:       10 * time.Millisecond,
+		StallThreshold: 50 * time.Millisecond,
+		CPUDuration:    10 * time.Millisecond,
+	})
+	w.Start()
+	defer w.Stop()
+
+	m.WriteStallMicros.Add((100 * time.Millisecond).Microseconds())
+
+	err := testutil.WaitFor(func() bool {
+		entries, _ := os.ReadDir(dir)
+		return len(entries) >= 2
+	}, 2*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected heap and cpu profiles after stall")
+	}
+}
@@ -0,0 +1,226 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Block format:
+//
+//	[entry] ... [restart:4] ... [num_restarts:4]
+//	entry: [shared:uvarint][unshared:uvarint][val_len:uvarint][key suffix][val]
+//
+// Each key is stored as the length of the prefix it shares with the previous
+// key plus the remaining suffix. Every restartInterval entries the prefix is
+// reset (shared = 0) and the entry's offset is recorded as a restart point,
+// so a seek can binary search the restart points and then scan at most
+// restartInterval entries.
+
+// blockBuilder accumulates sorted entries into an encoded block.
+type blockBuilder struct {
+	restartInterval int
+
+	buf      []byte
+	restarts []uint32
+	counter  int // entries since the last restart
+	entries  int
+	lastKey  []byte
+}
+
+func newBlockBuilder(restartInterval int) blockBuilder {
+	if restartInterval <= 0 {
+		restartInterval = 1
+	}
+	return blockBuilder{restartInterval: restartInterval, restarts: []uint32{0}}
+}
+
+func (b *blockBuilder) add(key, val []byte) {
+	shared := 0
+	if b.counter < b.restartInterval {
+		for shared < len(key) && shared < len(b.lastKey) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+		b.counter = 0
+	}
+
+	b.buf = binary.AppendUvarint(b.buf, uint64(shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(key)-shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(val)))
+	b.buf = append(b.buf, key[shared:]...)
+	b.buf = append(b.buf, val...)
+
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.counter++
+	b.entries++
+}
+
+// estimatedSize returns the size of the block if finished now.
+func (b *blockBuilder) estimatedSize() int { return len(b.buf) + 4*len(b.restarts) + 4 }
+
+func (b *blockBuilder) empty() bool { return b.entries == 0 }
+
+// finish appends the restart array and returns the encoded block. The
+// builder must be reset before reuse.
+func (b *blockBuilder) finish() []byte {
+	for _, r := range b.restarts {
+		b.buf = binary.BigEndian.AppendUint32(b.buf, r)
+	}
+	b.buf = binary.BigEndian.AppendUint32(b.buf, uint32(len(b.restarts)))
+	return b.buf
+}
+
+func (b *blockBuilder) reset() {
+	b.buf = b.buf[:0]
+	b.restarts = append(b.restarts[:0], 0)
+	b.counter = 0
+	b.entries = 0
+	b.lastKey = b.lastKey[:0]
+}
+
+// blockIter iterates over the entries of an encoded block.
+type blockIter struct {
+	data     []byte // entries, restart array stripped
+	restarts []byte // encoded restart offsets
+	off      int    // offset of the next entry
+	key      []byte // owned; rebuilt from shared prefixes
+	val      []byte
+	valid    bool
+	err      error
+}
+
+func newBlockIter(block []byte) (*blockIter, error) {
+	it := &blockIter{}
+	if err := it.init(block); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// init points the iterator at a new block, keeping its key buffer so one
+// iterator can be reused across blocks without allocating.
+func (it *blockIter) init(block []byte) error {
+	it.release()
+	if len(block) < 4 {
+		return errBadBlock
+	}
+	n := uint64(binary.BigEndian.Uint32(block[len(block)-4:]))
+	if n == 0 || 4*n+4 > uint64(len(block)) {
+		return errBadBlock
+	}
+	start := len(block) - 4 - 4*int(n)
+	it.data = block[:start]
+	it.restarts = block[start : len(block)-4]
+	return nil
+}
+
+// release drops the iterator's references to its block.
+func (it *blockIter) release() {
+	it.data, it.restarts, it.val = nil, nil, nil
+	it.key = it.key[:0]
+	it.off = 0
+	it.valid = false
+	it.err = nil
+}
+
+// decodeEntry parses the entry header at off, returning the shared and
+// unshared key lengths, the value length and the header size.
+func (it *blockIter) decodeEntry(off int) (shared, unshared, vlen uint64, n int, ok bool) {
+	rest := it.data[off:]
+	var k int
+	if shared, k = binary.Uvarint(rest); k <= 0 {
+		return 0, 0, 0, 0, false
+	}
+	n += k
+	if unshared, k = binary.Uvarint(rest[n:]); k <= 0 {
+		return 0, 0, 0, 0, false
+	}
+	n += k
+	if vlen, k = binary.Uvarint(rest[n:]); k <= 0 {
+		return 0, 0, 0, 0, false
+	}
+	n += k
+	if unshared > uint64(len(rest)-n) || vlen > uint64(len(rest)-n)-unshared {
+		return 0, 0, 0, 0, false
+	}
+	return shared, unshared, vlen, n, true
+}
+
+// next decodes the entry at off and reports whether there was one.
+func (it *blockIter) next() bool {
+	if it.err != nil || it.off >= len(it.data) {
+		it.valid = false
+		return false
+	}
+	shared, unshared, vlen, n, ok := it.decodeEntry(it.off)
+	if !ok || shared > uint64(len(it.key)) {
+		it.err = errBadBlock
+		it.valid = false
+		return false
+	}
+	p := it.off + n
+	it.key = append(it.key[:shared], it.data[p:p+int(unshared)]...)
+	p += int(unshared)
+	it.val = it.data[p : p+int(vlen)]
+	it.off = p + int(vlen)
+	it.valid = true
+	return true
+}
+
+// numRestarts returns the number of restart points.
+func (it *blockIter) numRestarts() int { return len(it.restarts) / 4 }
+
+// restartKey returns the full key stored at restart point i.
+func (it *blockIter) restartKey(i int) ([]byte, bool) {
+	off := int(binary.BigEndian.Uint32(it.restarts[4*i:]))
+	if off >= len(it.data) {
+		return nil, false
+	}
+	shared, unshared, _, n, ok := it.decodeEntry(off)
+	if !ok || shared != 0 {
+		return nil, false
+	}
+	return it.data[off+n : off+n+int(unshared)], true
+}
+
+// seekGE positions the iterator on the first key >= target and reports
+// whether there is one.
+func (it *blockIter) seekGE(target []byte) bool {
+	if it.err != nil || len(it.data) == 0 {
+		it.valid = false
+		return false
+	}
+	// Find the last restart point whose key is < target.
+	lo, hi := 0, it.numRestarts()-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		key, ok := it.restartKey(mid)
+		if !ok {
+			it.err = errBadBlock
+			it.valid = false
+			return false
+		}
+		if bytes.Compare(key, target) < 0 {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	it.off = int(binary.BigEndian.Uint32(it.restarts[4*lo:]))
+	it.key = it.key[:0]
+	for it.next() {
+		if bytes.Compare(it.key, target) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// first positions the iterator on the first entry.
+func (it *blockIter) first() bool {
+	it.off = 0
+	it.key = it.key[:0]
+	return it.next()
+}
@@ -0,0 +1,104 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func buildBlock(interval, n int) []byte {
+	b := newBlockBuilder(interval)
+	for i := 0; i < n; i++ {
+		b.add([]byte(fmt.Sprintf("key%06d", i*2)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	return append([]byte(nil), b.finish()...)
+}
+
+func TestBlockSeekAcrossRestarts(t *testing.T) {
+	for _, interval := range []int{1, 3, 16} {
+		block := buildBlock(interval, 100)
+		it, err := newBlockIter(block)
+		if err != nil {
+			t.Fatalf("interval %d: %v", interval, err)
+		}
+		for i := 0; i < 100; i++ {
+			want := fmt.Sprintf("key%06d", i*2)
+			// Exact key and the gap just before it both land on want.
+			for _, target := range []string{want, fmt.Sprintf("key%06d", i*2-1)} {
+				if !it.seekGE([]byte(target)) || string(it.key) != want {
+					t.Fatalf("interval %d: seek %s landed on %q", interval, target, it.key)
+				}
+			}
+		}
+		if it.seekGE([]byte("key999999")) {
+			t.Fatalf("interval %d: seek past the end found %q", interval, it.key)
+		}
+
+		n := 0
+		for ok := it.first(); ok; ok = it.next() {
+			n++
+		}
+		if n != 100 || it.err != nil {
+			t.Fatalf("interval %d: scanned %d entries, err=%v", interval, n, it.err)
+		}
+	}
+}
+
+func TestBlockPrefixCompression(t *testing.T) {
+	plain, compressed := len(buildBlock(1, 1000)), len(buildBlock(16, 1000))
+	if compressed >= plain {
+		t.Fatalf("restart interval 16 gave %d bytes, interval 1 gave %d", compressed, plain)
+	}
+	t.Logf("1000 sequential keys: %d bytes with interval 16, %d with interval 1", compressed, plain)
+}
+
+func TestBlockCorrupt(t *testing.T) {
+	block := buildBlock(16, 10)
+	binary.BigEndian.PutUint32(block[len(block)-4:], 1<<30)
+	if _, err := newBlockIter(block); err != errBadBlock {
+		t.Fatalf("expected errBadBlock for bad restart count, got %v", err)
+	}
+
+	block = buildBlock(16, 10)
+	block[0] = 0x05 // first entry claims a shared prefix
+	it, _ := newBlockIter(block)
+	if it.first() || it.err != errBadBlock {
+		t.Fatalf("expected errBadBlock for bad shared length, got %v", it.err)
+	}
+}
+
+func BenchmarkBlockSeek(b *testing.B) {
+	block := buildBlock(16, 1000)
+	it, _ := newBlockIter(block)
+	targets := make([][]byte, 1000)
+	for i := range targets {
+		targets[i] = []byte(fmt.Sprintf("key%06d", i*2))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it.seekGE(targets[i%len(targets)])
+	}
+}
+
+func BenchmarkBlockBuild(b *testing.B) {
+	for _, size := range []int{16, 256, 4 << 10} {
+		b.Run(fmt.Sprintf("value=%d", size), func(b *testing.B) {
+			keys := make([][]byte, 1000)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("key%06d", i))
+			}
+			val := make([]byte, size)
+			bb := newBlockBuilder(16)
+			b.SetBytes(int64(len(keys) * (9 + size)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bb.reset()
+				for _, k := range keys {
+					bb.add(k, val)
+				}
+				bb.finish()
+			}
+		})
+	}
+}
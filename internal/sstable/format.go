@@ -0,0 +1,113 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+)
+
+// Table layout:
+//
+//	[data block 1] ... [data block n] [index block] [footer]
+//
+// Every block is followed by a trailer holding the CRC32 of its contents.
+// A block handle's Size excludes the trailer. The footer also holds a
+// CRC-64 digest of every byte before it, so a whole file can be verified
+// with one sequential read.
+//
+// Every index entry maps a key >= the last key of a data block (and < the
+// first key of the next one) to that block's handle.
+
+// blockHandle locates a block inside a table file.
+type blockHandle struct {
+	Offset uint64
+	Size   uint64
+}
+
+// blockHandleSize is the encoded size of a blockHandle.
+// Format: [offset:8][size:8]
+const blockHandleSize = 8 + 8
+
+func (h blockHandle) encode() []byte {
+	buf := make([]byte, blockHandleSize)
+	binary.BigEndian.PutUint64(buf[0:8], h.Offset)
+	binary.BigEndian.PutUint64(buf[8:16], h.Size)
+	return buf
+}
+
+func decodeBlockHandle(buf []byte) (blockHandle, error) {
+	if len(buf) != blockHandleSize {
+		return blockHandle{}, errBadHandle
+	}
+	return blockHandle{
+		Offset: binary.BigEndian.Uint64(buf[0:8]),
+		Size:   binary.BigEndian.Uint64(buf[8:16]),
+	}, nil
+}
+
+// blockTrailerSize is the size of the checksum after every block.
+const blockTrailerSize = 4
+
+// footerSize is the encoded size of the footer.
+// Format: [index_handle:16][digest:8][magic:8]
+const footerSize = blockHandleSize + 8 + 8
+
+// tableMagic ends every table.
+const tableMagic = 0x74696e79726f6332 // "tinyroc2"
+
+var digestTable = crc64.MakeTable(crc64.ECMA)
+
+// footer is the decoded footer of a table.
+type footer struct {
+	index  blockHandle
+	digest uint64
+}
+
+// readFooter reads the footer of the table of the given size. Format
+// problems are reported as errShortFile, errBadMagic or errBadHandle.
+func readFooter(r io.ReaderAt, size int64) (footer, error) {
+	if size < footerSize {
+		return footer{}, errShortFile
+	}
+	buf := make([]byte, footerSize)
+	if _, err := r.ReadAt(buf, size-footerSize); err != nil {
+		return footer{}, err
+	}
+	if binary.BigEndian.Uint64(buf[footerSize-8:]) != tableMagic {
+		return footer{}, errBadMagic
+	}
+	f := footer{digest: binary.BigEndian.Uint64(buf[blockHandleSize:])}
+	var err error
+	f.index, err = decodeBlockHandle(buf[:blockHandleSize])
+	return f, err
+}
+
+// ErrCorruption is matched by every checksum or format error found while
+// reading a table; use errors.Is. The concrete error is a *CorruptionError.
+var ErrCorruption = errors.New("sstable: corruption")
+
+// CorruptionError reports where corruption was found.
+type CorruptionError struct {
+	File   string
+	Offset uint64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("sstable: corruption in %s at offset %d: %s", e.File, e.Offset, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrCorruption) report true.
+func (e *CorruptionError) Is(target error) bool { return target == ErrCorruption }
+
+var (
+	errBadHandle  = errors.New("sstable: invalid block handle")
+	errBadMagic   = errors.New("sstable: bad magic number")
+	errBadBlock   = errors.New("sstable: malformed block")
+	errOutOfOrder = errors.New("sstable: keys added out of order")
+	errFinished   = errors.New("sstable: writer already finished")
+	errNoBlock    = errors.New("sstable: no data block at offset")
+	errShortFile  = errors.New("sstable: file too short")
+)
@@ -0,0 +1,41 @@
+package sstable
+
+// Separator returns a short key k with a <= k < b, for use as the index key
+// of a block whose last key is a when the next block starts at b. It returns
+// a itself when no shorter key exists. a must be less than b.
+//
+// For example, the separator of "the quick" and "the who" is "the r".
+func Separator(a, b []byte) []byte {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	if n >= len(a) || n >= len(b) {
+		// One key is a prefix of the other; nothing to shorten.
+		return a
+	}
+	c := a[n]
+	if c < 0xff && c+1 < b[n] && n+1 < len(a) {
+		sep := make([]byte, n+1)
+		copy(sep, a[:n])
+		sep[n] = c + 1
+		return sep
+	}
+	return a
+}
+
+// Successor returns a short key k >= a, for use as the index key of the last
+// block in a table. It returns a itself when no shorter key exists.
+//
+// For example, the successor of "abc" is "b".
+func Successor(a []byte) []byte {
+	for i, c := range a {
+		if c != 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, a[:i])
+			succ[i] = c + 1
+			return succ
+		}
+	}
+	return a
+}
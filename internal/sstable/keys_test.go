@@ -0,0 +1,40 @@
+package sstable
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeparator(t *testing.T) {
+	cases := []struct{ a, b, want string }{
+		{"the quick", "the who", "the r"},
+		{"abc", "abd", "abc"},        // differing byte cannot be bumped below b
+		{"abc", "abcd", "abc"},       // prefix
+		{"ab\xffz", "ac", "ab\xffz"}, // 0xff cannot be incremented
+		{"a", "z", "a"},              // already minimal
+		{"apple", "cherry", "b"},
+	}
+	for _, c := range cases {
+		got := Separator([]byte(c.a), []byte(c.b))
+		if string(got) != c.want {
+			t.Errorf("Separator(%q, %q) = %q, want %q", c.a, c.b, got, c.want)
+		}
+		if bytes.Compare(got, []byte(c.a)) < 0 || bytes.Compare(got, []byte(c.b)) >= 0 {
+			t.Errorf("Separator(%q, %q) = %q outside [a, b)", c.a, c.b, got)
+		}
+	}
+}
+
+func TestSuccessor(t *testing.T) {
+	cases := []struct{ a, want string }{
+		{"abc", "b"},
+		{"\xff\x01", "\xff\x02"},
+		{"\xff\xff", "\xff\xff"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := Successor([]byte(c.a)); string(got) != c.want {
+			t.Errorf("Successor(%q) = %q, want %q", c.a, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package sstable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"os"
+
+	"github.com/arthurzhang/kivi/internal/cache"
+)
+
+// ReaderOptions configure a Reader.
+type ReaderOptions struct {
+	// Name identifies the table in corruption errors, usually its path.
+	Name string
+	// Paranoid verifies the checksum of every block read, regardless of
+	// ReadOptions.
+	Paranoid bool
+	// Cache, if set, holds data blocks across reads, keyed by FileNum and
+	// block offset. FileNum must be unique among tables sharing a cache.
+	Cache   *cache.Cache
+	FileNum uint64
+}
+
+// ReadOptions control a single read.
+type ReadOptions struct {
+	// VerifyChecksums verifies the checksum of every block read.
+	VerifyChecksums bool
+	// DontFillCache reads blocks missing from the cache without inserting
+	// them, so a large scan does not evict the working set.
+	DontFillCache bool
+}
+
+// Reader reads a table written by Writer.
+type Reader struct {
+	r       io.ReaderAt
+	options ReaderOptions
+	index   []byte // encoded index block
+	indexAt uint64 // offset of the index block
+	footer  footer
+}
+
+// NewReader opens the table of the given size read through r. The index
+// block is always verified.
+func NewReader(r io.ReaderAt, size int64, opts ReaderOptions) (*Reader, error) {
+	t := &Reader{r: r, options: opts}
+	f, err := readFooter(r, size)
+	switch err {
+	case nil:
+	case errShortFile, errBadMagic, errBadHandle:
+		return nil, t.corruption(uint64(max(size-footerSize, 0)), err.Error())
+	default:
+		return nil, err
+	}
+	t.footer = f
+	h := f.index
+	if size < footerSize+blockTrailerSize {
+		return nil, t.corruption(0, errShortFile.Error())
+	}
+	limit := uint64(size - footerSize - blockTrailerSize)
+	if h.Size > limit || h.Offset > limit-h.Size {
+		return nil, t.corruption(uint64(size-footerSize), errBadHandle.Error())
+	}
+
+	if t.index, err = t.readBlock(h, true, true); err != nil {
+		return nil, err
+	}
+	t.indexAt = h.Offset
+	return t, nil
+}
+
+// Get returns the value stored for key.
+func (t *Reader) Get(key []byte, opts ReadOptions) ([]byte, bool, error) {
+	it := t.NewIterator(opts)
+	it.SeekGE(key)
+	if err := it.Err(); err != nil {
+		return nil, false, err
+	}
+	if !it.Valid() || !bytes.Equal(it.Key(), key) {
+		return nil, false, nil
+	}
+	return append([]byte(nil), it.Value()...), true, nil
+}
+
+// NewIterator returns an iterator over the table. Call SeekGE before use.
+// Data blocks are loaded lazily as the iterator reaches them.
+func (t *Reader) NewIterator(opts ReadOptions) *Iterator {
+	it := &Iterator{t: t, verify: opts.VerifyChecksums || t.options.Paranoid, fill: !opts.DontFillCache}
+	it.err = it.index.init(t.index)
+	return it
+}
+
+// Digest returns the digest stored in the table's footer.
+func (t *Reader) Digest() uint64 { return t.footer.digest }
+
+// VerifyFile checks the table at path against the digest in its footer
+// with one sequential read, without parsing its blocks.
+func VerifyFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ft, err := readFooter(f, st.Size())
+	if err != nil {
+		return &CorruptionError{File: path, Reason: err.Error()}
+	}
+	h := crc64.New(digestTable)
+	if _, err := io.Copy(h, bufio.NewReader(io.NewSectionReader(f, 0, st.Size()-footerSize))); err != nil {
+		return err
+	}
+	if h.Sum64() != ft.digest {
+		return &CorruptionError{File: path, Reason: "file digest mismatch"}
+	}
+	return nil
+}
+
+// IndexKeys returns the index separators of the table in order, one per
+// data block: each is >= every key of its block and < every key of the
+// next. They make good split points for dividing the table's key range.
+func (t *Reader) IndexKeys() ([][]byte, error) {
+	var idx blockIter
+	if err := idx.init(t.index); err != nil {
+		return nil, err
+	}
+	defer idx.release()
+	var keys [][]byte
+	for ok := idx.first(); ok; ok = idx.next() {
+		keys = append(keys, append([]byte(nil), idx.key...))
+	}
+	return keys, idx.err
+}
+
+// LoadBlock reads the data block starting at offset into the block cache,
+// verifying its checksum. It is used to warm the cache after a restart.
+// The index block was cached when the table was opened.
+func (t *Reader) LoadBlock(offset uint64) error {
+	if offset == t.indexAt {
+		return nil
+	}
+	var idx blockIter
+	if err := idx.init(t.index); err != nil {
+		return err
+	}
+	defer idx.release()
+	for ok := idx.first(); ok; ok = idx.next() {
+		h, err := decodeBlockHandle(idx.val)
+		if err != nil {
+			return err
+		}
+		if h.Offset == offset {
+			_, err := t.readBlock(h, true, true)
+			return err
+		}
+	}
+	if idx.err != nil {
+		return idx.err
+	}
+	return errNoBlock
+}
+
+// readBlock returns the block at h, from the cache if possible, checking
+// its trailer if verify is set. Blocks read from the file are cached if
+// fill is set.
+func (t *Reader) readBlock(h blockHandle, verify, fill bool) ([]byte, error) {
+	key := cache.Key{FileNum: t.options.FileNum, Offset: h.Offset}
+	buf, cached := []byte(nil), false
+	if t.options.Cache != nil {
+		buf, cached = t.options.Cache.Get(key)
+	}
+	if !cached {
+		buf = make([]byte, h.Size+blockTrailerSize)
+		if _, err := t.r.ReadAt(buf, int64(h.Offset)); err != nil {
+			return nil, err
+		}
+	}
+	// Cached buffers keep their trailer so they can be verified too.
+	block := buf[:h.Size]
+	if verify && crc32.ChecksumIEEE(block) != binary.BigEndian.Uint32(buf[h.Size:]) {
+		return nil, t.corruption(h.Offset, "block checksum mismatch")
+	}
+	if !cached && fill && t.options.Cache != nil {
+		t.options.Cache.Set(key, buf)
+	}
+	return block, nil
+}
+
+func (t *Reader) corruption(offset uint64, reason string) error {
+	return &CorruptionError{File: t.options.Name, Offset: offset, Reason: reason}
+}
+
+// Iterator walks a table in key order, loading data blocks as it reaches
+// them and reusing one block iterator for all of them. Key and Value are
+// valid until the next call to SeekGE, Next or Close.
+type Iterator struct {
+	t      *Reader
+	verify bool
+	fill   bool
+	index  blockIter
+	data   blockIter // positioned on the current block when loaded is set
+	loaded bool
+	blocks int // data blocks loaded so far
+	err    error
+}
+
+// SeekGE positions the iterator at the first key >= target.
+func (it *Iterator) SeekGE(target []byte) {
+	if it.err != nil {
+		return
+	}
+	it.unload()
+	if !it.index.seekGE(target) {
+		it.err = it.index.err
+		return
+	}
+	if !it.loadBlock() {
+		return
+	}
+	if !it.data.seekGE(target) {
+		it.nextBlock()
+	}
+}
+
+// Next advances the iterator.
+func (it *Iterator) Next() {
+	if !it.loaded {
+		return
+	}
+	if !it.data.next() {
+		it.nextBlock()
+	}
+}
+
+// Valid reports whether the iterator is positioned on an entry.
+func (it *Iterator) Valid() bool { return it.err == nil && it.loaded && it.data.valid }
+
+// Key returns the current key.
+func (it *Iterator) Key() []byte { return it.data.key }
+
+// Value returns the current value.
+func (it *Iterator) Value() []byte { return it.data.val }
+
+// Err returns the first error encountered.
+func (it *Iterator) Err() error { return it.err }
+
+// BlocksRead returns the number of data blocks the iterator has loaded,
+// from the cache or the file.
+func (it *Iterator) BlocksRead() int { return it.blocks }
+
+// SetFillCache controls whether blocks the iterator reads from now on are
+// inserted into the cache.
+func (it *Iterator) SetFillCache(fill bool) { it.fill = fill }
+
+// Close releases the block the iterator holds.
+func (it *Iterator) Close() error {
+	it.unload()
+	it.index.release()
+	return it.err
+}
+
+// nextBlock moves to the first entry of the following non-empty block,
+// releasing the current one first.
+func (it *Iterator) nextBlock() {
+	for {
+		if it.loaded && it.data.err != nil {
+			it.err = it.data.err
+			return
+		}
+		it.unload()
+		if !it.index.next() {
+			it.err = it.index.err
+			return
+		}
+		if !it.loadBlock() {
+			return
+		}
+		if it.data.first() {
+			return
+		}
+	}
+}
+
+// loadBlock reads the block the index iterator points at into it.data.
+func (it *Iterator) loadBlock() bool {
+	h, err := decodeBlockHandle(it.index.val)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	block, err := it.t.readBlock(h, it.verify, it.fill)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if err := it.data.init(block); err != nil {
+		it.err = it.t.corruption(h.Offset, err.Error())
+		return false
+	}
+	it.loaded = true
+	it.blocks++
+	return true
+}
+
+// unload drops the reference to the current block so it can be evicted
+// from the cache and collected.
+func (it *Iterator) unload() {
+	if it.loaded {
+		it.data.release()
+		it.loaded = false
+	}
+}
@@ -0,0 +1,283 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurzhang/kivi/internal/cache"
+	"github.com/arthurzhang/kivi/internal/invariants"
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+// buildTable writes n entries produced by kv and returns the encoded table.
+func buildTable(t testing.TB, opts WriterOptions, n int, kv func(i int) ([]byte, []byte)) ([]byte, *Writer) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, opts)
+	for i := 0; i < n; i++ {
+		k, v := kv(i)
+		if err := w.Add(k, v); err != nil {
+			t.Fatalf("add %d: %v", i, err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	return buf.Bytes(), w
+}
+
+func stringKV(i int) ([]byte, []byte) {
+	return []byte(fmt.Sprintf("key%06d", i)), []byte(fmt.Sprintf("value-%d", i))
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	data, _ := buildTable(t, WriterOptions{BlockSize: 256}, 1000, stringKV)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	for _, i := range []int{0, 1, 499, 998, 999} {
+		k, v := stringKV(i)
+		got, ok, err := r.Get(k, ReadOptions{})
+		if err != nil || !ok || !bytes.Equal(got, v) {
+			t.Fatalf("get %s: %q ok=%v err=%v", k, got, ok, err)
+		}
+	}
+	if _, ok, _ := r.Get([]byte("key000500x"), ReadOptions{}); ok {
+		t.Fatalf("found absent key")
+	}
+	if _, ok, _ := r.Get([]byte("zzz"), ReadOptions{}); ok {
+		t.Fatalf("found key past the end")
+	}
+
+	it := r.NewIterator(ReadOptions{})
+	n := 0
+	for it.SeekGE(nil); it.Valid(); it.Next() {
+		k, _ := stringKV(n)
+		if !bytes.Equal(it.Key(), k) {
+			t.Fatalf("entry %d: key %s, want %s", n, it.Key(), k)
+		}
+		n++
+	}
+	if it.Err() != nil || n != 1000 {
+		t.Fatalf("scanned %d entries, err=%v", n, it.Err())
+	}
+
+	it.SeekGE([]byte("key000500x"))
+	if !it.Valid() || string(it.Key()) != "key000501" {
+		t.Fatalf("seek landed on %q", it.Key())
+	}
+}
+
+func TestTableRejectsBadInput(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, DefaultWriterOptions())
+	_ = w.Add([]byte("b"), nil)
+	func() {
+		if invariants.Enabled {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected out-of-order key to violate invariants")
+				}
+			}()
+		}
+		if err := w.Add([]byte("a"), nil); err != errOutOfOrder {
+			t.Fatalf("expected out-of-order error, got %v", err)
+		}
+	}()
+
+	data, _ := buildTable(t, DefaultWriterOptions(), 10, stringKV)
+	data[len(data)-1] ^= 0xff
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{}); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("expected corruption for bad magic, got %v", err)
+	}
+}
+
+func TestBlockChecksum(t *testing.T) {
+	data, _ := buildTable(t, WriterOptions{BlockSize: 256}, 100, stringKV)
+	data[20] ^= 0xff // inside the first data block's key bytes
+	k, _ := stringKV(0)
+
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{Name: "000001.sst"})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, _, err = r.Get(k, ReadOptions{VerifyChecksums: true})
+	var cerr *CorruptionError
+	if !errors.Is(err, ErrCorruption) || !errors.As(err, &cerr) {
+		t.Fatalf("expected corruption error, got %v", err)
+	}
+	if cerr.File != "000001.sst" || cerr.Offset != 0 {
+		t.Fatalf("corruption at %s:%d, want 000001.sst:0", cerr.File, cerr.Offset)
+	}
+
+	// Without verification the damaged block is read as is.
+	if _, _, err := r.Get(k, ReadOptions{}); errors.Is(err, ErrCorruption) {
+		t.Fatalf("checksum verified although not requested")
+	}
+
+	paranoid, _ := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{Paranoid: true})
+	if _, _, err := paranoid.Get(k, ReadOptions{}); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("paranoid reader should verify every block, got %v", err)
+	}
+}
+
+// TestIndexKeyShortening reports how much separator shortening saves on the
+// index for string keys and for the 8-byte big-endian keys the bench
+// workload generator produces.
+func TestIndexKeyShortening(t *testing.T) {
+	benchKV := func(i int) ([]byte, []byte) {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i)*7919)
+		return k, make([]byte, 100)
+	}
+	wordKV := func(i int) ([]byte, []byte) {
+		return []byte(fmt.Sprintf("user/%08d/profile", i*37)), make([]byte, 100)
+	}
+	for name, kv := range map[string]func(int) ([]byte, []byte){"bench": benchKV, "words": wordKV} {
+		opts := WriterOptions{BlockSize: 4096}
+		_, short := buildTable(t, opts, 20000, kv)
+
+		var buf bytes.Buffer
+		full := NewWriter(&buf, opts)
+		full.fullIndexKeys = true
+		for i := 0; i < 20000; i++ {
+			k, v := kv(i)
+			_ = full.Add(k, v)
+		}
+		_ = full.Finish()
+
+		if short.IndexSize() > full.IndexSize() {
+			t.Fatalf("%s: shortened index %d bytes larger than full %d", name, short.IndexSize(), full.IndexSize())
+		}
+		t.Logf("%s: index %d bytes, %d without shortening (%.1f%% saved)", name, short.IndexSize(), full.IndexSize(),
+			100*float64(full.IndexSize()-short.IndexSize())/float64(full.IndexSize()))
+	}
+}
+
+func TestTableBlockCache(t *testing.T) {
+	data, _ := buildTable(t, WriterOptions{BlockSize: 256}, 1000, stringKV)
+	c := cache.New(1 << 20)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{Cache: c, FileNum: 7})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	scan := func() int {
+		it := r.NewIterator(ReadOptions{})
+		defer it.Close()
+		n := 0
+		for it.SeekGE(nil); it.Valid(); it.Next() {
+			n++
+		}
+		return n
+	}
+	if n := scan(); n != 1000 {
+		t.Fatalf("cold scan saw %d entries", n)
+	}
+	_, misses := c.Stats()
+	if n := scan(); n != 1000 {
+		t.Fatalf("warm scan saw %d entries", n)
+	}
+	if hits, m := c.Stats(); m != misses || hits == 0 {
+		t.Fatalf("warm scan missed the cache: hits=%d misses=%d->%d", hits, misses, m)
+	}
+
+	// With every block cached, a full scan only allocates the iterator and
+	// the growth of its reused key buffer.
+	if allocs := testing.AllocsPerRun(10, func() { scan() }); allocs > 3 {
+		t.Fatalf("warm full scan allocated %v times", allocs)
+	}
+
+	// Verification also works on cached blocks.
+	k, _ := stringKV(10)
+	if _, ok, err := r.Get(k, ReadOptions{VerifyChecksums: true}); !ok || err != nil {
+		t.Fatalf("verified cached get: ok=%v err=%v", ok, err)
+	}
+
+	// LoadBlock refills a cold cache from the keys of a warm one.
+	cold := cache.New(1 << 20)
+	r2, _ := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{Cache: cold, FileNum: 7})
+	for _, key := range c.Keys() {
+		if err := r2.LoadBlock(key.Offset); err != nil {
+			t.Fatalf("load block at %d: %v", key.Offset, err)
+		}
+	}
+	if cold.Size() != c.Size() {
+		t.Fatalf("warmed cache holds %d bytes, want %d", cold.Size(), c.Size())
+	}
+	if err := r2.LoadBlock(1); err == nil {
+		t.Fatalf("loaded a block at an offset that starts none")
+	}
+}
+
+func benchmarkScan(b *testing.B, c *cache.Cache) {
+	data, _ := buildTable(b, DefaultWriterOptions(), 100000, stringKV)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{Cache: c, FileNum: 1})
+	if err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := r.NewIterator(ReadOptions{})
+		for it.SeekGE(nil); it.Valid(); it.Next() {
+		}
+		it.Close()
+	}
+}
+
+func BenchmarkTableScan(b *testing.B)       { benchmarkScan(b, nil) }
+func BenchmarkTableScanCached(b *testing.B) { benchmarkScan(b, cache.New(64<<20)) }
+
+func TestTableDigest(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	data, _ := buildTable(t, WriterOptions{BlockSize: 256}, 100, stringKV)
+	path := filepath.Join(dir, "000001.sst")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(path); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), ReaderOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if r.Digest() == 0 {
+		t.Fatalf("new table has no digest")
+	}
+
+	bad := append([]byte(nil), data...)
+	bad[20] ^= 0xff
+	_ = os.WriteFile(path, bad, 0644)
+	if err := VerifyFile(path); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("damaged table verified: %v", err)
+	}
+
+	// Tables from before the digest do not open until upgraded.
+	old := append([]byte(nil), data[:len(data)-footerSize+blockHandleSize]...)
+	old = binary.BigEndian.AppendUint64(old, tableMagicV1)
+	if _, err := NewReader(bytes.NewReader(old), int64(len(old)), ReaderOptions{}); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("opened an old table: %v", err)
+	}
+	_ = os.WriteFile(path, old, 0644)
+	if ok, err := UpgradeFooter(path); !ok || err != nil {
+		t.Fatalf("upgrade: ok=%v err=%v", ok, err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+		t.Fatalf("upgraded table differs from one written with a digest")
+	}
+	if ok, err := UpgradeFooter(path); ok || err != nil {
+		t.Fatalf("second upgrade: ok=%v err=%v", ok, err)
+	}
+}
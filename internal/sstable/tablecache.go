@@ -0,0 +1,156 @@
+package sstable
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// TableFileName returns the path of table fileNum inside dir.
+func TableFileName(dir string, fileNum uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.sst", fileNum))
+}
+
+// TableCache keeps up to maxOpen table files open, closing the least
+// recently used one when the limit is reached. Readers are reference
+// counted, so a table evicted while in use is closed on its last release.
+type TableCache struct {
+	dir     string
+	maxOpen int
+	options ReaderOptions // Name and FileNum are set per table
+
+	mu    sync.Mutex
+	lru   *list.List // of *tableEntry, front is most recently used
+	items map[uint64]*list.Element
+}
+
+type tableEntry struct {
+	fileNum uint64
+	file    *os.File
+	reader  *Reader
+	refs    int  // the cache's own reference plus one per Acquire
+	evicted bool // no longer in items; close when refs reaches zero
+}
+
+// NewTableCache returns a cache for the tables in dir. opts is applied to
+// every reader; a shared block cache goes in opts.Cache. A non-positive
+// maxOpen defaults to 1000.
+func NewTableCache(dir string, maxOpen int, opts ReaderOptions) *TableCache {
+	if maxOpen <= 0 {
+		maxOpen = 1000
+	}
+	return &TableCache{
+		dir:     dir,
+		maxOpen: maxOpen,
+		options: opts,
+		lru:     list.New(),
+		items:   make(map[uint64]*list.Element),
+	}
+}
+
+// Acquire returns the reader for fileNum, opening the file if needed. The
+// returned release function must be called once the reader is no longer
+// used.
+func (c *TableCache) Acquire(fileNum uint64) (*Reader, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[fileNum]; ok {
+		c.lru.MoveToFront(el)
+		e := el.Value.(*tableEntry)
+		e.refs++
+		return e.reader, c.releaseFunc(e), nil
+	}
+
+	e, err := c.open(fileNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.items[fileNum] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxOpen {
+		c.removeLocked(c.lru.Back())
+	}
+	e.refs++
+	return e.reader, c.releaseFunc(e), nil
+}
+
+// Evict closes fileNum once it is no longer in use, e.g. after compaction
+// deleted it.
+func (c *TableCache) Evict(fileNum uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[fileNum]; ok {
+		c.removeLocked(el)
+	}
+	if c.options.Cache != nil {
+		c.options.Cache.EvictFile(fileNum)
+	}
+}
+
+// Len returns the number of tables currently held open by the cache.
+func (c *TableCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close evicts every table. Tables still acquired are closed on release.
+func (c *TableCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+	return nil
+}
+
+func (c *TableCache) open(fileNum uint64) (*tableEntry, error) {
+	path := TableFileName(c.dir, fileNum)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	opts := c.options
+	opts.Name = path
+	opts.FileNum = fileNum
+	r, err := NewReader(f, st.Size(), opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &tableEntry{fileNum: fileNum, file: f, reader: r, refs: 1}, nil
+}
+
+// removeLocked drops the cache's reference to the entry at el.
+func (c *TableCache) removeLocked(el *list.Element) {
+	e := el.Value.(*tableEntry)
+	c.lru.Remove(el)
+	delete(c.items, e.fileNum)
+	e.evicted = true
+	c.unrefLocked(e)
+}
+
+func (c *TableCache) unrefLocked(e *tableEntry) {
+	e.refs--
+	if e.refs == 0 && e.evicted {
+		e.file.Close()
+	}
+}
+
+func (c *TableCache) releaseFunc(e *tableEntry) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.unrefLocked(e)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package sstable
+
+import (
+	"os"
+	"testing"
+
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func writeTableFile(t *testing.T, dir string, fileNum uint64) {
+	t.Helper()
+	data, _ := buildTable(t, DefaultWriterOptions(), 10, stringKV)
+	if err := os.WriteFile(TableFileName(dir, fileNum), data, 0644); err != nil {
+		t.Fatalf("write table: %v", err)
+	}
+}
+
+func TestTableCacheLimit(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	for n := uint64(1); n <= 3; n++ {
+		writeTableFile(t, dir, n)
+	}
+
+	tc := NewTableCache(dir, 2, ReaderOptions{})
+	defer tc.Close()
+
+	k, _ := stringKV(3)
+	r1, release1, err := tc.Acquire(1)
+	if err != nil {
+		t.Fatalf("acquire 1: %v", err)
+	}
+	_, release2, _ := tc.Acquire(2)
+	release2()
+	_, release3, _ := tc.Acquire(3) // evicts 1, the least recently used
+	release3()
+	if tc.Len() != 2 {
+		t.Fatalf("%d tables open, want 2", tc.Len())
+	}
+
+	// Table 1 was evicted but is still acquired, so its file stays open.
+	if _, ok, err := r1.Get(k, ReadOptions{}); !ok || err != nil {
+		t.Fatalf("read from evicted but acquired table: ok=%v err=%v", ok, err)
+	}
+	release1()
+	release1() // releasing twice is harmless
+
+	// Re-acquiring reopens it.
+	r1, release1, err = tc.Acquire(1)
+	if err != nil {
+		t.Fatalf("reacquire 1: %v", err)
+	}
+	defer release1()
+	if _, ok, _ := r1.Get(k, ReadOptions{}); !ok {
+		t.Fatalf("reopened table lost data")
+	}
+
+	if _, _, err := tc.Acquire(99); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist for missing table, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"os"
+	"path/filepath"
+
+	"github.com/arthurzhang/kivi/internal/fsutil"
+)
+
+// Tables written before footers carried a digest end in
+// [index_handle:16][magic:8] with this magic. Readers do not open them;
+// UpgradeFooter converts them.
+const (
+	tableMagicV1 = 0x74696e79726f636b // "tinyrock"
+	footerSizeV1 = blockHandleSize + 8
+)
+
+// UpgradeFooter gives the table at path, if it was written before footers
+// carried a digest, the current footer, replacing the file through a
+// temporary one. It reports whether it rewrote the table; tables already
+// in the current format are left alone, so it is safe to run again.
+func UpgradeFooter(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if len(data) < footerSizeV1 || binary.BigEndian.Uint64(data[len(data)-8:]) != tableMagicV1 {
+		return false, nil
+	}
+	body := data[:len(data)-footerSizeV1]
+	out := make([]byte, 0, len(data)+8)
+	out = append(out, data[:len(data)-8]...) // body and index handle
+	out = binary.BigEndian.AppendUint64(out, crc64.Checksum(body, digestTable))
+	out = binary.BigEndian.AppendUint64(out, tableMagic)
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp) // no-op once renamed
+	_, err = f.Write(out)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return false, err
+	}
+	return true, fsutil.SyncDir(filepath.Dir(path))
+}
@@ -0,0 +1,184 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/arthurzhang/kivi/internal/invariants"
+)
+
+// WriterOptions configure table writing.
+type WriterOptions struct {
+	// BlockSize is the approximate uncompressed size of a data block.
+	BlockSize int
+	// RestartInterval is the number of keys between restart points in a
+	// data block; keys in between are prefix-compressed.
+	RestartInterval int
+}
+
+// DefaultWriterOptions returns default writer options.
+func DefaultWriterOptions() WriterOptions {
+	return WriterOptions{
+		BlockSize:       16 << 10,
+		RestartInterval: 16,
+	}
+}
+
+// Writer builds a table from keys added in strictly increasing order.
+type Writer struct {
+	w       io.Writer
+	options WriterOptions
+	offset  uint64
+	digest  hash.Hash64 // of everything written before the footer
+
+	data  blockBuilder
+	index blockBuilder
+
+	lastKey []byte
+	// pendingHandle is the handle of the last data block written, whose
+	// index entry waits for the next key so a short separator can be used.
+	pendingHandle blockHandle
+	pendingIndex  bool
+
+	indexSize int
+	finished  bool
+
+	// fullIndexKeys disables separator shortening; tests use it to measure
+	// the index size saved.
+	fullIndexKeys bool
+}
+
+// NewWriter returns a writer that writes a table to w.
+func NewWriter(w io.Writer, opts WriterOptions) *Writer {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultWriterOptions().BlockSize
+	}
+	if opts.RestartInterval <= 0 {
+		opts.RestartInterval = DefaultWriterOptions().RestartInterval
+	}
+	return &Writer{
+		w:       w,
+		options: opts,
+		digest:  crc64.New(digestTable),
+		data:    newBlockBuilder(opts.RestartInterval),
+		// Index keys are binary searched directly, as in LevelDB.
+		index: newBlockBuilder(1),
+	}
+}
+
+// Add appends an entry. Keys must be strictly increasing.
+func (w *Writer) Add(key, val []byte) error {
+	if w.finished {
+		return errFinished
+	}
+	if w.lastKey != nil && bytes.Compare(key, w.lastKey) <= 0 {
+		if invariants.Enabled {
+			invariants.Violation("sstable: key %q added after %q at offset %d", key, w.lastKey, w.offset)
+		}
+		return errOutOfOrder
+	}
+
+	if w.pendingIndex {
+		w.addIndexEntry(w.separator(w.lastKey, key))
+	}
+
+	w.data.add(key, val)
+	w.lastKey = append(w.lastKey[:0], key...)
+
+	if w.data.estimatedSize() >= w.options.BlockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// Finish writes the remaining data, the index block and the footer. The
+// underlying writer is not closed.
+func (w *Writer) Finish() error {
+	if w.finished {
+		return errFinished
+	}
+	w.finished = true
+
+	if !w.data.empty() {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+	if w.pendingIndex {
+		w.addIndexEntry(w.successor(w.lastKey))
+	}
+
+	index := w.index.finish()
+	w.indexSize = len(index)
+	indexHandle, err := w.writeBlock(index)
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, 0, footerSize)
+	footer = append(footer, indexHandle.encode()...)
+	footer = binary.BigEndian.AppendUint64(footer, w.digest.Sum64())
+	footer = binary.BigEndian.AppendUint64(footer, tableMagic)
+	_, err = w.w.Write(footer)
+	return err
+}
+
+// IndexSize returns the encoded size of the index block. It is only
+// meaningful after Finish.
+func (w *Writer) IndexSize() int { return w.indexSize }
+
+// Digest returns the digest recorded in the footer. It is only meaningful
+// after Finish.
+func (w *Writer) Digest() uint64 { return w.digest.Sum64() }
+
+// flushBlock writes the current data block and defers its index entry.
+func (w *Writer) flushBlock() error {
+	h, err := w.writeBlock(w.data.finish())
+	w.data.reset()
+	if err != nil {
+		return err
+	}
+	w.pendingHandle = h
+	w.pendingIndex = true
+	return nil
+}
+
+// writeBlock writes block followed by its checksum trailer.
+func (w *Writer) writeBlock(block []byte) (blockHandle, error) {
+	h := blockHandle{Offset: w.offset, Size: uint64(len(block))}
+	n, err := w.w.Write(block)
+	w.offset += uint64(n)
+	w.digest.Write(block[:n])
+	if err != nil {
+		return h, err
+	}
+	var trailer [blockTrailerSize]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(block))
+	n, err = w.w.Write(trailer[:])
+	w.offset += uint64(n)
+	w.digest.Write(trailer[:n])
+	return h, err
+}
+
+func (w *Writer) addIndexEntry(key []byte) {
+	w.index.add(key, w.pendingHandle.encode())
+	w.pendingIndex = false
+}
+
+func (w *Writer) separator(a, b []byte) []byte {
+	if w.fullIndexKeys {
+		return a
+	}
+	return Separator(a, b)
+}
+
+func (w *Writer) successor(a []byte) []byte {
+	if w.fullIndexKeys {
+		return a
+	}
+	return Successor(a)
+}
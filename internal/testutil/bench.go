@@ -5,10 +5,13 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"math"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -62,6 +65,104 @@ const (
 	WorkloadF                     // 50% read, 50% read-modify-write
 )
 
+// OpMix gives the share of each operation kind. Shares need not sum to 1;
+// they are normalized.
+type OpMix struct {
+	Read   float64 // GET of an existing key
+	Update float64 // PUT of an existing key
+	Insert float64 // PUT of a new key past the current key space
+	Scan   float64 // SCAN starting at an existing key
+	RMW    float64 // GET then PUT of the same key
+}
+
+// Mix returns the operation mix of a preset workload.
+func (w WorkloadType) Mix() OpMix {
+	switch w {
+	case WorkloadB:
+		return OpMix{Read: 0.95, Update: 0.05}
+	case WorkloadC:
+		return OpMix{Read: 1}
+	case WorkloadE:
+		return OpMix{Read: 0.95, Insert: 0.05}
+	case WorkloadF:
+		return OpMix{Read: 0.5, RMW: 0.5}
+	}
+	return OpMix{Read: 0.5, Update: 0.5}
+}
+
+// ParseOpMix parses ratios such as "read=0.7,update=0.2,scan=0.1". Kinds
+// left out get no operations.
+func ParseOpMix(s string) (OpMix, error) {
+	var m OpMix
+	for _, part := range strings.Split(s, ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return OpMix{}, fmt.Errorf("op mix: %q is not name=ratio", part)
+		}
+		r, err := strconv.ParseFloat(val, 64)
+		if err != nil || r < 0 {
+			return OpMix{}, fmt.Errorf("op mix: bad ratio %q for %s", val, name)
+		}
+		switch name {
+		case "read":
+			m.Read = r
+		case "update":
+			m.Update = r
+		case "insert":
+			m.Insert = r
+		case "scan":
+			m.Scan = r
+		case "rmw":
+			m.RMW = r
+		default:
+			return OpMix{}, fmt.Errorf("op mix: unknown operation %q", name)
+		}
+	}
+	if m.Read+m.Update+m.Insert+m.Scan+m.RMW == 0 {
+		return OpMix{}, fmt.Errorf("op mix: all ratios are zero")
+	}
+	return m, nil
+}
+
+// KeyDistribution selects which keys operations touch.
+type KeyDistribution int
+
+const (
+	DistZipfian KeyDistribution = iota // skewed towards low key indices
+	DistUniform                        // every key equally likely
+	DistLatest                         // skewed towards the highest key indices
+	DistHotspot                        // a hot fraction of keys gets most operations
+)
+
+// KeyFormat selects how key indices are turned into bytes.
+type KeyFormat int
+
+const (
+	KeyBinary KeyFormat = iota // big-endian integer
+	KeyString                  // "user" and the zero-padded decimal index, as in YCSB
+	KeyHashed                  // 8-byte hash of the index, then the index, spreading neighbours apart
+)
+
+// WorkloadOptions shape the keys and values of generated operations. The
+// zero value matches the original generator: 8-byte binary keys with a
+// Zipfian distribution.
+type WorkloadOptions struct {
+	// KeySize is the minimum key length; shorter keys are zero-padded in a
+	// way that keeps their order.
+	KeySize      int
+	KeyFormat    KeyFormat
+	Distribution KeyDistribution
+	// HotspotKeys is the fraction of keys in the hot set and HotspotOps the
+	// fraction of operations aimed at it (DistHotspot; defaults 0.2, 0.8).
+	HotspotKeys float64
+	HotspotOps  float64
+	// FieldCount and FieldLength, when both > 0, model records of several
+	// named fields: values are FieldCount "fieldN=" prefixed fields of
+	// FieldLength bytes each, replacing the value size.
+	FieldCount  int
+	FieldLength int
+}
+
 // WorkloadGenerator generates operations according to a workload spec.
 type WorkloadGenerator struct {
 	rng       *RandSeeded
@@ -71,6 +172,8 @@ type WorkloadGenerator struct {
 	numOps    int
 	opCount   int
 	keyCount  int64
+	opts      WorkloadOptions
+	mix       OpMix
 }
 
 // NewWorkloadGenerator creates a new workload generator.
@@ -82,52 +185,122 @@ func NewWorkloadGenerator(workload WorkloadType, seed int64, numKeys int64, valu
 		valueSize: valueSize,
 		numOps:    1000000, // default
 		keyCount:  numKeys,
+		mix:       workload.Mix(),
 	}
 }
 
+// SetMix replaces the preset's operation mix.
+func (wg *WorkloadGenerator) SetMix(m OpMix) {
+	wg.mix = m
+}
+
 // SetNumOps sets the total number of operations to generate.
 func (wg *WorkloadGenerator) SetNumOps(n int) {
 	wg.numOps = n
 }
 
-// Next generates the next operation type and key/value.
+// SetOptions changes the key and value model.
+func (wg *WorkloadGenerator) SetOptions(opts WorkloadOptions) {
+	if opts.HotspotKeys <= 0 || opts.HotspotKeys > 1 {
+		opts.HotspotKeys = 0.2
+	}
+	if opts.HotspotOps <= 0 || opts.HotspotOps > 1 {
+		opts.HotspotOps = 0.8
+	}
+	wg.opts = opts
+}
+
+// nextKeyIndex draws a key index in [0, keyCount) from the distribution.
+func (wg *WorkloadGenerator) nextKeyIndex() int64 {
+	n := wg.keyCount
+	switch wg.opts.Distribution {
+	case DistUniform:
+		return wg.rng.Int64() % n
+	case DistLatest:
+		return n - 1 - min(wg.keyGen.Next(), n-1)
+	case DistHotspot:
+		hot := max(int64(float64(n)*wg.opts.HotspotKeys), 1)
+		if wg.rng.Float64() < wg.opts.HotspotOps || hot == n {
+			return wg.rng.Int64() % hot
+		}
+		return hot + wg.rng.Int64()%(n-hot)
+	}
+	return wg.keyGen.Next()
+}
+
+// formatKey encodes a key index according to the key format and size.
+func (wg *WorkloadGenerator) formatKey(idx int64) []byte {
+	var key []byte
+	switch wg.opts.KeyFormat {
+	case KeyString:
+		width := max(wg.opts.KeySize-len("user"), 0)
+		key = fmt.Appendf(nil, "user%0*d", width, idx)
+	case KeyHashed:
+		var raw [8]byte
+		binary.BigEndian.PutUint64(raw[:], uint64(idx))
+		h := fnv.New64a()
+		h.Write(raw[:])
+		key = binary.BigEndian.AppendUint64(nil, h.Sum64())
+		key = append(key, raw[:]...)
+	default:
+		key = binary.BigEndian.AppendUint64(nil, uint64(idx))
+	}
+	if pad := wg.opts.KeySize - len(key); pad > 0 && wg.opts.KeyFormat != KeyString {
+		key = append(make([]byte, pad), key...)
+	}
+	return key
+}
+
+// newValue returns a random value following the value model.
+func (wg *WorkloadGenerator) newValue() []byte {
+	if wg.opts.FieldCount <= 0 || wg.opts.FieldLength <= 0 {
+		val := make([]byte, wg.valueSize)
+		rand.Read(val)
+		return val
+	}
+	var val []byte
+	field := make([]byte, wg.opts.FieldLength)
+	for i := 0; i < wg.opts.FieldCount; i++ {
+		rand.Read(field)
+		val = fmt.Appendf(val, "field%d=", i)
+		val = append(val, field...)
+	}
+	return val
+}
+
+// Next generates the next operation and its key. op is one of GET, PUT,
+// INSERT, SCAN (key is the start) and RMW; val is set for PUT, INSERT and
+// RMW.
 func (wg *WorkloadGenerator) Next() (op string, key []byte, val []byte, err error) {
 	if wg.opCount >= wg.numOps {
 		return "", nil, nil, fmt.Errorf("workload exhausted")
 	}
 	wg.opCount++
 
-	keyIdx := wg.keyGen.Next()
-	key = make([]byte, 8)
-	binary.BigEndian.PutUint64(key, uint64(keyIdx))
-
-	var shouldRead, shouldUpdate bool
-	switch wg.workload {
-	case WorkloadA:
-		shouldRead = wg.rng.Float64() < 0.5
-		shouldUpdate = !shouldRead
-	case WorkloadB:
-		shouldRead = wg.rng.Float64() < 0.95
-		shouldUpdate = !shouldRead
-	case WorkloadC:
-		shouldRead = true
-		shouldUpdate = false
-	case WorkloadE:
-		shouldRead = wg.rng.Float64() < 0.95
-		shouldUpdate = !shouldRead
-	case WorkloadF:
-		shouldUpdate = wg.rng.Float64() < 0.5
-		shouldRead = false // RMW reads internally
+	m := wg.mix
+	r := wg.rng.Float64() * (m.Read + m.Update + m.Insert + m.Scan + m.RMW)
+	switch {
+	case r < m.Read:
+		op = "GET"
+	case r < m.Read+m.Update:
+		op = "PUT"
+	case r < m.Read+m.Update+m.Insert:
+		op = "INSERT"
+	case r < m.Read+m.Update+m.Insert+m.Scan:
+		op = "SCAN"
+	default:
+		op = "RMW"
 	}
 
-	if shouldUpdate {
-		op = "PUT"
-		val = make([]byte, wg.valueSize)
-		rand.Read(val)
+	if op == "INSERT" {
+		key = wg.formatKey(wg.keyCount)
+		wg.keyCount++
 	} else {
-		op = "GET"
+		key = wg.formatKey(wg.nextKeyIndex())
+	}
+	if op == "PUT" || op == "INSERT" || op == "RMW" {
+		val = wg.newValue()
 	}
-
 	return op, key, val, nil
 }
 
@@ -145,6 +318,11 @@ func (r *RandSeeded) Int() int64 {
 	return r.state
 }
 
+// Int64 returns a non-negative value of 62 random bits from two draws.
+func (r *RandSeeded) Int64() int64 {
+	return r.Int()<<31 | r.Int()
+}
+
 func (r *RandSeeded) Float64() float64 {
 	return float64(r.Int()) / (1 << 31)
 }
@@ -1,6 +1,8 @@
 package testutil
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -75,3 +77,130 @@ func TestBenchStats(t *testing.T) {
 		t.Errorf("Expected P50 ~= %v, got %v", expected, p50)
 	}
 }
+
+func TestBenchStatsPercentileUnsorted(t *testing.T) {
+	stats := NewBenchStats()
+	for _, us := range []int{90, 10, 50, 30, 70} {
+		stats.Record("get", time.Duration(us)*time.Microsecond)
+	}
+	if p50, p99 := stats.CalculatePercentile(50), stats.CalculatePercentile(99); p50 != 50*time.Microsecond || p99 != 90*time.Microsecond {
+		t.Errorf("p50=%v p99=%v, want 50µs and 90µs", p50, p99)
+	}
+}
+
+func TestTimelineCSV(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tl := NewTimeline(start, time.Second)
+	for i := 1; i <= 100; i++ {
+		tl.Record(start.Add(100*time.Millisecond), time.Duration(i)*time.Microsecond)
+	}
+	tl.Record(start.Add(2500*time.Millisecond), 5*time.Millisecond) // a stall
+
+	var buf strings.Builder
+	if err := tl.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "elapsed_s,ops_per_sec,p50_us,p99_us,max_us\n" +
+		"0.000,100.0,51,100,100\n" +
+		"1.000,0.0,0,0,0\n" +
+		"2.000,1.0,5000,5000,5000\n"
+	if buf.String() != want {
+		t.Errorf("timeline CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWorkloadKeyModel(t *testing.T) {
+	const n = 1000
+	gen := NewWorkloadGenerator(WorkloadC, 1, n, 64, 0.99)
+	gen.SetOptions(WorkloadOptions{KeySize: 16, KeyFormat: KeyString, Distribution: DistHotspot, HotspotKeys: 0.1, HotspotOps: 0.9})
+	hot := 0
+	for i := 0; i < 10000; i++ {
+		_, key, _, _ := gen.Next()
+		if len(key) != 16 || !strings.HasPrefix(string(key), "user") {
+			t.Fatalf("key %q does not match the format", key)
+		}
+		var idx int
+		fmt.Sscanf(string(key), "user%d", &idx)
+		if idx < 0 || idx >= n {
+			t.Fatalf("key index %d out of range", idx)
+		}
+		if idx < n/10 {
+			hot++
+		}
+	}
+	if hot < 8500 || hot > 9500 {
+		t.Errorf("%d of 10000 ops on the hot set, want ~9000", hot)
+	}
+
+	gen = NewWorkloadGenerator(WorkloadA, 1, n, 64, 0.99)
+	gen.SetOptions(WorkloadOptions{KeySize: 20, KeyFormat: KeyHashed, Distribution: DistUniform, FieldCount: 3, FieldLength: 10})
+	for i := 0; i < 100; i++ {
+		op, key, val, _ := gen.Next()
+		if len(key) != 20 {
+			t.Fatalf("hashed key %x has length %d, want 20", key, len(key))
+		}
+		if op == "PUT" && (len(val) != 3*(len("fieldN=")+10) || !strings.HasPrefix(string(val), "field0=")) {
+			t.Fatalf("value %q does not follow the field model", val)
+		}
+	}
+
+	// Padding binary keys keeps their order.
+	gen.SetOptions(WorkloadOptions{KeySize: 12})
+	if a, b := gen.formatKey(1), gen.formatKey(256); len(a) != 12 || string(a) >= string(b) {
+		t.Errorf("padded binary keys %x, %x out of order", a, b)
+	}
+}
+
+func TestParseOpMix(t *testing.T) {
+	m, err := ParseOpMix("read=0.7, update=0.2,insert=0.05,scan=0.05,rmw=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (OpMix{Read: 0.7, Update: 0.2, Insert: 0.05, Scan: 0.05}); m != want {
+		t.Errorf("mix = %+v, want %+v", m, want)
+	}
+	for _, bad := range []string{"", "read", "read=x", "read=-1", "write=1", "read=0,update=0"} {
+		if _, err := ParseOpMix(bad); err == nil {
+			t.Errorf("ParseOpMix(%q) succeeded", bad)
+		}
+	}
+
+	gen := NewWorkloadGenerator(WorkloadA, 1, 1000, 64, 0.99)
+	gen.SetMix(OpMix{Insert: 1, Scan: 1})
+	counts := map[string]int{}
+	inserted := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		op, key, _, _ := gen.Next()
+		counts[op]++
+		if op == "INSERT" {
+			if inserted[string(key)] {
+				t.Fatalf("key %x inserted twice", key)
+			}
+			inserted[string(key)] = true
+		}
+	}
+	if len(counts) != 2 || counts["INSERT"] < 400 || counts["SCAN"] < 400 {
+		t.Errorf("op counts %v, want about half INSERT and half SCAN", counts)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	start := time.Unix(1000, 0)
+	p := NewPacer(start, 4)
+	for i := 0; i < 5; i++ {
+		if at, want := p.Next(), start.Add(time.Duration(i)*250*time.Millisecond); !at.Equal(want) {
+			t.Fatalf("op %d intended at %v, want %v", i, at, want)
+		}
+	}
+
+	// Behind schedule, Wait does not sleep and keeps the schedule.
+	p = NewPacer(time.Now().Add(-time.Hour), 1)
+	begin := time.Now()
+	first := p.Wait()
+	if second := p.Wait(); second.Sub(first) != time.Second {
+		t.Errorf("intended starts %v apart, want 1s", second.Sub(first))
+	}
+	if time.Since(begin) > 100*time.Millisecond {
+		t.Errorf("Wait slept while behind schedule")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -80,6 +81,8 @@ type BenchStats struct {
 	MinLatency   time.Duration
 	MaxLatency   time.Duration
 	Latencies    []time.Duration
+
+	sorted bool // Latencies is in ascending order
 }
 
 func NewBenchStats() *BenchStats {
@@ -98,6 +101,7 @@ func (bs *BenchStats) Record(op string, latency time.Duration) {
 		bs.MaxLatency = latency
 	}
 	bs.Latencies = append(bs.Latencies, latency)
+	bs.sorted = false
 }
 
 func (bs *BenchStats) OpsPerSec() float64 {
@@ -107,17 +111,26 @@ func (bs *BenchStats) OpsPerSec() float64 {
 	return float64(bs.TotalOps) / bs.TotalLatency.Seconds()
 }
 
-// CalculatePercentile calculates the p-th percentile latency.
+// CalculatePercentile calculates the p-th percentile latency. It sorts
+// Latencies in place.
 func (bs *BenchStats) CalculatePercentile(p float64) time.Duration {
-	if len(bs.Latencies) == 0 {
-		return 0
+	if !bs.sorted {
+		slices.Sort(bs.Latencies)
+		bs.sorted = true
 	}
+	return percentile(bs.Latencies, p)
+}
 
-	idx := int(float64(len(bs.Latencies)) * p / 100.0)
-	if idx >= len(bs.Latencies) {
-		idx = len(bs.Latencies) - 1
+// percentile returns the p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)) * p / 100.0)
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
 	}
-	return bs.Latencies[idx]
+	return sorted[idx]
 }
 
 // Print prints benchmark statistics.
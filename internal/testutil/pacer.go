@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"runtime"
+	"time"
+)
+
+// spinWindow is how long before an intended start Wait stops sleeping.
+const spinWindow = 2 * time.Millisecond
+
+// Pacer schedules operations at a fixed target rate. Each operation has an
+// intended start time on the schedule, whether or not the previous one
+// finished in time, so latency measured from the intended start includes
+// the queueing delay a stalled store would cause real clients. Measuring
+// from the actual start instead hides it (coordinated omission).
+type Pacer struct {
+	start    time.Time
+	interval time.Duration
+	n        int64
+}
+
+// NewPacer returns a pacer issuing opsPerSec operations per second from
+// start.
+func NewPacer(start time.Time, opsPerSec float64) *Pacer {
+	return &Pacer{start: start, interval: time.Duration(float64(time.Second) / opsPerSec)}
+}
+
+// Next returns the intended start of the next operation.
+func (p *Pacer) Next() time.Time {
+	at := p.start.Add(time.Duration(p.n) * p.interval)
+	p.n++
+	return at
+}
+
+// Wait returns the intended start of the next operation, waiting until
+// then if it is still ahead. Behind schedule it returns at once, so
+// operations are issued back to back until the schedule catches up.
+func (p *Pacer) Wait() time.Time {
+	at := p.Next()
+	// Sleeps overshoot by up to a millisecond or so, which would show up
+	// as latency; sleep short and spin the rest of the way.
+	if d := time.Until(at); d > spinWindow {
+		time.Sleep(d - spinWindow)
+	}
+	for time.Now().Before(at) {
+		runtime.Gosched()
+	}
+	return at
+}
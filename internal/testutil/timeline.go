@@ -0,0 +1,60 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// Timeline buckets operation latencies by the interval they completed in,
+// so throughput dips and latency spikes during a run stay visible instead
+// of vanishing into end-of-run aggregates.
+type Timeline struct {
+	start    time.Time
+	interval time.Duration
+	buckets  [][]time.Duration
+}
+
+// NewTimeline returns a timeline of interval-long buckets starting at
+// start.
+func NewTimeline(start time.Time, interval time.Duration) *Timeline {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &Timeline{start: start, interval: interval}
+}
+
+// Record adds an operation that completed at the given time.
+func (t *Timeline) Record(at time.Time, latency time.Duration) {
+	i := int(at.Sub(t.start) / t.interval)
+	if i < 0 {
+		i = 0
+	}
+	for len(t.buckets) <= i {
+		t.buckets = append(t.buckets, nil)
+	}
+	t.buckets[i] = append(t.buckets[i], latency)
+}
+
+// WriteCSV writes one row per interval: its start in seconds since the
+// timeline began, throughput and latency percentiles in microseconds.
+func (t *Timeline) WriteCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "elapsed_s,ops_per_sec,p50_us,p99_us,max_us")
+	for i, lat := range t.buckets {
+		slices.Sort(lat)
+		var slowest time.Duration
+		if len(lat) > 0 {
+			slowest = lat[len(lat)-1]
+		}
+		fmt.Fprintf(bw, "%.3f,%.1f,%d,%d,%d\n",
+			(time.Duration(i) * t.interval).Seconds(),
+			float64(len(lat))/t.interval.Seconds(),
+			percentile(lat, 50).Microseconds(),
+			percentile(lat, 99).Microseconds(),
+			slowest.Microseconds())
+	}
+	return bw.Flush()
+}
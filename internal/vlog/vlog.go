@@ -0,0 +1,450 @@
+package vlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options configure value-log behavior.
+type Options struct {
+	// MaxFileSize is the size in bytes after which the active file is rotated.
+	MaxFileSize int64
+}
+
+// DefaultOptions returns default value-log options.
+func DefaultOptions() Options {
+	return Options{
+		MaxFileSize: 256 << 20,
+	}
+}
+
+// Pointer locates a value inside a value-log file.
+type Pointer struct {
+	FileNum uint32
+	Offset  uint64
+	Len     uint32 // length of the whole entry, header included
+}
+
+// PointerSize is the encoded size of a Pointer.
+const PointerSize = 4 + 8 + 4
+
+// Encode encodes the pointer to bytes.
+// Format: [file:4][offset:8][len:4]
+func (p Pointer) Encode() []byte {
+	buf := make([]byte, PointerSize)
+	binary.BigEndian.PutUint32(buf[0:4], p.FileNum)
+	binary.BigEndian.PutUint64(buf[4:12], p.Offset)
+	binary.BigEndian.PutUint32(buf[12:16], p.Len)
+	return buf
+}
+
+// DecodePointer decodes bytes produced by Pointer.Encode.
+func DecodePointer(buf []byte) (Pointer, error) {
+	if len(buf) != PointerSize {
+		return Pointer{}, errBadPointer
+	}
+	return Pointer{
+		FileNum: binary.BigEndian.Uint32(buf[0:4]),
+		Offset:  binary.BigEndian.Uint64(buf[4:12]),
+		Len:     binary.BigEndian.Uint32(buf[12:16]),
+	}, nil
+}
+
+// entryHeaderSize is the fixed prefix of every value-log entry.
+// Format: [checksum:4][key_len:4][val_len:4][key][val]
+const entryHeaderSize = 4 + 4 + 4
+
+// GCHandler is consulted by GC for every entry of the file being collected.
+type GCHandler interface {
+	// IsLive reports whether ptr is still the current location of key's value.
+	IsLive(key []byte, ptr Pointer) (bool, error)
+	// Rewrite stores val, the live value of key found at old, outside the
+	// file; the LSM decides where, e.g. back through its write path.
+	Rewrite(key, val []byte, old Pointer) error
+	// Commit is called once every live entry has been rewritten. It makes
+	// the rewrites durable and returns once nothing can still read through
+	// a pointer into the file, which GC then deletes.
+	Commit() error
+}
+
+// Log is an append-only value log made of numbered files. Large values are
+// written here and the LSM stores only a Pointer, so compaction rewrites
+// pointers instead of the values themselves.
+type Log struct {
+	mu      sync.Mutex
+	dir     string
+	options Options
+
+	active     *os.File
+	activeNum  uint32
+	activeSize int64
+
+	// readMu is held for reading while an entry is read from one of the
+	// readers, and for writing while GC or Close closes them, so a read
+	// never runs on a file closed under it. It is taken before mu.
+	readMu    sync.RWMutex
+	readers   map[uint32]*os.File
+	discarded map[uint32]int64 // dead bytes per file
+}
+
+// Open opens the value log in dir, creating it if necessary.
+func Open(dir string) (*Log, error) {
+	return OpenWithOptions(dir, DefaultOptions())
+}
+
+// OpenWithOptions opens the value log with custom options.
+func OpenWithOptions(dir string, opts Options) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:       dir,
+		options:   opts,
+		readers:   make(map[uint32]*os.File),
+		discarded: make(map[uint32]int64),
+	}
+
+	nums, err := l.fileNums()
+	if err != nil {
+		return nil, err
+	}
+	next := uint32(1)
+	if len(nums) > 0 {
+		next = nums[len(nums)-1]
+	}
+	if err := l.openActive(next); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append writes key and val as a new entry and returns its location.
+func (l *Log) Append(key, val []byte) (Pointer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.options.MaxFileSize > 0 && l.activeSize >= l.options.MaxFileSize {
+		if err := l.rotate(); err != nil {
+			return Pointer{}, err
+		}
+	}
+	return l.appendLocked(key, val)
+}
+
+// Read returns the value stored at ptr, validating its checksum.
+func (l *Log) Read(ptr Pointer) ([]byte, error) {
+	_, val, err := l.readEntry(ptr)
+	return val, err
+}
+
+// Sync flushes the active file to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active.Sync()
+}
+
+// Close closes all open files.
+func (l *Log) Close() error {
+	l.readMu.Lock()
+	defer l.readMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for num, f := range l.readers {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(l.readers, num)
+	}
+	if err := l.active.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// MarkDiscarded records that the value at ptr was overwritten or deleted.
+// The accumulated dead bytes drive GC candidate selection.
+func (l *Log) MarkDiscarded(ptr Pointer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.discarded[ptr.FileNum] += int64(ptr.Len)
+}
+
+// LiveBytes returns the size of the log's files less the bytes marked
+// discarded since it was opened.
+func (l *Log) LiveBytes() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nums, err := l.fileNums()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, num := range nums {
+		st, err := os.Stat(l.filePath(num))
+		if err != nil {
+			return 0, err
+		}
+		n += max(st.Size()-l.discarded[num], 0)
+	}
+	return n, nil
+}
+
+// GCCandidate returns the inactive file with the highest fraction of dead
+// bytes, provided that fraction is at least minRatio.
+func (l *Log) GCCandidate(minRatio float64) (uint32, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var best uint32
+	bestRatio := 0.0
+	for num, dead := range l.discarded {
+		if num == l.activeNum {
+			continue
+		}
+		st, err := os.Stat(l.filePath(num))
+		if os.IsNotExist(err) {
+			// Values of a collected file are discarded again as the
+			// tables that pointed at them are shadowed.
+			delete(l.discarded, num)
+			continue
+		}
+		if err != nil || st.Size() == 0 {
+			continue
+		}
+		ratio := float64(dead) / float64(st.Size())
+		if ratio >= minRatio && ratio > bestRatio {
+			best, bestRatio = num, ratio
+		}
+	}
+	return best, bestRatio > 0
+}
+
+// GC hands the live entries of an inactive file to h to rewrite and, once
+// h has committed them, deletes the file. It returns the number of bytes
+// reclaimed.
+func (l *Log) GC(fileNum uint32, h GCHandler) (int64, error) {
+	l.mu.Lock()
+	if fileNum == l.activeNum {
+		l.mu.Unlock()
+		return 0, errActiveFile
+	}
+	l.mu.Unlock()
+
+	f, err := os.Open(l.filePath(fileNum))
+	if err != nil {
+		return 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	size := st.Size()
+
+	var offset int64
+	for offset < size {
+		key, val, n, err := readEntryAt(f, offset)
+		if err != nil {
+			f.Close()
+			return 0, fmt.Errorf("vlog: gc %s at %d: %w", l.filePath(fileNum), offset, err)
+		}
+		old := Pointer{FileNum: fileNum, Offset: uint64(offset), Len: uint32(n)}
+		offset += n
+
+		live, err := h.IsLive(key, old)
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		if !live {
+			continue
+		}
+		if err := h.Rewrite(key, val, old); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+	f.Close()
+
+	if err := h.Commit(); err != nil {
+		return 0, err
+	}
+
+	l.readMu.Lock()
+	defer l.readMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.readers[fileNum]; ok {
+		r.Close()
+		delete(l.readers, fileNum)
+	}
+	delete(l.discarded, fileNum)
+	if err := os.Remove(l.filePath(fileNum)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Files returns the numbers of all value-log files in ascending order.
+func (l *Log) Files() ([]uint32, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fileNums()
+}
+
+func (l *Log) appendLocked(key, val []byte) (Pointer, error) {
+	if uint64(entryHeaderSize)+uint64(len(key))+uint64(len(val)) > math.MaxUint32 {
+		return Pointer{}, errEntryTooLarge
+	}
+	n := entryHeaderSize + len(key) + len(val)
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(val)))
+	copy(buf[entryHeaderSize:], key)
+	copy(buf[entryHeaderSize+len(key):], val)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	if _, err := l.active.Write(buf); err != nil {
+		return Pointer{}, err
+	}
+	ptr := Pointer{FileNum: l.activeNum, Offset: uint64(l.activeSize), Len: uint32(n)}
+	l.activeSize += int64(n)
+	return ptr, nil
+}
+
+func (l *Log) readEntry(ptr Pointer) ([]byte, []byte, error) {
+	l.readMu.RLock()
+	defer l.readMu.RUnlock()
+
+	f, err := l.reader(ptr.FileNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, val, n, err := readEntryAt(f, int64(ptr.Offset))
+	if err != nil {
+		return nil, nil, err
+	}
+	if n != int64(ptr.Len) {
+		return nil, nil, errBadPointer
+	}
+	return key, val, nil
+}
+
+func (l *Log) reader(num uint32) (*os.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.readers[num]; ok {
+		return f, nil
+	}
+	f, err := os.Open(l.filePath(num))
+	if err != nil {
+		return nil, err
+	}
+	l.readers[num] = f
+	return f, nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	return l.openActive(l.activeNum + 1)
+}
+
+func (l *Log) openActive(num uint32) error {
+	f, err := os.OpenFile(l.filePath(num), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.active = f
+	l.activeNum = num
+	l.activeSize = st.Size()
+	return nil
+}
+
+func (l *Log) filePath(num uint32) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%06d%s", num, fileExt))
+}
+
+func (l *Log) fileNums() ([]uint32, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint32
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		var num uint32
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, fileExt), "%d", &num); err != nil {
+			continue
+		}
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// readEntryAt reads and verifies the entry at offset, returning its key,
+// value and total encoded length.
+func readEntryAt(r io.ReaderAt, offset int64) ([]byte, []byte, int64, error) {
+	var hdr [entryHeaderSize]byte
+	if _, err := r.ReadAt(hdr[:], offset); err != nil {
+		return nil, nil, 0, err
+	}
+	keyLen := binary.BigEndian.Uint32(hdr[4:8])
+	valLen := binary.BigEndian.Uint32(hdr[8:12])
+	// Entries are addressed by a 32-bit Pointer.Len; a larger header can only
+	// come from corruption and must not drive a huge allocation.
+	if uint64(entryHeaderSize)+uint64(keyLen)+uint64(valLen) > math.MaxUint32 {
+		return nil, nil, 0, errEntryTooLarge
+	}
+
+	body := make([]byte, uint64(keyLen)+uint64(valLen))
+	if _, err := r.ReadAt(body, offset+entryHeaderSize); err != nil {
+		return nil, nil, 0, err
+	}
+
+	h := crc32.NewIEEE()
+	h.Write(hdr[4:])
+	h.Write(body)
+	if h.Sum32() != binary.BigEndian.Uint32(hdr[0:4]) {
+		return nil, nil, 0, errChecksumMismatch
+	}
+
+	n := int64(entryHeaderSize) + int64(len(body))
+	return body[:keyLen], body[keyLen:], n, nil
+}
+
+const fileExt = ".vlog"
+
+var (
+	errChecksumMismatch = errors.New("checksum mismatch")
+	errBadPointer       = errors.New("invalid value pointer")
+	errActiveFile       = errors.New("cannot gc the active value log file")
+	errEntryTooLarge    = errors.New("value log entry too large")
+)
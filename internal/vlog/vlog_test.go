@@ -0,0 +1,298 @@
+package vlog
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func TestVlogAppendRead(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+
+	val := bytes.Repeat([]byte("v"), 512)
+	ptr, err := l.Append([]byte("k"), val)
+	if err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	got, err := l.Read(ptr)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, val) {
+		t.Fatalf("value mismatch: got %d bytes want %d", len(got), len(val))
+	}
+}
+
+func TestPointerEncodeDecode(t *testing.T) {
+	p := Pointer{FileNum: 7, Offset: 1 << 40, Len: 300}
+	got, err := DecodePointer(p.Encode())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got != p {
+		t.Fatalf("pointer mismatch: got %+v want %+v", got, p)
+	}
+	if _, err := DecodePointer([]byte{1, 2}); err == nil {
+		t.Fatalf("expected error on short pointer")
+	}
+}
+
+func TestVlogReopen(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	ptr, _ := l.Append([]byte("a"), []byte("1"))
+	l.Sync()
+	l.Close()
+
+	l, err = Open(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+
+	ptr2, _ := l.Append([]byte("b"), []byte("2"))
+	if ptr2.Offset <= ptr.Offset {
+		t.Fatalf("reopen should append after existing data: %d <= %d", ptr2.Offset, ptr.Offset)
+	}
+	if v, err := l.Read(ptr); err != nil || string(v) != "1" {
+		t.Fatalf("read after reopen: %q %v", v, err)
+	}
+}
+
+func TestVlogCorruption(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+
+	ptr, _ := l.Append([]byte("key"), []byte("value"))
+	l.Sync()
+
+	f, err := os.OpenFile(l.filePath(ptr.FileNum), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("open for corruption: %v", err)
+	}
+	f.WriteAt([]byte{0xFF}, int64(ptr.Offset)+entryHeaderSize)
+	f.Close()
+
+	if _, err := l.Read(ptr); err == nil {
+		t.Fatalf("expected checksum error")
+	}
+}
+
+// mapIndex stands in for the LSM: it maps keys to their current pointer
+// and rewrites live values to the head of the log.
+type mapIndex struct {
+	l    *Log
+	ptrs map[string]Pointer
+}
+
+func newMapIndex(l *Log) *mapIndex { return &mapIndex{l: l, ptrs: map[string]Pointer{}} }
+
+func (m *mapIndex) IsLive(key []byte, ptr Pointer) (bool, error) {
+	cur, ok := m.ptrs[string(key)]
+	return ok && cur == ptr, nil
+}
+
+func (m *mapIndex) Rewrite(key, val []byte, old Pointer) error {
+	ptr, err := m.l.Append(key, val)
+	if err != nil {
+		return err
+	}
+	m.ptrs[string(key)] = ptr
+	return nil
+}
+
+func (m *mapIndex) Commit() error { return m.l.Sync() }
+
+func TestVlogLiveBytes(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+
+	a, _ := l.Append([]byte("a"), bytes.Repeat([]byte("x"), 100))
+	b, _ := l.Append([]byte("b"), bytes.Repeat([]byte("y"), 50))
+	l.MarkDiscarded(a)
+	n, err := l.LiveBytes()
+	if err != nil {
+		t.Fatalf("live bytes: %v", err)
+	}
+	if n != int64(b.Len) {
+		t.Fatalf("live bytes %d, want b's %d", n, b.Len)
+	}
+}
+
+func TestVlogGC(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := OpenWithOptions(dir, Options{MaxFileSize: 256})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+
+	idx := newMapIndex(l)
+	val := bytes.Repeat([]byte("x"), 64)
+	for i := 0; i < 8; i++ {
+		k := []byte{byte('a' + i)}
+		ptr, err := l.Append(k, val)
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+		idx.ptrs[string(k)] = ptr
+	}
+	// Overwrite half the keys so their first versions become garbage.
+	for i := 0; i < 4; i++ {
+		k := []byte{byte('a' + i)}
+		l.MarkDiscarded(idx.ptrs[string(k)])
+		ptr, _ := l.Append(k, []byte("new"))
+		idx.ptrs[string(k)] = ptr
+	}
+
+	num, ok := l.GCCandidate(0.5)
+	if !ok {
+		t.Fatalf("expected a gc candidate")
+	}
+	reclaimed, err := l.GC(num, idx)
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if reclaimed <= 0 {
+		t.Fatalf("expected reclaimed bytes, got %d", reclaimed)
+	}
+
+	for i := 0; i < 8; i++ {
+		k := string([]byte{byte('a' + i)})
+		v, err := l.Read(idx.ptrs[k])
+		if err != nil {
+			t.Fatalf("read %s after gc: %v", k, err)
+		}
+		want := val
+		if i < 4 {
+			want = []byte("new")
+		}
+		if !bytes.Equal(v, want) {
+			t.Fatalf("key %s: got %q", k, v)
+		}
+	}
+
+	files, _ := l.Files()
+	for _, f := range files {
+		if f == num {
+			t.Fatalf("collected file %d still present", num)
+		}
+	}
+}
+
+func TestVlogGCActiveFile(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+
+	ptr, _ := l.Append([]byte("k"), []byte("v"))
+	if _, err := l.GC(ptr.FileNum, newMapIndex(l)); err == nil {
+		t.Fatalf("expected error collecting the active file")
+	}
+}
+
+// hugeHeader is an io.ReaderAt whose entry header claims 4GB key and value
+// lengths, standing in for a corrupt file without allocating one.
+type hugeHeader struct{}
+
+func (hugeHeader) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0xFF
+	}
+	return len(p), nil
+}
+
+func TestReadEntryRejectsOversizedHeader(t *testing.T) {
+	if _, _, _, err := readEntryAt(hugeHeader{}, 1<<33); err != errEntryTooLarge {
+		t.Fatalf("expected errEntryTooLarge, got %v", err)
+	}
+}
+
+func TestVlogReadDuringGC(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := OpenWithOptions(dir, Options{MaxFileSize: 1 << 10})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+
+	val := bytes.Repeat([]byte("x"), 64)
+	for round := 0; round < 500; round++ {
+		idx := newMapIndex(l)
+		var ptrs []Pointer
+		for i := 0; i < 32; i++ {
+			k := []byte{byte(round), byte(i)}
+			ptr, err := l.Append(k, val)
+			if err != nil {
+				t.Fatalf("append: %v", err)
+			}
+			idx.ptrs[string(k)] = ptr
+			ptrs = append(ptrs, ptr)
+		}
+
+		done := make(chan struct{})
+		errs := make(chan error, 1)
+		go func() {
+			defer close(errs)
+			for {
+				for _, ptr := range ptrs {
+					// Once GC has removed the file, old pointers fail to
+					// open it, but a read never sees it closed midway.
+					if _, err := l.Read(ptr); errors.Is(err, os.ErrClosed) {
+						errs <- err
+						return
+					}
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+		_, err := l.GC(ptrs[0].FileNum, idx)
+		close(done)
+		if err != nil {
+			t.Fatalf("gc: %v", err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("read during gc: %v", err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+)
+
+// A RecordBatch record's SeqNum is that of its first operation, and the
+// operations follow it one sequence number apart. Its Key is empty and its
+// Value is [count:4] then count ops of [type:1][key_len:4][key][val_len:4][val].
+
+var errBadBatch = errors.New("batch record malformed")
+
+// BatchRecord returns a RecordBatch record logging ops, which must be puts
+// and deletes on consecutive sequence numbers.
+func BatchRecord(ops []*Record) *Record {
+	return &Record{Type: RecordBatch, Value: AppendBatch(nil, ops), SeqNum: ops[0].SeqNum}
+}
+
+// AppendBatch appends the value of a RecordBatch record holding ops to dst
+// and returns the extended buffer. Sequence numbers are not encoded.
+func AppendBatch(dst []byte, ops []*Record) []byte {
+	size := 4
+	for _, op := range ops {
+		size += 1 + 4 + len(op.Key) + 4 + len(op.Value)
+	}
+	dst = slices.Grow(dst, size)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(ops)))
+	for _, op := range ops {
+		dst = append(dst, byte(op.Type))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(op.Key)))
+		dst = append(dst, op.Key...)
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(op.Value)))
+		dst = append(dst, op.Value...)
+	}
+	return dst
+}
+
+// LastSeq returns the sequence number of the last operation in the record:
+// SeqNum, unless the record is a batch.
+func (r *Record) LastSeq() uint64 {
+	if r.Type != RecordBatch || len(r.Value) < 4 {
+		return r.SeqNum
+	}
+	if n := binary.BigEndian.Uint32(r.Value); n > 0 {
+		return r.SeqNum + uint64(n) - 1
+	}
+	return r.SeqNum
+}
+
+// BatchOps returns the operations of a RecordBatch record with their
+// sequence numbers. Their keys and values alias r.Value.
+func (r *Record) BatchOps() ([]*Record, error) {
+	data := r.Value
+	if r.Type != RecordBatch || len(data) < 4 {
+		return nil, errBadBatch
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	// Every op takes at least 9 bytes, which bounds a corrupt count.
+	if uint64(count)*9 > uint64(len(data)) {
+		return nil, errBadBatch
+	}
+	field := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			return nil, false
+		}
+		f := data[4 : 4+n]
+		data = data[4+n:]
+		return f, true
+	}
+	ops := make([]*Record, count)
+	for i := range ops {
+		if len(data) < 1 {
+			return nil, errBadBatch
+		}
+		op := &Record{Type: RecordType(data[0]), SeqNum: r.SeqNum + uint64(i)}
+		data = data[1:]
+		var ok bool
+		if op.Key, ok = field(); !ok {
+			return nil, errBadBatch
+		}
+		if op.Value, ok = field(); !ok {
+			return nil, errBadBatch
+		}
+		if op.Type != RecordPut && op.Type != RecordDelete {
+			return nil, errBadBatch
+		}
+		ops[i] = op
+	}
+	if len(data) != 0 {
+		return nil, errBadBatch
+	}
+	return ops, nil
+}
@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func TestBatchRecord(t *testing.T) {
+	ops := []*Record{
+		{Type: RecordPut, Key: []byte("a"), Value: []byte("1"), SeqNum: 7},
+		{Type: RecordDelete, Key: []byte("b"), SeqNum: 8},
+		{Type: RecordPut, Key: []byte("c"), Value: []byte("333"), SeqNum: 9},
+	}
+	rec, err := Decode(BatchRecord(ops).Encode())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Type != RecordBatch || rec.SeqNum != 7 || rec.LastSeq() != 9 {
+		t.Fatalf("batch record type %d seqs %d..%d", rec.Type, rec.SeqNum, rec.LastSeq())
+	}
+	got, err := rec.BatchOps()
+	if err != nil {
+		t.Fatalf("ops: %v", err)
+	}
+	if len(got) != len(ops) {
+		t.Fatalf("%d ops, want %d", len(got), len(ops))
+	}
+	for i, op := range got {
+		if fmt.Sprint(*op) != fmt.Sprint(*ops[i]) {
+			t.Fatalf("op %d = %v, want %v", i, *op, *ops[i])
+		}
+	}
+
+	if _, err := (&Record{Type: RecordBatch, Value: rec.Value[:len(rec.Value)-1]}).BatchOps(); err == nil {
+		t.Fatalf("expected error for a cut batch")
+	}
+	if _, err := (&Record{Type: RecordPut, Key: []byte("a"), SeqNum: 3}).BatchOps(); err == nil {
+		t.Fatalf("expected error for a put")
+	}
+	if s := (&Record{Type: RecordPut, SeqNum: 3}).LastSeq(); s != 3 {
+		t.Fatalf("put LastSeq %d, want 3", s)
+	}
+}
+
+func TestWALTruncateKeepsBatch(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal.log")
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = w.Append(&Record{Type: RecordPut, Key: []byte("a"), SeqNum: 1})
+	_ = w.Append(BatchRecord([]*Record{
+		{Type: RecordPut, Key: []byte("b"), SeqNum: 2},
+		{Type: RecordPut, Key: []byte("c"), SeqNum: 3},
+	}))
+	if w.LastSequence() != 3 {
+		t.Fatalf("last sequence %d, want 3", w.LastSequence())
+	}
+	// A flush may end inside a batch; the batch must survive whole.
+	if err := w.Truncate(2); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := replaySeqs(t, path); fmt.Sprint(got) != "[2]" {
+		t.Fatalf("got seqs %v, want the batch at 2", got)
+	}
+}
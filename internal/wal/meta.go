@@ -0,0 +1,77 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/arthurzhang/kivi/internal/fsutil"
+)
+
+// MetaFileName is the name of the WAL metadata file inside the WAL directory.
+const MetaFileName = "wal.meta"
+
+// Meta records how much of the WAL is still needed for recovery. The log is
+// a single file rather than numbered segments, so the only pointer kept is
+// the sequence number below which records are already durable elsewhere.
+type Meta struct {
+	// FlushedSeq is the highest sequence number persisted to SSTables.
+	// Recovery skips records at or below it.
+	FlushedSeq uint64
+}
+
+// metaSize is the encoded size of Meta.
+// Format: [checksum:4][flushed_seq:8]
+const metaSize = 4 + 8
+
+// ReadMeta reads the metadata file in dir. A missing file yields a zero Meta.
+func ReadMeta(dir string) (Meta, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, MetaFileName))
+	if os.IsNotExist(err) {
+		return Meta{}, nil
+	}
+	if err != nil {
+		return Meta{}, err
+	}
+	if len(buf) != metaSize {
+		return Meta{}, errBadMeta
+	}
+	if crc32.ChecksumIEEE(buf[4:]) != binary.BigEndian.Uint32(buf[0:4]) {
+		return Meta{}, errBadMeta
+	}
+	return Meta{FlushedSeq: binary.BigEndian.Uint64(buf[4:12])}, nil
+}
+
+// WriteMeta atomically replaces the metadata file in dir.
+func WriteMeta(dir string, m Meta) error {
+	buf := make([]byte, metaSize)
+	binary.BigEndian.PutUint64(buf[4:12], m.FlushedSeq)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	path := filepath.Join(dir, MetaFileName)
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return fsutil.SyncDir(dir)
+}
+
+var errBadMeta = errors.New("wal meta corrupted")
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
+	"math"
+	"sync"
 )
 
 // RecordType represents the type of operation.
@@ -12,6 +14,9 @@ type RecordType byte
 const (
 	RecordPut RecordType = iota
 	RecordDelete
+	// RecordBatch logs several puts and deletes as one record, so replay
+	// sees all of them or none. See BatchRecord.
+	RecordBatch
 )
 
 // Record represents a single WAL record.
@@ -22,67 +27,99 @@ type Record struct {
 	SeqNum uint64
 }
 
+// recordHeaderSize is the fixed part of the payload: type, seq and the two
+// length fields.
+const recordHeaderSize = 1 + 8 + 4 + 4
+
+// ErrRecordTooLarge is returned for records whose payload does not fit the
+// 32-bit length field.
+var ErrRecordTooLarge = errors.New("record too large")
+
+// payloadSize returns the encoded payload size for the given key and value
+// lengths, computed in 64 bits so it cannot wrap on 32-bit platforms.
+func payloadSize(keyLen, valLen uint64) (uint64, error) {
+	n := recordHeaderSize + keyLen + valLen
+	if n > math.MaxUint32 || n < keyLen || n < valLen {
+		return 0, ErrRecordTooLarge
+	}
+	return n, nil
+}
+
+// Validate reports whether the record can be encoded.
+func (r *Record) Validate() error {
+	_, err := payloadSize(uint64(len(r.Key)), uint64(len(r.Value)))
+	return err
+}
+
 // Encode encodes a record to bytes with checksum.
 // Format: [length:4][checksum:4][type:1][seq:8][key_len:4][key][val_len:4][val]
 func (r *Record) Encode() []byte {
-	// Calculate payload size
-	payloadSize := 1 + // type
-		8 + // seq
-		4 + len(r.Key) + // key len + key
-		4 + len(r.Value) // val len + val
-
-	// Total size = 4 (length) + 4 (checksum) + payload
-	totalSize := 4 + 4 + payloadSize
-	buf := make([]byte, totalSize)
-
-	// Write length
-	binary.BigEndian.PutUint32(buf[0:4], uint32(payloadSize))
+	return r.EncodeTo(make([]byte, 0, r.encodedSize()))
+}
 
-	// Build payload
-	pos := 8 // skip length and checksum
-	buf[pos] = byte(r.Type)
-	pos++
+// encodedSize returns the full encoded size, length and checksum included.
+func (r *Record) encodedSize() int {
+	return 4 + 4 + recordHeaderSize + len(r.Key) + len(r.Value)
+}
 
-	binary.BigEndian.PutUint64(buf[pos:pos+8], r.SeqNum)
-	pos += 8
+// EncodeTo appends the encoded record to dst and returns the extended slice,
+// growing it only if its capacity is insufficient.
+func (r *Record) EncodeTo(dst []byte) []byte {
+	payloadSize := recordHeaderSize + len(r.Key) + len(r.Value)
 
-	binary.BigEndian.PutUint32(buf[pos:pos+4], uint32(len(r.Key)))
-	pos += 4
-	copy(buf[pos:], r.Key)
-	pos += len(r.Key)
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(payloadSize))
+	dst = binary.BigEndian.AppendUint32(dst, 0) // checksum, filled below
 
-	binary.BigEndian.PutUint32(buf[pos:pos+4], uint32(len(r.Value)))
-	pos += 4
-	copy(buf[pos:], r.Value)
+	// Build payload
+	dst = append(dst, byte(r.Type))
+	dst = binary.BigEndian.AppendUint64(dst, r.SeqNum)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(r.Key)))
+	dst = append(dst, r.Key...)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(r.Value)))
+	dst = append(dst, r.Value...)
 
 	// Calculate checksum on payload
-	checksum := crc32.ChecksumIEEE(buf[8:])
-	binary.BigEndian.PutUint32(buf[4:8], checksum)
+	checksum := crc32.ChecksumIEEE(dst[start+8:])
+	binary.BigEndian.PutUint32(dst[start+4:start+8], checksum)
 
-	return buf
+	return dst
 }
 
-// Decode decodes bytes to a record, validating checksum.
+// Decode decodes bytes to a record, validating checksum. The returned key
+// and value are copies and do not alias buf.
 func Decode(buf []byte) (*Record, error) {
+	rec := &Record{}
+	if err := decodeInto(rec, buf, false); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// decodeInto decodes buf into rec. With borrow set, rec.Key and rec.Value
+// alias buf instead of being copied.
+func decodeInto(rec *Record, buf []byte, borrow bool) error {
 	if len(buf) < 8 {
-		return nil, errors.New("record too short")
+		return errors.New("record too short")
 	}
 
 	payloadLen := binary.BigEndian.Uint32(buf[0:4])
 	checksum := binary.BigEndian.Uint32(buf[4:8])
 
-	if len(buf) < int(8+payloadLen) {
-		return nil, errors.New("record truncated")
+	if uint64(len(buf)) < 8+uint64(payloadLen) {
+		return errors.New("record truncated")
+	}
+	if payloadLen < recordHeaderSize {
+		return errMalformed
 	}
 
 	// Verify checksum
 	expectedChecksum := crc32.ChecksumIEEE(buf[8 : 8+payloadLen])
 	if checksum != expectedChecksum {
-		return nil, errors.New("checksum mismatch")
+		return errChecksumMismatch
 	}
 
 	pos := 8
-	rec := &Record{}
 
 	rec.Type = RecordType(buf[pos])
 	pos++
@@ -90,16 +127,55 @@ func Decode(buf []byte) (*Record, error) {
 	rec.SeqNum = binary.BigEndian.Uint64(buf[pos : pos+8])
 	pos += 8
 
+	end := uint64(8 + payloadLen)
+
 	keyLen := binary.BigEndian.Uint32(buf[pos : pos+4])
 	pos += 4
-	rec.Key = make([]byte, keyLen)
-	copy(rec.Key, buf[pos:pos+int(keyLen)])
+	if uint64(pos)+uint64(keyLen)+4 > end {
+		return errMalformed
+	}
+	key := buf[pos : pos+int(keyLen)]
 	pos += int(keyLen)
 
 	valLen := binary.BigEndian.Uint32(buf[pos : pos+4])
 	pos += 4
-	rec.Value = make([]byte, valLen)
-	copy(rec.Value, buf[pos:pos+int(valLen)])
+	if uint64(pos)+uint64(valLen) != end {
+		return errMalformed
+	}
+	val := buf[pos : pos+int(valLen)]
 
-	return rec, nil
+	if borrow {
+		rec.Key, rec.Value = key, val
+	} else {
+		rec.Key = append(make([]byte, 0, len(key)), key...)
+		rec.Value = append(make([]byte, 0, len(val)), val...)
+	}
+	return nil
+}
+
+var errMalformed = errors.New("record malformed")
+
+// maxPooledBuffer bounds the buffers returned to bufPool so a single huge
+// record does not stay pinned in memory.
+const maxPooledBuffer = 64 << 10
+
+// bufPool holds scratch buffers for encoding on the direct append path and
+// for reading records during replay.
+var bufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+func getBuffer() *[]byte {
+	return bufPool.Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+	*b = (*b)[:0]
+	bufPool.Put(b)
 }
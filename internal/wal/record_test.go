@@ -1,6 +1,10 @@
 package wal
 
 import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
 	"testing"
 )
 
@@ -49,3 +53,119 @@ func TestRecordChecksum(t *testing.T) {
 		t.Error("Expected checksum error")
 	}
 }
+
+func TestPayloadSizeOverflow(t *testing.T) {
+	// Lengths are passed as numbers so >4GB cases run without allocating.
+	if _, err := payloadSize(1<<32, 0); err != ErrRecordTooLarge {
+		t.Errorf("Expected ErrRecordTooLarge for 4GB key, got %v", err)
+	}
+	if _, err := payloadSize(math.MaxUint32-recordHeaderSize, 1); err != ErrRecordTooLarge {
+		t.Errorf("Expected ErrRecordTooLarge at the uint32 boundary, got %v", err)
+	}
+	if _, err := payloadSize(math.MaxUint64, 1); err != ErrRecordTooLarge {
+		t.Errorf("Expected ErrRecordTooLarge on uint64 wrap, got %v", err)
+	}
+	n, err := payloadSize(3, 5)
+	if err != nil || n != recordHeaderSize+8 {
+		t.Errorf("Expected %d, got %d (%v)", recordHeaderSize+8, n, err)
+	}
+}
+
+func TestDecodeMalformedLengths(t *testing.T) {
+	rec := &Record{Type: RecordPut, Key: []byte("key"), Value: []byte("val"), SeqNum: 1}
+	encoded := rec.Encode()
+
+	// Claim a key length far past the payload and fix up the checksum so
+	// only the bounds check can catch it.
+	binary.BigEndian.PutUint32(encoded[17:21], math.MaxUint32)
+	binary.BigEndian.PutUint32(encoded[4:8], crc32.ChecksumIEEE(encoded[8:]))
+
+	if _, err := Decode(encoded); err == nil {
+		t.Error("Expected error for key length beyond payload")
+	}
+}
+
+func TestEncodingIsBigEndian(t *testing.T) {
+	rec := &Record{Type: RecordPut, Key: []byte{}, Value: []byte{}, SeqNum: 0x0102030405060708}
+	encoded := rec.Encode()
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	for i, b := range want {
+		if encoded[9+i] != b {
+			t.Fatalf("Expected big-endian seq bytes %v, got %v", want, encoded[9:17])
+		}
+	}
+}
+
+func TestRecordEncodeToAppends(t *testing.T) {
+	rec := &Record{Type: RecordPut, Key: []byte("k"), Value: []byte("v"), SeqNum: 7}
+	prefix := []byte("prefix")
+	buf := rec.EncodeTo(append([]byte(nil), prefix...))
+	if string(buf[:len(prefix)]) != string(prefix) {
+		t.Fatalf("prefix clobbered: %q", buf[:len(prefix)])
+	}
+	if string(buf[len(prefix):]) != string(rec.Encode()) {
+		t.Fatalf("EncodeTo and Encode disagree")
+	}
+
+	// Appending into an existing buffer with room must not allocate.
+	scratch := make([]byte, 0, 256)
+	if n := testing.AllocsPerRun(10, func() { scratch = rec.EncodeTo(scratch[:0]) }); n != 0 {
+		t.Fatalf("EncodeTo allocated %v times", n)
+	}
+}
+
+// benchValueSizes spans inline values to large blobs, where checksumming
+// dominates encode and decode.
+var benchValueSizes = []int{16, 256, 4 << 10, 64 << 10, 1 << 20}
+
+func BenchmarkRecordEncode(b *testing.B) {
+	for _, size := range benchValueSizes {
+		b.Run(fmt.Sprintf("value=%d", size), func(b *testing.B) {
+			rec := &Record{Type: RecordPut, SeqNum: 1, Key: []byte("key00000001"), Value: make([]byte, size)}
+			buf := make([]byte, 0, rec.encodedSize())
+			b.SetBytes(int64(rec.encodedSize()))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf = rec.EncodeTo(buf[:0])
+			}
+		})
+	}
+}
+
+func BenchmarkRecordDecode(b *testing.B) {
+	for _, size := range benchValueSizes {
+		b.Run(fmt.Sprintf("value=%d", size), func(b *testing.B) {
+			rec := &Record{Type: RecordPut, SeqNum: 1, Key: []byte("key00000001"), Value: make([]byte, size)}
+			encoded := rec.Encode()
+			var out Record
+			b.SetBytes(int64(len(encoded)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := decodeInto(&out, encoded, true); err != nil {
+					b.Fatalf("decode: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkChecksum compares the IEEE polynomial used on disk with
+// Castagnoli, which has hardware support on amd64 and arm64.
+func BenchmarkChecksum(b *testing.B) {
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+	for _, size := range benchValueSizes {
+		data := make([]byte, size)
+		b.Run(fmt.Sprintf("ieee/size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				crc32.ChecksumIEEE(data)
+			}
+		})
+		b.Run(fmt.Sprintf("castagnoli/size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				crc32.Checksum(data, castagnoli)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package wal
+
+// replaceFile renames the rewritten log at tmpPath over the log and
+// switches w.file to it. The new handle is opened first and follows the
+// file through the rename, so on error both the log and w.file are left
+// as they were.
+func (w *WAL) replaceFile(tmpPath string) error {
+	file, err := openLogFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := renameLogFile(tmpPath, w.path); err != nil {
+		file.Close()
+		return err
+	}
+	w.file.Close()
+	w.file = file
+	w.buf.Reset(file)
+	return nil
+}
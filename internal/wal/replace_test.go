@@ -0,0 +1,69 @@
+//go:build !windows
+
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurzhang/kivi/internal/failpoint"
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func TestWALTruncateReplaceFails(t *testing.T) {
+	for _, fp := range []string{failpoint.WALOpen, failpoint.WALRename} {
+		for _, group := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/group=%v", fp, group), func(t *testing.T) {
+				dir := testutil.MustTempDir(t)
+				defer os.RemoveAll(dir)
+				path := filepath.Join(dir, "wal.log")
+
+				w, err := OpenWithOptions(path, Options{GroupCommit: group, GroupCommitMS: 5, BufferSize: 64 * 1024})
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				for i := 1; i <= 5; i++ {
+					_ = w.Append(&Record{Type: RecordPut, Key: []byte{byte(i)}, SeqNum: uint64(i)})
+				}
+				size := w.Size()
+
+				failpoint.Enable(fp, nil)
+				err = w.Truncate(3)
+				failpoint.Reset()
+				if !errors.Is(err, failpoint.ErrInjected) {
+					t.Fatalf("truncate: %v, want the injected error", err)
+				}
+				if w.Size() != size {
+					t.Fatalf("size %d after the failed truncate, want %d", w.Size(), size)
+				}
+				// The old log is still open and in place.
+				if err := w.Append(&Record{Type: RecordPut, Key: []byte("x"), SeqNum: 6}); err != nil {
+					t.Fatalf("append after the failed truncate: %v", err)
+				}
+				if err := w.Sync(); err != nil {
+					t.Fatalf("sync after the failed truncate: %v", err)
+				}
+				if got := replaySeqs(t, path); fmt.Sprint(got) != "[1 2 3 4 5 6]" {
+					t.Fatalf("seqs %v after the failed truncate", got)
+				}
+				if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+					t.Fatalf("rewritten log left behind: %v", err)
+				}
+
+				if err := w.Truncate(3); err != nil {
+					t.Fatalf("truncate: %v", err)
+				}
+				_ = w.Append(&Record{Type: RecordPut, Key: []byte("y"), SeqNum: 7})
+				if err := w.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+				if got := replaySeqs(t, path); fmt.Sprint(got) != "[4 5 6 7]" {
+					t.Fatalf("seqs %v after the retried truncate", got)
+				}
+			})
+		}
+	}
+}
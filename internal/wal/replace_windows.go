@@ -0,0 +1,22 @@
+package wal
+
+import "fmt"
+
+// replaceFile renames the rewritten log at tmpPath over the log and
+// reopens it. Windows cannot rename an open file or rename over one, so
+// the log is closed first. If the rename fails the old log is reopened.
+// If no log can be reopened, every later Append fails with the error, as
+// after a failed group commit, rather than writing to a closed file.
+func (w *WAL) replaceFile(tmpPath string) error {
+	w.file.Close()
+	renameErr := renameLogFile(tmpPath, w.path)
+	file, err := openLogFile(w.path)
+	if err != nil {
+		err = fmt.Errorf("wal: reopen after rewrite: %w", err)
+		w.groupErr.CompareAndSwap(nil, &err)
+		return err
+	}
+	w.file = file
+	w.buf.Reset(file)
+	return renameErr
+}
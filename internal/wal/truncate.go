@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+
+	"github.com/arthurzhang/kivi/internal/failpoint"
+	"github.com/arthurzhang/kivi/internal/fsutil"
+)
+
+// LastSequence returns the highest sequence number appended to the WAL,
+// including records found in the file when it was opened. It is not reset
+// by Truncate or Reset, so sequence numbers stay monotonic across them.
+func (w *WAL) LastSequence() uint64 {
+	return w.lastSeq.Load()
+}
+
+// Size returns the size of the log in bytes, counting records that are
+// appended but not yet written.
+func (w *WAL) Size() int64 {
+	return w.size.Load()
+}
+
+// noteSeq raises lastSeq to seq.
+func (w *WAL) noteSeq(seq uint64) {
+	for {
+		cur := w.lastSeq.Load()
+		if seq <= cur || w.lastSeq.CompareAndSwap(cur, seq) {
+			return
+		}
+	}
+}
+
+// Truncate drops every record with a sequence number <= seq, typically once
+// the memtable holding them has been flushed to an SSTable. A batch is kept
+// whole while any of its operations is above seq. The surviving records are
+// rewritten to a temporary file that atomically replaces the log.
+func (w *WAL) Truncate(seq uint64) error {
+	return w.exclusive(func() error {
+		return w.rewrite(func(rec *Record) bool { return rec.LastSeq() > seq })
+	})
+}
+
+// Reset discards every record in the WAL.
+func (w *WAL) Reset() error {
+	return w.exclusive(func() error {
+		st, err := w.file.Stat()
+		if err != nil {
+			return err
+		}
+		if err := w.file.Truncate(0); err != nil {
+			return err
+		}
+		// Records still queued for the next batch stay counted.
+		w.size.Add(-st.Size())
+		return w.syncFile()
+	})
+}
+
+// rewrite copies the records for which keep reports true into a new file
+// and swaps it in place of the current one. The caller has exclusive access
+// to the file and has flushed w.buf.
+func (w *WAL) rewrite(keep func(*Record) bool) error {
+	if err := w.syncFile(); err != nil {
+		return err
+	}
+	oldSt, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	out := bufio.NewWriterSize(tmp, w.options.BufferSize)
+	r, err := NewReader(w.path)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	var buf []byte
+	err = r.ReplayBorrowed(func(rec *Record) error {
+		if !keep(rec) {
+			return nil
+		}
+		buf = rec.EncodeTo(buf[:0])
+		_, err := out.Write(buf)
+		return err
+	})
+	r.Close()
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := w.replaceFile(tmpPath); err != nil {
+		return err
+	}
+	if st, err := w.file.Stat(); err == nil {
+		w.size.Add(st.Size() - oldSt.Size())
+	}
+	return fsutil.SyncDir(filepath.Dir(w.path))
+}
+
+// openLogFile opens the log at path for appending, creating it if needed.
+func openLogFile(path string) (*os.File, error) {
+	if err := failpoint.Inject(failpoint.WALOpen); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// renameLogFile renames a rewritten log over the log at path.
+func renameLogFile(tmpPath, path string) error {
+	if err := failpoint.Inject(failpoint.WALRename); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// scanLastSequence returns the highest sequence number in the WAL at path.
+// Scanning stops at the first unreadable record, as replay does.
+func scanLastSequence(path string) uint64 {
+	r, err := NewReader(path)
+	if err != nil {
+		return 0
+	}
+	defer r.Close()
+
+	var last uint64
+	_ = r.ReplayBorrowed(func(rec *Record) error {
+		if rec.LastSeq() > last {
+			last = rec.LastSeq()
+		}
+		return nil
+	})
+	return last
+}
@@ -0,0 +1,160 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func replaySeqs(t *testing.T, path string) []uint64 {
+	t.Helper()
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	defer r.Close()
+	var seqs []uint64
+	if err := r.Replay(func(rec *Record) error {
+		seqs = append(seqs, rec.SeqNum)
+		return nil
+	}); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return seqs
+}
+
+func TestWALTruncate(t *testing.T) {
+	for _, group := range []bool{false, true} {
+		t.Run(fmt.Sprintf("group=%v", group), func(t *testing.T) {
+			dir := testutil.MustTempDir(t)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "wal.log")
+
+			w, err := OpenWithOptions(path, Options{GroupCommit: group, GroupCommitMS: 5, BufferSize: 64 * 1024})
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			for i := 1; i <= 10; i++ {
+				_ = w.Append(&Record{Type: RecordPut, Key: []byte{byte(i)}, SeqNum: uint64(i)})
+			}
+			if err := w.Truncate(6); err != nil {
+				t.Fatalf("truncate: %v", err)
+			}
+			// Appends after a truncate land in the rewritten file.
+			_ = w.Append(&Record{Type: RecordPut, Key: []byte("x"), SeqNum: 11})
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			got := replaySeqs(t, path)
+			want := []uint64{7, 8, 9, 10, 11}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("got seqs %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWALResetAndLastSequence(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal.log")
+
+	w, err := OpenWithOptions(path, Options{GroupCommit: true, GroupCommitMS: 5, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		_ = w.Append(&Record{Type: RecordPut, Key: []byte{byte(i)}, SeqNum: uint64(i)})
+	}
+	if got := w.LastSequence(); got != 3 {
+		t.Fatalf("last sequence %d, want 3", got)
+	}
+	w.Close()
+
+	// LastSequence is recovered from the file on open.
+	w, err = OpenWithOptions(path, Options{GroupCommit: true, GroupCommitMS: 5, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := w.LastSequence(); got != 3 {
+		t.Fatalf("last sequence after reopen %d, want 3", got)
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if got := w.LastSequence(); got != 3 {
+		t.Fatalf("reset must not rewind last sequence, got %d", got)
+	}
+	_ = w.Append(&Record{Type: RecordPut, Key: []byte("k"), SeqNum: 4})
+	w.Close()
+
+	if got := replaySeqs(t, path); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("got seqs %v after reset, want [4]", got)
+	}
+}
+
+func TestWALMeta(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	if m, err := ReadMeta(dir); err != nil || m.FlushedSeq != 0 {
+		t.Fatalf("missing meta: %+v err=%v", m, err)
+	}
+	if err := WriteMeta(dir, Meta{FlushedSeq: 42}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if m, err := ReadMeta(dir); err != nil || m.FlushedSeq != 42 {
+		t.Fatalf("read back: %+v err=%v", m, err)
+	}
+
+	path := filepath.Join(dir, MetaFileName)
+	buf, _ := os.ReadFile(path)
+	buf[len(buf)-1] ^= 0xff
+	_ = os.WriteFile(path, buf, 0644)
+	if _, err := ReadMeta(dir); err == nil {
+		t.Fatalf("expected corrupted meta to be rejected")
+	}
+}
+
+func TestWALSize(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal.log")
+
+	w, err := OpenWithOptions(path, Options{GroupCommit: true, GroupCommitMS: 5, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	rec := &Record{Type: RecordPut, Key: []byte("k"), Value: []byte("v"), SeqNum: 1}
+	_ = w.Append(rec)
+	_ = w.Append(&Record{Type: RecordPut, Key: []byte("k"), Value: []byte("v"), SeqNum: 2})
+	_ = w.Sync()
+	one := int64(len(rec.Encode()))
+	if got := w.Size(); got != 2*one {
+		t.Fatalf("size %d, want %d", got, 2*one)
+	}
+	if err := w.Truncate(1); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if got := w.Size(); got != one {
+		t.Fatalf("size after truncate %d, want %d", got, one)
+	}
+	w.Close()
+
+	w, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer w.Close()
+	if got := w.Size(); got != one {
+		t.Fatalf("size after reopen %d, want %d", got, one)
+	}
+	_ = w.Reset()
+	if got := w.Size(); got != 0 {
+		t.Fatalf("size after reset %d, want 0", got)
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
-	"hash/crc32"
 	"io"
+	"math"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/arthurzhang/kivi/internal/clock"
+	"github.com/arthurzhang/kivi/internal/failpoint"
 )
 
 // Options configure WAL behavior.
@@ -16,8 +20,36 @@ type Options struct {
 	GroupCommit   bool
 	GroupCommitMS int
 	BufferSize    int
+	Observer      Observer // optional write accounting
+
+	// TargetLatency, if set, makes the group commit window adaptive: it is
+	// tuned between MinWindow and MaxWindow so that the window plus the p99
+	// fsync latency stays within TargetLatency. GroupCommitMS is the
+	// starting window. Zero bounds default to 0.5ms and 10ms.
+	TargetLatency time.Duration
+	MinWindow     time.Duration
+	MaxWindow     time.Duration
+
+	// Clock drives the group commit ticker. Defaults to the wall clock.
+	Clock clock.Clock
+}
+
+// Observer receives WAL write accounting. metrics.Metrics implements it.
+type Observer interface {
+	RecordWALWrite(bytes int64)
+	RecordWALSync(latency time.Duration)
+	RecordWALGroupCommit(records int)
+	RecordWALWindow(window time.Duration)
 }
 
+// nopObserver discards accounting when no Observer is configured.
+type nopObserver struct{}
+
+func (nopObserver) RecordWALWrite(int64)          {}
+func (nopObserver) RecordWALSync(time.Duration)   {}
+func (nopObserver) RecordWALGroupCommit(int)      {}
+func (nopObserver) RecordWALWindow(time.Duration) {}
+
 // WAL is a write-ahead log.
 type WAL struct {
 	file    *os.File
@@ -25,15 +57,29 @@ type WAL struct {
 	mu      sync.Mutex
 	options Options
 
-	// Group commit state
-	groupCh  chan *Record
-	groupBuf []*Record
-	groupMu  sync.Mutex
-	wg       sync.WaitGroup
+	// Group commit state. groupCh is the single ordering point: only
+	// groupCommitLoop touches groupBuf, encodeBuf and, while it runs, the
+	// file, so the batch path takes no locks.
+	groupCh   chan *Record
+	groupBuf  []*Record
+	encodeBuf []byte                // reused across batches
+	groupErr  atomic.Pointer[error] // first write/sync error, set by the loop
+	tuner     *windowTuner          // nil unless Options.TargetLatency is set
+	wg        sync.WaitGroup
+
+	path    string
+	lastSeq atomic.Uint64
+	size    atomic.Int64 // bytes in the log, including queued records
+
+	// barrier for WaitForPending, Truncate and Reset
+	barrierCh chan barrierReq
+}
 
-	path string
-	// barrier for WaitForPending
-	barrierCh chan chan struct{}
+// barrierReq asks the group commit loop to write and sync everything queued,
+// run fn (if any) while no batch is in flight, and reply on done.
+type barrierReq struct {
+	fn   func() error
+	done chan error
 }
 
 // DefaultOptions returns default WAL options.
@@ -52,7 +98,13 @@ func Open(path string) (*WAL, error) {
 
 // OpenWithOptions opens a WAL with custom options.
 func OpenWithOptions(path string, opts Options) (*WAL, error) {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if opts.Observer == nil {
+		opts.Observer = nopObserver{}
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	file, err := openLogFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -64,9 +116,17 @@ func OpenWithOptions(path string, opts Options) (*WAL, error) {
 		path:      path,
 		groupCh:   make(chan *Record, 100),
 		groupBuf:  make([]*Record, 0, 10),
-		barrierCh: make(chan chan struct{}, 1),
+		barrierCh: make(chan barrierReq, 1),
+	}
+	wal.lastSeq.Store(scanLastSequence(path))
+	if st, err := file.Stat(); err == nil {
+		wal.size.Store(st.Size())
 	}
 
+	if opts.GroupCommit && opts.TargetLatency > 0 {
+		initial := time.Duration(opts.GroupCommitMS) * time.Millisecond
+		wal.tuner = newWindowTuner(opts.TargetLatency, opts.MinWindow, opts.MaxWindow, initial)
+	}
 	if opts.GroupCommit {
 		wal.wg.Add(1)
 		go wal.groupCommitLoop()
@@ -75,8 +135,18 @@ func OpenWithOptions(path string, opts Options) (*WAL, error) {
 	return wal, nil
 }
 
-// Append appends a record to the WAL.
+// Append appends a record to the WAL. With group commit, once a batch has
+// failed to reach disk every later Append fails with the same error, so no
+// record is acknowledged behind a hole in the log.
 func (w *WAL) Append(rec *Record) error {
+	if err := rec.Validate(); err != nil {
+		return err
+	}
+	if err := w.loopErr(); err != nil {
+		return err
+	}
+	w.noteSeq(rec.LastSeq())
+	w.size.Add(int64(rec.encodedSize()))
 	if w.options.GroupCommit {
 		// Send to group commit channel
 		w.groupCh <- rec
@@ -87,17 +157,23 @@ func (w *WAL) Append(rec *Record) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	data := rec.Encode()
-	_, err := w.buf.Write(data)
+	if err := failpoint.Inject(failpoint.WALWrite); err != nil {
+		return err
+	}
+	// bufio copies the bytes, so the encode buffer can go straight back.
+	bp := getBuffer()
+	*bp = rec.EncodeTo(*bp)
+	n, err := w.buf.Write(*bp)
+	putBuffer(bp)
+	w.options.Observer.RecordWALWrite(int64(n))
 	return err
 }
 
 // Sync flushes buffered data and syncs to disk.
 func (w *WAL) Sync() error {
 	if w.options.GroupCommit {
-		// Send a nil to trigger immediate commit
-		w.groupCh <- nil
-		return nil
+		// Wait for the group commit loop to write and sync everything queued
+		return w.barrier()
 	}
 
 	w.mu.Lock()
@@ -106,10 +182,39 @@ func (w *WAL) Sync() error {
 	if err := w.buf.Flush(); err != nil {
 		return err
 	}
-	return w.file.Sync()
+	if err := failpoint.Inject(failpoint.WALSync); err != nil {
+		return err
+	}
+	return w.syncFile()
 }
 
-// Close closes the WAL and flushes any pending data.
+// syncFile fsyncs the file and reports the latency. Callers hold w.mu or run
+// on the group commit loop.
+func (w *WAL) syncFile() error {
+	start := time.Now()
+	err := w.file.Sync()
+	latency := time.Since(start)
+	w.options.Observer.RecordWALSync(latency)
+	if w.tuner != nil {
+		w.tuner.observe(latency)
+	}
+	return err
+}
+
+// Flush writes buffered records to the OS without forcing an fsync. With
+// group commit every batch is synced, so this waits for the pending batch.
+func (w *WAL) Flush() error {
+	if w.options.GroupCommit {
+		return w.barrier()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Flush()
+}
+
+// Close closes the WAL and flushes any pending data. It returns the group
+// commit loop's error, if it hit one, since records may have been lost.
 func (w *WAL) Close() error {
 	if w.options.GroupCommit {
 		close(w.groupCh)
@@ -119,20 +224,47 @@ func (w *WAL) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if err := w.buf.Flush(); err != nil {
-		return err
+	err := w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if lerr := w.loopErr(); lerr != nil {
+		return lerr
 	}
-	return w.file.Close()
+	return err
 }
 
+// loopErr returns the first error the group commit loop hit, or nil.
+func (w *WAL) loopErr() error {
+	if p := w.groupErr.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// groupBatchSize is the number of queued records that triggers a commit
+// before the ticker fires.
+const groupBatchSize = 10
+
 // groupCommitLoop runs in the background to batch commits.
 func (w *WAL) groupCommitLoop() {
 	defer w.wg.Done()
 
-	ticker := time.NewTicker(time.Duration(w.options.GroupCommitMS) * time.Millisecond)
+	window := time.Duration(w.options.GroupCommitMS) * time.Millisecond
+	if w.tuner != nil {
+		window = w.tuner.window
+		w.options.Observer.RecordWALWindow(window)
+	}
+	ticker := w.options.Clock.NewTicker(window)
 	defer ticker.Stop()
 
 	for {
+		if w.tuner != nil && w.tuner.window != window {
+			window = w.tuner.window
+			ticker.Reset(window)
+			w.options.Observer.RecordWALWindow(window)
+		}
+
 		select {
 		case rec, ok := <-w.groupCh:
 			if !ok {
@@ -140,67 +272,105 @@ func (w *WAL) groupCommitLoop() {
 				w.flushBatch()
 				return
 			}
-
-			if rec == nil {
-				// Explicit flush request
+			w.groupBuf = append(w.groupBuf, rec)
+			if len(w.groupBuf) >= groupBatchSize {
 				w.flushBatch()
-				continue
 			}
 
-			w.groupMu.Lock()
-			w.groupBuf = append(w.groupBuf, rec)
-			shouldFlush := len(w.groupBuf) >= 10
-			w.groupMu.Unlock()
+		case <-ticker.C():
+			w.flushBatch()
 
-			if shouldFlush {
-				w.flushBatch()
+		case req := <-w.barrierCh:
+			w.drain()
+			w.flushBatch()
+			err := w.loopErr()
+			if err == nil && req.fn != nil {
+				err = req.fn()
 			}
+			req.done <- err
+		}
+	}
+}
 
-		case <-ticker.C:
-			w.flushBatch()
-		case done := <-w.barrierCh:
-			// Drain pending items quickly
-			for {
-				select {
-				case r := <-w.groupCh:
-					if r == nil {
-						w.flushBatch()
-						continue
-					}
-					w.groupMu.Lock()
-					w.groupBuf = append(w.groupBuf, r)
-					w.groupMu.Unlock()
-				default:
-					w.flushBatch()
-					close(done)
-					goto barrierDone
-				}
+// drain moves every record already queued on groupCh into the batch.
+func (w *WAL) drain() {
+	for {
+		select {
+		case rec, ok := <-w.groupCh:
+			if !ok {
+				return
 			}
-		barrierDone:
+			w.groupBuf = append(w.groupBuf, rec)
+		default:
+			return
 		}
 	}
 }
 
-// flushBatch flushes the current batch of records.
+// flushBatch encodes the current batch into the reusable buffer, writes it
+// with a single call and syncs. Only groupCommitLoop calls it.
 func (w *WAL) flushBatch() {
-	w.groupMu.Lock()
 	if len(w.groupBuf) == 0 {
-		w.groupMu.Unlock()
 		return
 	}
 	batch := w.groupBuf
 	w.groupBuf = w.groupBuf[:0]
-	w.groupMu.Unlock()
+
+	buf := w.encodeBuf[:0]
+	for _, rec := range batch {
+		buf = rec.EncodeTo(buf)
+	}
+	w.encodeBuf = buf
+	for i := range batch {
+		batch[i] = nil
+	}
+
+	if err := w.writeAndSync(buf); err != nil {
+		w.groupErr.CompareAndSwap(nil, &err)
+	}
+	w.options.Observer.RecordWALGroupCommit(len(batch))
+}
+
+func (w *WAL) writeAndSync(data []byte) error {
+	n, err := w.buf.Write(data)
+	w.options.Observer.RecordWALWrite(int64(n))
+	if err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := failpoint.Inject(failpoint.WALSync); err != nil {
+		return err
+	}
+	return w.syncFile()
+}
+
+// barrier waits until everything queued so far is written and synced and
+// returns the first error the group commit loop hit, if any.
+func (w *WAL) barrier() error {
+	return w.exclusive(nil)
+}
+
+// exclusive runs fn once every record appended so far has reached the file
+// and no other write can interleave: on the group commit loop in group mode,
+// under w.mu otherwise. A nil fn only waits.
+func (w *WAL) exclusive(fn func() error) error {
+	if w.options.GroupCommit {
+		done := make(chan error, 1)
+		w.barrierCh <- barrierReq{fn: fn, done: done}
+		return <-done
+	}
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
-
-	for _, rec := range batch {
-		data := rec.Encode()
-		w.buf.Write(data)
+	if err := w.buf.Flush(); err != nil {
+		return err
 	}
-	w.buf.Flush()
-	w.file.Sync()
+	if fn == nil {
+		return nil
+	}
+	return fn()
 }
 
 // WaitForPending waits for all pending writes to be committed.
@@ -209,15 +379,14 @@ func (w *WAL) WaitForPending() {
 		_ = w.Sync()
 		return
 	}
-	done := make(chan struct{})
-	w.barrierCh <- done
-	<-done
+	_ = w.barrier()
 }
 
 // Reader reads records from a WAL file.
 type Reader struct {
-	file   *os.File
-	reader *bufio.Reader
+	file    *os.File
+	reader  *bufio.Reader
+	scratch *[]byte // pooled frame buffer, returned on Close
 }
 
 // NewReader creates a new WAL reader.
@@ -233,11 +402,36 @@ func NewReader(path string) (*Reader, error) {
 	}, nil
 }
 
+// Close closes the underlying file.
+func (r *Reader) Close() error {
+	if r.scratch != nil {
+		putBuffer(r.scratch)
+		r.scratch = nil
+	}
+	return r.file.Close()
+}
+
 // Replay replays all records, calling callback for each.
 func (r *Reader) Replay(callback func(*Record) error) error {
+	return r.replay(false, callback)
+}
+
+// ReplayBorrowed is like Replay but decodes every record into the same
+// Record, whose Key and Value alias a pooled buffer. They are only valid
+// until callback returns; callers that keep them must copy.
+func (r *Reader) ReplayBorrowed(callback func(*Record) error) error {
+	return r.replay(true, callback)
+}
+
+func (r *Reader) replay(borrow bool, callback func(*Record) error) error {
+	var shared Record
 	count := 0
 	for {
-		rec, err := r.ReadRecord()
+		rec := &shared
+		if !borrow {
+			rec = &Record{}
+		}
+		err := r.readInto(rec, borrow)
 		if err == io.EOF {
 			return nil
 		}
@@ -259,45 +453,43 @@ func (r *Reader) Replay(callback func(*Record) error) error {
 
 // ReadRecord reads a single record from the WAL.
 func (r *Reader) ReadRecord() (*Record, error) {
-	// Read length
-	var lenBytes [4]byte
-	if _, err := io.ReadFull(r.reader, lenBytes[:]); err != nil {
+	rec := &Record{}
+	if err := r.readInto(rec, false); err != nil {
 		return nil, err
 	}
-	payloadLen := binary.BigEndian.Uint32(lenBytes[:])
+	return rec, nil
+}
 
-	// Read checksum
-	var checksumBytes [4]byte
-	if _, err := io.ReadFull(r.reader, checksumBytes[:]); err != nil {
-		return nil, err
+// readInto reads the next frame into the reader's scratch buffer and decodes
+// it into rec.
+func (r *Reader) readInto(rec *Record, borrow bool) error {
+	// Read length and checksum
+	var hdr [8]byte
+	if _, err := io.ReadFull(r.reader, hdr[:]); err != nil {
+		return err
 	}
-
-	// Read payload
-	buf := make([]byte, payloadLen)
-	if _, err := io.ReadFull(r.reader, buf); err != nil {
-		return nil, err
+	payloadLen := binary.BigEndian.Uint32(hdr[0:4])
+	if uint64(payloadLen) > math.MaxInt-8 {
+		return ErrRecordTooLarge
 	}
 
-	// Verify checksum
-	checksum := binary.BigEndian.Uint32(checksumBytes[:])
-	expectedChecksum := crc32.ChecksumIEEE(buf)
-	if checksum != expectedChecksum {
-		// Try to decode anyway for error message
-		fullBuf := make([]byte, 8+payloadLen)
-		copy(fullBuf[0:4], lenBytes[:])
-		copy(fullBuf[4:8], checksumBytes[:])
-		copy(fullBuf[8:], buf)
-		_, _ = Decode(fullBuf) // for error message
-		return nil, errChecksumMismatch
+	if r.scratch == nil {
+		r.scratch = getBuffer()
 	}
+	frameLen := 8 + int(payloadLen)
+	buf := *r.scratch
+	if cap(buf) < frameLen {
+		buf = make([]byte, frameLen)
+	}
+	buf = buf[:frameLen]
+	*r.scratch = buf
+	copy(buf, hdr[:])
 
-	// Reconstruct full buffer for decoding
-	fullBuf := make([]byte, 8+payloadLen)
-	copy(fullBuf[0:4], lenBytes[:])
-	copy(fullBuf[4:8], checksumBytes[:])
-	copy(fullBuf[8:], buf)
-
-	return Decode(fullBuf)
+	// Read payload
+	if _, err := io.ReadFull(r.reader, buf[8:]); err != nil {
+		return err
+	}
+	return decodeInto(rec, buf, borrow)
 }
 
 var (
@@ -1,11 +1,16 @@
 package wal
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/arthurzhang/kivi/internal/clock"
+	"github.com/arthurzhang/kivi/internal/failpoint"
 	"github.com/arthurzhang/kivi/internal/testutil"
 )
 
@@ -342,3 +347,223 @@ func TestWALConcurrentAppend(t *testing.T) {
 		t.Fatalf("expected %d records, got %d", n, cnt)
 	}
 }
+
+func TestWALSyncFailpoint(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	wal, err := OpenWithOptions(filepath.Join(dir, "wal.log"), Options{GroupCommit: false, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer wal.Close()
+
+	failpoint.Enable(failpoint.WALWrite, nil)
+	if err := wal.Append(&Record{Type: RecordPut, Key: []byte("k"), SeqNum: 1}); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("expected injected append error, got %v", err)
+	}
+	failpoint.Disable(failpoint.WALWrite)
+
+	_ = wal.Append(&Record{Type: RecordPut, Key: []byte("k"), SeqNum: 1})
+	failpoint.Enable(failpoint.WALSync, nil)
+	defer failpoint.Reset()
+	if err := wal.Sync(); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("expected injected sync error, got %v", err)
+	}
+}
+
+type countingObserver struct {
+	mu      sync.Mutex
+	bytes   int64
+	syncs   int
+	commits int
+	window  time.Duration
+}
+
+func (o *countingObserver) RecordWALWrite(n int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.bytes += n
+}
+
+func (o *countingObserver) RecordWALSync(time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.syncs++
+}
+
+func (o *countingObserver) RecordWALGroupCommit(int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.commits++
+}
+
+func (o *countingObserver) RecordWALWindow(w time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.window = w
+}
+
+func TestWALObserver(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, group := range []bool{false, true} {
+		obs := &countingObserver{}
+		path := filepath.Join(dir, fmt.Sprintf("wal-%v.log", group))
+		wal, err := OpenWithOptions(path, Options{GroupCommit: group, GroupCommitMS: 5, BufferSize: 4096, Observer: obs})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		rec := &Record{Type: RecordPut, Key: []byte("k"), Value: []byte("v"), SeqNum: 1}
+		_ = wal.Append(rec)
+		_ = wal.Sync()
+		wal.Close()
+
+		st, _ := os.Stat(path)
+		obs.mu.Lock()
+		if obs.bytes != st.Size() {
+			t.Errorf("group=%v: observed %d bytes, file has %d", group, obs.bytes, st.Size())
+		}
+		if obs.syncs == 0 {
+			t.Errorf("group=%v: no fsync observed", group)
+		}
+		obs.mu.Unlock()
+	}
+}
+
+func TestWALGroupCommitSyncError(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	wal, err := OpenWithOptions(filepath.Join(dir, "wal.log"), Options{GroupCommit: true, GroupCommitMS: 1000, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	failpoint.Enable(failpoint.WALSync, nil)
+	defer failpoint.Reset()
+	_ = wal.Append(&Record{Type: RecordPut, Key: []byte("k"), SeqNum: 1})
+	if err := wal.Sync(); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("expected injected sync error from group commit, got %v", err)
+	}
+
+	// The error sticks even once syncs would succeed again.
+	failpoint.Reset()
+	if err := wal.Append(&Record{Type: RecordPut, Key: []byte("k"), SeqNum: 2}); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("append after a failed batch = %v, want the batch's error", err)
+	}
+	if seq := wal.LastSequence(); seq != 1 {
+		t.Fatalf("last sequence %d, want 1", seq)
+	}
+	if err := wal.Close(); !errors.Is(err, failpoint.ErrInjected) {
+		t.Fatalf("close = %v, want the batch's error", err)
+	}
+}
+
+func BenchmarkWALGroupCommitAppend(b *testing.B) {
+	dir := testutil.MustTempDir(b)
+	defer os.RemoveAll(dir)
+
+	wal, err := OpenWithOptions(filepath.Join(dir, "wal.log"), Options{GroupCommit: true, GroupCommitMS: 1, BufferSize: 64 * 1024})
+	if err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	defer wal.Close()
+
+	val := make([]byte, 100)
+	b.SetBytes(int64(len(val)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := &Record{Type: RecordPut, SeqNum: uint64(i + 1), Key: []byte(fmt.Sprintf("key%08d", i)), Value: val}
+		if err := wal.Append(rec); err != nil {
+			b.Fatalf("append: %v", err)
+		}
+	}
+	if err := wal.Sync(); err != nil {
+		b.Fatalf("sync: %v", err)
+	}
+}
+
+func BenchmarkRecordEncodeTo(b *testing.B) {
+	rec := &Record{Type: RecordPut, SeqNum: 1, Key: []byte("key00000001"), Value: make([]byte, 100)}
+	buf := make([]byte, 0, 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = rec.EncodeTo(buf[:0])
+	}
+}
+
+func TestWALReplayBorrowed(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	walPath := filepath.Join(dir, "wal.log")
+
+	wal, err := OpenWithOptions(walPath, Options{GroupCommit: false, BufferSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		_ = wal.Append(&Record{Type: RecordPut, Key: []byte(fmt.Sprintf("k%d", i)), Value: []byte(fmt.Sprintf("v%d", i)), SeqNum: uint64(i + 1)})
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reader, err := NewReader(walPath)
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	defer reader.Close()
+
+	var got []string
+	err = reader.ReplayBorrowed(func(rec *Record) error {
+		got = append(got, string(rec.Key)+"="+string(rec.Value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(got) != 5 || got[0] != "k0=v0" || got[4] != "k4=v4" {
+		t.Fatalf("unexpected records %v", got)
+	}
+}
+
+func TestWALGroupCommitManualClock(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	clk := clock.NewManual(time.Unix(0, 0))
+	obs := &countingObserver{}
+	wal, err := OpenWithOptions(filepath.Join(dir, "wal.log"), Options{
+		GroupCommit: true, GroupCommitMS: 60 * 60 * 1000, BufferSize: 4096, Observer: obs, Clock: clk,
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer wal.Close()
+
+	commits := func() int {
+		obs.mu.Lock()
+		defer obs.mu.Unlock()
+		return obs.commits
+	}
+	if err := wal.Append(&Record{Type: RecordPut, SeqNum: 1, Key: []byte("k"), Value: []byte("v")}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := commits(); n != 0 {
+		t.Fatalf("%d commits before the window elapsed", n)
+	}
+
+	// The loop may not have picked the record up before the first tick, so
+	// keep ticking until it commits.
+	err = testutil.WaitFor(func() bool {
+		clk.Advance(time.Hour)
+		return commits() == 1
+	}, 2*time.Second, time.Millisecond)
+	if err != nil {
+		t.Fatalf("record not committed after the window elapsed")
+	}
+}
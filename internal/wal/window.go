@@ -0,0 +1,80 @@
+package wal
+
+import (
+	"sort"
+	"time"
+)
+
+// Default bounds for the adaptive group commit window.
+const (
+	defaultMinWindow = 500 * time.Microsecond
+	defaultMaxWindow = 10 * time.Millisecond
+)
+
+// windowSamples is the number of recent fsync latencies the tuner keeps.
+const windowSamples = 64
+
+// windowTuner picks the group commit window so that waiting for the batch
+// plus the fsync stays within the target commit latency at the p99. A longer
+// window batches more records per fsync, so the tuner uses all the headroom
+// the observed fsync latency leaves.
+type windowTuner struct {
+	target   time.Duration
+	min, max time.Duration
+	window   time.Duration
+
+	samples [windowSamples]time.Duration
+	n       int // number of valid samples
+	next    int // ring position of the next sample
+}
+
+func newWindowTuner(target, min, max, initial time.Duration) *windowTuner {
+	if min <= 0 {
+		min = defaultMinWindow
+	}
+	if max < min {
+		max = defaultMaxWindow
+		if max < min {
+			max = min
+		}
+	}
+	t := &windowTuner{target: target, min: min, max: max}
+	t.window = t.clamp(initial)
+	return t
+}
+
+// observe records an fsync latency and moves the window halfway toward the
+// largest value that still meets the target.
+func (t *windowTuner) observe(fsync time.Duration) {
+	t.samples[t.next] = fsync
+	t.next = (t.next + 1) % windowSamples
+	if t.n < windowSamples {
+		t.n++
+	}
+
+	desired := t.clamp(t.target - t.p99())
+	step := (desired - t.window) / 2
+	if step == 0 {
+		t.window = desired
+		return
+	}
+	t.window += step
+}
+
+// p99 returns the 99th percentile of the recorded fsync latencies.
+func (t *windowTuner) p99() time.Duration {
+	sorted := make([]time.Duration, t.n)
+	copy(sorted, t.samples[:t.n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(t.n*99)/100]
+}
+
+func (t *windowTuner) clamp(d time.Duration) time.Duration {
+	if d < t.min {
+		return t.min
+	}
+	if d > t.max {
+		return t.max
+	}
+	return d
+}
@@ -0,0 +1,62 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/testutil"
+)
+
+func TestWindowTuner(t *testing.T) {
+	tuner := newWindowTuner(5*time.Millisecond, 0, 0, 10*time.Millisecond)
+	if tuner.window != defaultMaxWindow {
+		t.Fatalf("initial window %v, want clamp to %v", tuner.window, defaultMaxWindow)
+	}
+
+	// Slow fsyncs leave little headroom: the window shrinks to the floor.
+	for i := 0; i < 32; i++ {
+		tuner.observe(6 * time.Millisecond)
+	}
+	if tuner.window != defaultMinWindow {
+		t.Fatalf("window %v after slow fsyncs, want %v", tuner.window, defaultMinWindow)
+	}
+
+	// Once fast fsyncs have replaced every slow sample, the window grows
+	// back toward target minus fsync latency.
+	for i := 0; i < 2*windowSamples; i++ {
+		tuner.observe(100 * time.Microsecond)
+	}
+	if got, want := tuner.window, 4900*time.Microsecond; got < want-50*time.Microsecond || got > want {
+		t.Fatalf("window %v after fast fsyncs, want about %v", got, want)
+	}
+}
+
+func TestWALAdaptiveWindowReported(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	obs := &countingObserver{}
+	wal, err := OpenWithOptions(filepath.Join(dir, "wal.log"), Options{
+		GroupCommit:   true,
+		GroupCommitMS: 2,
+		BufferSize:    4096,
+		Observer:      obs,
+		TargetLatency: 3 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = wal.Append(&Record{Type: RecordPut, Key: []byte("k"), SeqNum: 1})
+	if err := wal.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	wal.Close()
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if obs.window < defaultMinWindow || obs.window > 3*time.Millisecond {
+		t.Fatalf("reported window %v out of range", obs.window)
+	}
+}
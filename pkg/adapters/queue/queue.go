@@ -0,0 +1,201 @@
+// Package queue is a persistent at-least-once queue built on a TinyRocks
+// store. Messages are stored under sequence-ordered keys and each consumer
+// keeps a committed offset; in-flight deliveries become visible again if
+// they are not acknowledged before their visibility timeout.
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+// ErrNotInFlight is returned when acknowledging a message that was not
+// delivered to the consumer or whose visibility timeout already expired.
+var ErrNotInFlight = errors.New("queue: message not in flight")
+
+// Message is a delivered queue entry.
+type Message struct {
+	Offset uint64
+	Value  []byte
+}
+
+// Queue is a named queue stored under "q/<name>/".
+type Queue struct {
+	db   *tinyrocks.Store
+	name string
+	now  func() time.Time
+
+	mu        sync.Mutex
+	next      uint64 // offset assigned to the next Enqueue
+	consumers map[string]*consumer
+}
+
+// consumer tracks per-consumer delivery state. Only committed is persisted.
+type consumer struct {
+	committed uint64               // all offsets below are acknowledged
+	inFlight  map[uint64]time.Time // offset -> visibility deadline
+	acked     map[uint64]bool      // acknowledged offsets above committed
+}
+
+// Open opens queue name on db, recovering the next offset from the stored
+// messages.
+func Open(db *tinyrocks.Store, name string) (*Queue, error) {
+	q := &Queue{
+		db:        db,
+		name:      name,
+		now:       time.Now,
+		consumers: make(map[string]*consumer),
+	}
+
+	it := db.NewIterator(q.msgKey(0), q.msgEnd(), nil)
+	for it.Next() {
+		q.next = binary.BigEndian.Uint64(it.Key()[len(it.Key())-8:]) + 1
+	}
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Enqueue appends val and returns its offset.
+func (q *Queue) Enqueue(val []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	off := q.next
+	if err := q.db.Put(q.msgKey(off), val, nil); err != nil {
+		return 0, err
+	}
+	q.next++
+	return off, nil
+}
+
+// Dequeue delivers the oldest message that consumer has not acknowledged and
+// that is not currently in flight. The message stays invisible to consumer
+// for visibility; unacknowledged messages are redelivered after that.
+func (q *Queue) Dequeue(consumer string, visibility time.Duration) (*Message, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, err := q.consumer(consumer)
+	if err != nil {
+		return nil, false, err
+	}
+	now := q.now()
+
+	it := q.db.NewIterator(q.msgKey(c.committed), q.msgEnd(), nil)
+	defer it.Close()
+	for it.Next() {
+		off := binary.BigEndian.Uint64(it.Key()[len(it.Key())-8:])
+		if c.acked[off] {
+			continue
+		}
+		if deadline, ok := c.inFlight[off]; ok && now.Before(deadline) {
+			continue
+		}
+		c.inFlight[off] = now.Add(visibility)
+		return &Message{Offset: off, Value: append([]byte(nil), it.Value()...)}, true, nil
+	}
+	return nil, false, nil
+}
+
+// Ack acknowledges offset for consumer and persists the committed offset
+// once every earlier message has been acknowledged.
+func (q *Queue) Ack(consumer string, offset uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, err := q.consumer(consumer)
+	if err != nil {
+		return err
+	}
+	deadline, ok := c.inFlight[offset]
+	if !ok || !q.now().Before(deadline) {
+		return ErrNotInFlight
+	}
+	delete(c.inFlight, offset)
+	c.acked[offset] = true
+
+	advanced := false
+	for c.acked[c.committed] {
+		delete(c.acked, c.committed)
+		c.committed++
+		advanced = true
+	}
+	if !advanced {
+		return nil
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], c.committed)
+	return q.db.Put(q.offsetKey(consumer), buf[:], nil)
+}
+
+// Committed returns consumer's committed offset.
+func (q *Queue) Committed(consumer string) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, err := q.consumer(consumer)
+	if err != nil {
+		return 0, err
+	}
+	return c.committed, nil
+}
+
+// Trim deletes messages below offset, typically the lowest committed offset
+// across all consumers of the queue.
+func (q *Queue) Trim(offset uint64) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var keys [][]byte
+	it := q.db.NewIterator(q.msgKey(0), q.msgKey(offset), nil)
+	for it.Next() {
+		keys = append(keys, append([]byte(nil), it.Key()...))
+	}
+	if err := it.Close(); err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err := q.db.Delete(k, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// consumer returns the state for name, loading its committed offset on
+// first use. Callers hold q.mu.
+func (q *Queue) consumer(name string) (*consumer, error) {
+	if c, ok := q.consumers[name]; ok {
+		return c, nil
+	}
+	c := &consumer{inFlight: make(map[uint64]time.Time), acked: make(map[uint64]bool)}
+	v, ok, err := q.db.Get(q.offsetKey(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if ok && len(v) == 8 {
+		c.committed = binary.BigEndian.Uint64(v)
+	}
+	q.consumers[name] = c
+	return c, nil
+}
+
+func (q *Queue) msgKey(off uint64) []byte {
+	k := []byte("q/" + q.name + "/m/")
+	return binary.BigEndian.AppendUint64(k, off)
+}
+
+// msgEnd is the first key past the message range ('/' + 1 == '0').
+func (q *Queue) msgEnd() []byte {
+	return []byte("q/" + q.name + "/m0")
+}
+
+func (q *Queue) offsetKey(consumer string) []byte {
+	return []byte("q/" + q.name + "/o/" + consumer)
+}
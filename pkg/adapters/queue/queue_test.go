@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+func TestQueueDeliveryAndRedelivery(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := metrics.DefaultConfig()
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.WALDir = filepath.Join(dir, "wal")
+
+	db, err := tinyrocks.Open(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	q, err := Open(db, "jobs")
+	if err != nil {
+		t.Fatalf("open queue: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	q.now = func() time.Time { return now }
+
+	for _, v := range []string{"a", "b", "c"} {
+		if _, err := q.Enqueue([]byte(v)); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	m1, ok, _ := q.Dequeue("w", time.Second)
+	if !ok || string(m1.Value) != "a" {
+		t.Fatalf("first dequeue: %+v ok=%v", m1, ok)
+	}
+	m2, _, _ := q.Dequeue("w", time.Second)
+	if string(m2.Value) != "b" {
+		t.Fatalf("second dequeue should skip in-flight message, got %q", m2.Value)
+	}
+
+	// Ack b before a: committed offset must not move past a.
+	if err := q.Ack("w", m2.Offset); err != nil {
+		t.Fatalf("ack b: %v", err)
+	}
+	if c, _ := q.Committed("w"); c != 0 {
+		t.Fatalf("committed advanced past unacked message: %d", c)
+	}
+
+	// a's visibility expires and it is redelivered.
+	now = now.Add(2 * time.Second)
+	if err := q.Ack("w", m1.Offset); err != ErrNotInFlight {
+		t.Fatalf("expected ErrNotInFlight after expiry, got %v", err)
+	}
+	again, _, _ := q.Dequeue("w", time.Second)
+	if again.Offset != m1.Offset {
+		t.Fatalf("expected redelivery of %d, got %d", m1.Offset, again.Offset)
+	}
+	if err := q.Ack("w", again.Offset); err != nil {
+		t.Fatalf("ack a: %v", err)
+	}
+	if c, _ := q.Committed("w"); c != 2 {
+		t.Fatalf("expected committed 2, got %d", c)
+	}
+
+	if n, err := q.Trim(2); err != nil || n != 2 {
+		t.Fatalf("trim: n=%d err=%v", n, err)
+	}
+	db.Close()
+
+	// Offsets and the message counter survive a restart.
+	db, err = tinyrocks.Open(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	q, _ = Open(db, "jobs")
+	if c, _ := q.Committed("w"); c != 2 {
+		t.Fatalf("committed offset not persisted: %d", c)
+	}
+	off, _ := q.Enqueue([]byte("d"))
+	if off != 3 {
+		t.Fatalf("expected next offset 3, got %d", off)
+	}
+	m, ok, _ := q.Dequeue("w", time.Second)
+	if !ok || string(m.Value) != "c" {
+		t.Fatalf("dequeue after restart: %+v ok=%v", m, ok)
+	}
+}
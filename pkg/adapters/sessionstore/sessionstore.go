@@ -0,0 +1,148 @@
+// Package sessionstore is an HTTP session store backed by a TinyRocks store.
+// Each session is stored under a key prefix with its expiry encoded in front
+// of the session data.
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+// DefaultCookieName is the cookie used when Options.CookieName is empty.
+const DefaultCookieName = "kivi_session"
+
+// keyPrefix namespaces session keys inside the store; keyPrefixEnd is the
+// first key past the prefix range ('/' + 1 == '0').
+const (
+	keyPrefix    = "session/"
+	keyPrefixEnd = "session0"
+)
+
+// expirySize is the size of the expiry timestamp stored before session data.
+const expirySize = 8
+
+// Options configure a session store.
+type Options struct {
+	CookieName string
+	TTL        time.Duration
+}
+
+// Store keeps sessions in a TinyRocks store.
+type Store struct {
+	db   *tinyrocks.Store
+	opts Options
+	now  func() time.Time
+}
+
+// New creates a session store on db.
+func New(db *tinyrocks.Store, opts Options) *Store {
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCookieName
+	}
+	return &Store{db: db, opts: opts, now: time.Now}
+}
+
+// NewID returns a random session id.
+func NewID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// Get returns the data for session id if it exists and has not expired.
+func (s *Store) Get(id string) ([]byte, bool, error) {
+	stored, ok, err := s.db.Get(sessionKey(id), nil)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	data, live := s.decode(stored)
+	return data, live, nil
+}
+
+// Set stores data for session id, expiring after ttl (Options.TTL if zero).
+func (s *Store) Set(id string, data []byte, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = s.opts.TTL
+	}
+	buf := make([]byte, expirySize+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(s.now().Add(ttl).UnixNano()))
+	copy(buf[expirySize:], data)
+	return s.db.Put(sessionKey(id), buf, nil)
+}
+
+// Delete removes session id.
+func (s *Store) Delete(id string) error {
+	return s.db.Delete(sessionKey(id), nil)
+}
+
+// Sweep deletes every expired session and returns how many were removed.
+func (s *Store) Sweep() (int, error) {
+	var expired [][]byte
+	it := s.db.NewIterator([]byte(keyPrefix), []byte(keyPrefixEnd), nil)
+	for it.Next() {
+		if _, live := s.decode(it.Value()); !live {
+			expired = append(expired, append([]byte(nil), it.Key()...))
+		}
+	}
+	if err := it.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, k := range expired {
+		if err := s.db.Delete(k, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+// Load returns the session referenced by r's cookie.
+func (s *Store) Load(r *http.Request) (id string, data []byte, ok bool, err error) {
+	c, err := r.Cookie(s.opts.CookieName)
+	if err == http.ErrNoCookie {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+	data, ok, err = s.Get(c.Value)
+	return c.Value, data, ok, err
+}
+
+// Save stores data under id and sets the session cookie on w.
+func (s *Store) Save(w http.ResponseWriter, id string, data []byte) error {
+	if err := s.Set(id, data, 0); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.opts.CookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(s.opts.TTL.Seconds()),
+		HttpOnly: true,
+	})
+	return nil
+}
+
+// decode splits a stored session into its data and whether it is still live.
+func (s *Store) decode(stored []byte) ([]byte, bool) {
+	if len(stored) < expirySize {
+		return nil, false
+	}
+	expiry := int64(binary.BigEndian.Uint64(stored[:expirySize]))
+	if s.now().UnixNano() >= expiry {
+		return nil, false
+	}
+	return stored[expirySize:], true
+}
+
+func sessionKey(id string) []byte {
+	return []byte(keyPrefix + id)
+}
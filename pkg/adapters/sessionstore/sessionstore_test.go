@@ -0,0 +1,91 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+func openStore(t *testing.T) (*Store, func()) {
+	t.Helper()
+	dir := testutil.MustTempDir(t)
+	cfg := metrics.DefaultConfig()
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.WALDir = filepath.Join(dir, "wal")
+	db, err := tinyrocks.Open(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return New(db, Options{TTL: time.Minute}), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s, cleanup := openStore(t)
+	defer cleanup()
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	_ = s.Set("short", []byte("a"), time.Second)
+	_ = s.Set("long", []byte("b"), 0)
+
+	if data, ok, _ := s.Get("short"); !ok || string(data) != "a" {
+		t.Fatalf("short session: %q ok=%v", data, ok)
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok, _ := s.Get("short"); ok {
+		t.Fatalf("short session should have expired")
+	}
+	if _, ok, _ := s.Get("long"); !ok {
+		t.Fatalf("long session should still be live")
+	}
+
+	n, err := s.Sweep()
+	if err != nil || n != 1 {
+		t.Fatalf("sweep: n=%d err=%v", n, err)
+	}
+
+	_ = s.Delete("long")
+	if _, ok, _ := s.Get("long"); ok {
+		t.Fatalf("deleted session still present")
+	}
+}
+
+func TestSessionHTTP(t *testing.T) {
+	s, cleanup := openStore(t)
+	defer cleanup()
+
+	id, err := NewID()
+	if err != nil {
+		t.Fatalf("new id: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if err := s.Save(rec, id, []byte("cart=3")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	gotID, data, ok, err := s.Load(req)
+	if err != nil || !ok || gotID != id || string(data) != "cart=3" {
+		t.Fatalf("load: id=%q data=%q ok=%v err=%v", gotID, data, ok, err)
+	}
+
+	_, _, ok, err = s.Load(httptest.NewRequest(http.MethodGet, "/", nil))
+	if ok || err != nil {
+		t.Fatalf("load without cookie: ok=%v err=%v", ok, err)
+	}
+}
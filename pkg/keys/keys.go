@@ -0,0 +1,169 @@
+// Package keys builds composite keys whose byte order matches the order of
+// their parts, for indexes kept in a TinyRocks store.
+//
+// Each Append function adds one part to a key; a tuple is a sequence of
+// appends, and compares part by part:
+//
+//	k := keys.AppendString(nil, "orders")
+//	k = keys.AppendUint64(k, customerID)
+//	k = keys.AppendInt64Desc(k, createdAt) // newest first
+//
+// The Decode functions read the parts back in the same order, each
+// returning the rest of the key. Strings are escaped and terminated, so a
+// string part may hold any bytes and never runs into the next part.
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	// ErrShort is returned when a key ends before the part being decoded.
+	ErrShort = errors.New("keys: key too short")
+	// ErrBadString is returned for a string part with an invalid escape or
+	// no terminator.
+	ErrBadString = errors.New("keys: malformed string")
+)
+
+// String parts escape 0x00 as 0x00 0xFF and end with 0x00 0x01, so a
+// shorter string sorts before any string it prefixes.
+const (
+	escape     byte = 0x00
+	escapedNul byte = 0xFF
+	terminator byte = 0x01
+)
+
+// AppendUint64 appends v in ascending order.
+func AppendUint64(dst []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, v)
+}
+
+// AppendUint64Desc appends v in descending order.
+func AppendUint64Desc(dst []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, ^v)
+}
+
+// AppendInt64 appends v in ascending order, negatives first.
+func AppendInt64(dst []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(v)^(1<<63))
+}
+
+// AppendInt64Desc appends v in descending order.
+func AppendInt64Desc(dst []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, ^(uint64(v) ^ (1 << 63)))
+}
+
+// AppendString appends s in ascending order.
+func AppendString(dst []byte, s string) []byte {
+	return appendString(dst, s, 0)
+}
+
+// AppendStringDesc appends s in descending order.
+func AppendStringDesc(dst []byte, s string) []byte {
+	return appendString(dst, s, 0xFF)
+}
+
+// AppendBytes appends b in ascending order, like AppendString.
+func AppendBytes(dst, b []byte) []byte {
+	return appendString(dst, string(b), 0)
+}
+
+// appendString appends the escaped, terminated form of s with every byte
+// XORed with inv; inv 0xFF inverts the order.
+func appendString(dst []byte, s string, inv byte) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] == escape {
+			dst = append(dst, escape^inv, escapedNul^inv)
+		} else {
+			dst = append(dst, s[i]^inv)
+		}
+	}
+	return append(dst, escape^inv, terminator^inv)
+}
+
+// DecodeUint64 decodes a part written by AppendUint64.
+func DecodeUint64(key []byte) (uint64, []byte, error) {
+	if len(key) < 8 {
+		return 0, key, ErrShort
+	}
+	return binary.BigEndian.Uint64(key), key[8:], nil
+}
+
+// DecodeUint64Desc decodes a part written by AppendUint64Desc.
+func DecodeUint64Desc(key []byte) (uint64, []byte, error) {
+	v, rest, err := DecodeUint64(key)
+	return ^v, rest, err
+}
+
+// DecodeInt64 decodes a part written by AppendInt64.
+func DecodeInt64(key []byte) (int64, []byte, error) {
+	v, rest, err := DecodeUint64(key)
+	return int64(v ^ (1 << 63)), rest, err
+}
+
+// DecodeInt64Desc decodes a part written by AppendInt64Desc.
+func DecodeInt64Desc(key []byte) (int64, []byte, error) {
+	v, rest, err := DecodeUint64(key)
+	return int64(^v ^ (1 << 63)), rest, err
+}
+
+// DecodeString decodes a part written by AppendString.
+func DecodeString(key []byte) (string, []byte, error) {
+	b, rest, err := decodeString(key, 0)
+	return string(b), rest, err
+}
+
+// DecodeStringDesc decodes a part written by AppendStringDesc.
+func DecodeStringDesc(key []byte) (string, []byte, error) {
+	b, rest, err := decodeString(key, 0xFF)
+	return string(b), rest, err
+}
+
+// DecodeBytes decodes a part written by AppendBytes. The result does not
+// alias key.
+func DecodeBytes(key []byte) ([]byte, []byte, error) {
+	return decodeString(key, 0)
+}
+
+func decodeString(key []byte, inv byte) ([]byte, []byte, error) {
+	var out []byte
+	for i := 0; i < len(key); i++ {
+		c := key[i] ^ inv
+		if c != escape {
+			out = append(out, c)
+			continue
+		}
+		if i+1 == len(key) {
+			break
+		}
+		switch key[i+1] ^ inv {
+		case escapedNul:
+			out = append(out, escape)
+			i++
+		case terminator:
+			if out == nil {
+				out = []byte{}
+			}
+			return out, key[i+2:], nil
+		default:
+			return nil, key, ErrBadString
+		}
+	}
+	return nil, key, ErrBadString
+}
+
+// PrefixEnd returns the smallest key greater than every key starting with
+// prefix, for use as an exclusive scan bound. It returns nil if there is
+// none, i.e. prefix is empty or all 0xFF.
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < math.MaxUint8 {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package keys
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+type tuple struct {
+	s string
+	i int64
+	u uint64
+}
+
+func (t tuple) less(o tuple) bool {
+	if t.s != o.s {
+		return t.s < o.s
+	}
+	if t.i != o.i {
+		return t.i > o.i // descending part
+	}
+	return t.u < o.u
+}
+
+func (t tuple) encode() []byte {
+	k := AppendString(nil, t.s)
+	k = AppendInt64Desc(k, t.i)
+	return AppendUint64(k, t.u)
+}
+
+func TestTupleOrderAndRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	strs := []string{"", "a", "a\x00", "a\x00b", "ab", "b", "\x00", "\xff", "a\xff"}
+	ints := []int64{math.MinInt64, -1, 0, 1, math.MaxInt64}
+	var tuples []tuple
+	for i := 0; i < 500; i++ {
+		tuples = append(tuples, tuple{
+			s: strs[rng.Intn(len(strs))],
+			i: ints[rng.Intn(len(ints))],
+			u: uint64(rng.Intn(3)) << 62,
+		})
+	}
+	sort.Slice(tuples, func(i, j int) bool { return tuples[i].less(tuples[j]) })
+
+	for i, tu := range tuples {
+		k := tu.encode()
+		if i > 0 && tuples[i-1].less(tu) && bytes.Compare(tuples[i-1].encode(), k) >= 0 {
+			t.Fatalf("%+v encodes after %+v", tuples[i-1], tu)
+		}
+
+		s, rest, err := DecodeString(k)
+		if err != nil || s != tu.s {
+			t.Fatalf("decode string of %+v: %q %v", tu, s, err)
+		}
+		iv, rest, err := DecodeInt64Desc(rest)
+		if err != nil || iv != tu.i {
+			t.Fatalf("decode int of %+v: %d %v", tu, iv, err)
+		}
+		u, rest, err := DecodeUint64(rest)
+		if err != nil || u != tu.u || len(rest) != 0 {
+			t.Fatalf("decode uint of %+v: %d %v, %d bytes left", tu, u, err, len(rest))
+		}
+	}
+}
+
+func TestDescendingParts(t *testing.T) {
+	if bytes.Compare(AppendStringDesc(nil, "b"), AppendStringDesc(nil, "ab")) >= 0 {
+		t.Fatalf("descending strings out of order")
+	}
+	if bytes.Compare(AppendStringDesc(nil, "ab"), AppendStringDesc(nil, "a")) >= 0 {
+		t.Fatalf("descending string sorts before its prefix")
+	}
+	if s, _, err := DecodeStringDesc(AppendStringDesc(nil, "x\x00y")); err != nil || s != "x\x00y" {
+		t.Fatalf("decode desc string: %q %v", s, err)
+	}
+	if bytes.Compare(AppendUint64Desc(nil, 2), AppendUint64Desc(nil, 1)) >= 0 {
+		t.Fatalf("descending uints out of order")
+	}
+	if v, _, _ := DecodeUint64Desc(AppendUint64Desc(nil, 7)); v != 7 {
+		t.Fatalf("decode desc uint: %d", v)
+	}
+	if v, _, _ := DecodeInt64(AppendInt64(nil, -7)); v != -7 {
+		t.Fatalf("decode int: %d", v)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, _, err := DecodeUint64([]byte{1, 2, 3}); err != ErrShort {
+		t.Fatalf("short uint: %v", err)
+	}
+	if _, _, err := DecodeString([]byte("abc")); err != ErrBadString {
+		t.Fatalf("unterminated string: %v", err)
+	}
+	if _, _, err := DecodeString([]byte{'a', 0x00, 0x02}); err != ErrBadString {
+		t.Fatalf("bad escape: %v", err)
+	}
+	if b, rest, err := DecodeBytes(AppendBytes(nil, nil)); err != nil || b == nil || len(b) != 0 || len(rest) != 0 {
+		t.Fatalf("empty bytes: %v %v %v", b, rest, err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct{ in, want []byte }{
+		{[]byte("ab"), []byte("ac")},
+		{[]byte{'a', 0xFF}, []byte("b")},
+		{[]byte{0xFF, 0xFF}, nil},
+		{nil, nil},
+	} {
+		if got := PrefixEnd(tc.in); !bytes.Equal(got, tc.want) || (tc.want == nil) != (got == nil) {
+			t.Fatalf("PrefixEnd(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
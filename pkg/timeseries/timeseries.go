@@ -0,0 +1,122 @@
+// Package timeseries stores timestamped points in a TinyRocks store. Keys are
+// encoded as "ts/" + series + 0x00 + big-endian unix nanoseconds so that the
+// points of one series are contiguous and ordered by time.
+package timeseries
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+// ErrBadSeries is returned for series names containing the 0x00 separator.
+var ErrBadSeries = errors.New("timeseries: series name must not contain 0x00")
+
+// ErrBadTimestamp is returned for timestamps before the unix epoch.
+var ErrBadTimestamp = errors.New("timeseries: timestamp before unix epoch")
+
+const keyPrefix = "ts/"
+
+// Point is a single sample.
+type Point struct {
+	Time  time.Time
+	Value []byte
+}
+
+// DB reads and writes series on a TinyRocks store.
+type DB struct {
+	db *tinyrocks.Store
+}
+
+// New wraps db.
+func New(db *tinyrocks.Store) *DB {
+	return &DB{db: db}
+}
+
+// Append stores value for series at t, replacing any point at the same time.
+func (d *DB) Append(series string, t time.Time, value []byte) error {
+	key, err := EncodeKey(series, t)
+	if err != nil {
+		return err
+	}
+	return d.db.Put(key, value, nil)
+}
+
+// Range calls fn for every point of series in [from, to) in time order,
+// stopping early if fn returns false.
+func (d *DB) Range(series string, from, to time.Time, fn func(Point) bool) error {
+	start, err := EncodeKey(series, from)
+	if err != nil {
+		return err
+	}
+	end, err := EncodeKey(series, to)
+	if err != nil {
+		return err
+	}
+
+	it := d.db.NewIterator(start, end, nil)
+	for it.Next() {
+		_, t, err := DecodeKey(it.Key())
+		if err != nil {
+			it.Close()
+			return err
+		}
+		if !fn(Point{Time: t, Value: it.Value()}) {
+			break
+		}
+	}
+	return it.Close()
+}
+
+// DeleteBefore removes every point of series older than cutoff and returns
+// the number of points removed.
+func (d *DB) DeleteBefore(series string, cutoff time.Time) (int, error) {
+	var keys [][]byte
+	err := d.Range(series, time.Unix(0, 0), cutoff, func(p Point) bool {
+		k, _ := EncodeKey(series, p.Time)
+		keys = append(keys, k)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err := d.db.Delete(k, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// EncodeKey returns the store key for series at t.
+func EncodeKey(series string, t time.Time) ([]byte, error) {
+	if strings.IndexByte(series, 0) >= 0 {
+		return nil, ErrBadSeries
+	}
+	ns := t.UnixNano()
+	if ns < 0 {
+		return nil, ErrBadTimestamp
+	}
+	key := make([]byte, 0, len(keyPrefix)+len(series)+1+8)
+	key = append(key, keyPrefix...)
+	key = append(key, series...)
+	key = append(key, 0)
+	return binary.BigEndian.AppendUint64(key, uint64(ns)), nil
+}
+
+// DecodeKey splits a key produced by EncodeKey.
+func DecodeKey(key []byte) (string, time.Time, error) {
+	if len(key) < len(keyPrefix)+1+8 || string(key[:len(keyPrefix)]) != keyPrefix {
+		return "", time.Time{}, errors.New("timeseries: malformed key")
+	}
+	n := len(key) - 8
+	if key[n-1] != 0 {
+		return "", time.Time{}, errors.New("timeseries: malformed key")
+	}
+	series := string(key[len(keyPrefix) : n-1])
+	ns := int64(binary.BigEndian.Uint64(key[n:]))
+	return series, time.Unix(0, ns), nil
+}
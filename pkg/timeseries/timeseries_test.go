@@ -0,0 +1,69 @@
+package timeseries
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/pkg/tinyrocks"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 123)
+	k, err := EncodeKey("cpu.load", ts)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	series, got, err := DecodeKey(k)
+	if err != nil || series != "cpu.load" || !got.Equal(ts) {
+		t.Fatalf("decode: %q %v %v", series, got, err)
+	}
+	if _, err := EncodeKey("bad\x00name", ts); err != ErrBadSeries {
+		t.Fatalf("expected ErrBadSeries, got %v", err)
+	}
+}
+
+func TestRangeAndRetention(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := metrics.DefaultConfig()
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.WALDir = filepath.Join(dir, "wal")
+	db, err := tinyrocks.Open(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	ts := New(db)
+	base := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		_ = ts.Append("a", base.Add(time.Duration(i)*time.Minute), []byte{byte(i)})
+		_ = ts.Append("ab", base.Add(time.Duration(i)*time.Minute), []byte{0xFF})
+	}
+
+	var got []byte
+	err = ts.Range("a", base.Add(2*time.Minute), base.Add(5*time.Minute), func(p Point) bool {
+		got = append(got, p.Value[0])
+		return true
+	})
+	if err != nil {
+		t.Fatalf("range: %v", err)
+	}
+	if len(got) != 3 || got[0] != 2 || got[2] != 4 {
+		t.Fatalf("unexpected window %v", got)
+	}
+
+	n, err := ts.DeleteBefore("a", base.Add(5*time.Minute))
+	if err != nil || n != 5 {
+		t.Fatalf("retention: n=%d err=%v", n, err)
+	}
+	count := 0
+	_ = ts.Range("ab", base, base.Add(time.Hour), func(Point) bool { count++; return true })
+	if count != 10 {
+		t.Fatalf("retention leaked into another series: %d points left", count)
+	}
+}
@@ -0,0 +1,186 @@
+package tinyrocks
+
+import (
+	"errors"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/wal"
+)
+
+var (
+	// ErrNoSavepoint is returned by RollbackToSavepoint and PopSavepoint
+	// when no savepoint is set.
+	ErrNoSavepoint = errors.New("tinyrocks: no savepoint set")
+	// ErrBadBatch is returned by DecodeWriteBatch for data that is not an
+	// encoded batch.
+	ErrBadBatch = errors.New("tinyrocks: malformed write batch")
+	// ErrBatchVersion is returned by DecodeWriteBatch for batches encoded
+	// in a format this version does not understand.
+	ErrBatchVersion = errors.New("tinyrocks: unsupported write batch version")
+)
+
+// batchVersion is the current encoding of a WriteBatch:
+// [version:1][count:4] then count ops of [type:1][key_len:4][key][val_len:4][val].
+const (
+	batchVersion    byte = 1
+	batchHeaderSize      = 1 + 4
+)
+
+// BatchHandler receives the operations of a batch from Iterate.
+type BatchHandler interface {
+	Put(key, val []byte) error
+	Delete(key []byte) error
+}
+
+// WriteBatch collects puts and deletes that Store.Write applies in order
+// under consecutive sequence numbers, with no other write interleaved. A
+// WriteBatch is not safe for concurrent use.
+type WriteBatch struct {
+	ops        []batchOp
+	savepoints []int // len(ops) at each SetSavepoint, innermost last
+}
+
+type batchOp struct {
+	typ   wal.RecordType
+	key   []byte
+	value []byte
+}
+
+// NewWriteBatch returns an empty batch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put stages key=val. The batch keeps its own copies.
+func (b *WriteBatch) Put(key, val []byte) {
+	b.ops = append(b.ops, batchOp{typ: wal.RecordPut, key: append([]byte(nil), key...), value: append([]byte(nil), val...)})
+}
+
+// Delete stages the removal of key.
+func (b *WriteBatch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{typ: wal.RecordDelete, key: append([]byte(nil), key...)})
+}
+
+// Count returns the number of staged operations.
+func (b *WriteBatch) Count() int { return len(b.ops) }
+
+// Clear drops every staged operation and savepoint.
+func (b *WriteBatch) Clear() {
+	b.ops = b.ops[:0]
+	b.savepoints = b.savepoints[:0]
+}
+
+// SetSavepoint marks the current end of the batch. Savepoints nest: each
+// RollbackToSavepoint or PopSavepoint removes the most recent one.
+func (b *WriteBatch) SetSavepoint() {
+	b.savepoints = append(b.savepoints, len(b.ops))
+}
+
+// RollbackToSavepoint drops the operations staged since the most recent
+// savepoint and removes that savepoint.
+func (b *WriteBatch) RollbackToSavepoint() error {
+	n := len(b.savepoints)
+	if n == 0 {
+		return ErrNoSavepoint
+	}
+	clear(b.ops[b.savepoints[n-1]:])
+	b.ops = b.ops[:b.savepoints[n-1]]
+	b.savepoints = b.savepoints[:n-1]
+	return nil
+}
+
+// PopSavepoint removes the most recent savepoint, keeping the operations
+// staged since.
+func (b *WriteBatch) PopSavepoint() error {
+	n := len(b.savepoints)
+	if n == 0 {
+		return ErrNoSavepoint
+	}
+	b.savepoints = b.savepoints[:n-1]
+	return nil
+}
+
+// Iterate calls h for every staged operation in order, stopping at the
+// first error. Keys and values passed to h must not be modified.
+func (b *WriteBatch) Iterate(h BatchHandler) error {
+	for _, op := range b.ops {
+		var err error
+		switch op.typ {
+		case wal.RecordPut:
+			err = h.Put(op.key, op.value)
+		case wal.RecordDelete:
+			err = h.Delete(op.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode returns the staged operations in the versioned batch format, for
+// shipping to another process. Savepoints are not encoded. After the
+// version byte, the format is that of a WAL batch record.
+func (b *WriteBatch) Encode() []byte {
+	return wal.AppendBatch([]byte{batchVersion}, b.records())
+}
+
+// DecodeWriteBatch parses a batch produced by Encode. The returned batch
+// does not alias data.
+func DecodeWriteBatch(data []byte) (*WriteBatch, error) {
+	if len(data) < batchHeaderSize {
+		return nil, ErrBadBatch
+	}
+	if data[0] != batchVersion {
+		return nil, ErrBatchVersion
+	}
+	rec := &wal.Record{Type: wal.RecordBatch, Value: data[1:]}
+	ops, err := rec.BatchOps()
+	if err != nil {
+		return nil, ErrBadBatch
+	}
+	b := &WriteBatch{ops: make([]batchOp, 0, len(ops))}
+	for _, op := range ops {
+		switch {
+		case op.Type == wal.RecordPut:
+			b.Put(op.Key, op.Value)
+		case op.Type == wal.RecordDelete && len(op.Value) == 0:
+			b.Delete(op.Key)
+		default:
+			return nil, ErrBadBatch
+		}
+	}
+	return b, nil
+}
+
+// records returns the staged operations as WAL records, without sequence
+// numbers. They alias the batch.
+func (b *WriteBatch) records() []*wal.Record {
+	recs := make([]*wal.Record, len(b.ops))
+	for i, op := range b.ops {
+		recs[i] = &wal.Record{Type: op.typ, Key: op.key, Value: op.value}
+	}
+	return recs
+}
+
+// Write applies the batch to the store atomically: it is logged as one WAL
+// record, so recovery finds all of it or none. A nil opts uses
+// DefaultWriteOptions. The batch is left unchanged and may be reused after
+// Clear.
+func (s *Store) Write(b *WriteBatch, opts *WriteOptions) error {
+	if b.Count() == 0 {
+		return nil
+	}
+	start := time.Now()
+	defer func() { s.metrics.RecordOp("write", time.Since(start)) }()
+
+	recs := b.records()
+	if s.ttlEnabled() {
+		for _, rec := range recs {
+			if rec.Type == wal.RecordPut {
+				rec.Value = s.stampTTL(rec.Value)
+			}
+		}
+	}
+	return s.write(opts, recs...)
+}
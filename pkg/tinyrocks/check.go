@@ -0,0 +1,278 @@
+package tinyrocks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/sstable"
+	"github.com/arthurzhang/kivi/internal/wal"
+)
+
+// CheckReport is the result of Check. Problems lists every inconsistency
+// found; an empty list means the store is consistent.
+type CheckReport struct {
+	DBID     string       `json:"db_id"`
+	WAL      WALCheck     `json:"wal"`
+	Tables   []TableCheck `json:"tables"`
+	Seq      SeqCheck     `json:"seq"`
+	Problems []string     `json:"problems"`
+}
+
+// OK reports whether the check found no problems.
+func (r *CheckReport) OK() bool { return len(r.Problems) == 0 }
+
+// WALCheck describes the WAL.
+type WALCheck struct {
+	Records    int    `json:"records"`
+	FlushedSeq uint64 `json:"flushed_seq"`
+	FirstSeq   uint64 `json:"first_seq"`
+	LastSeq    uint64 `json:"last_seq"`
+	// Gaps counts jumps in sequence numbers. Writes made with DisableWAL
+	// leave gaps, so they are not problems by themselves.
+	Gaps int `json:"gaps"`
+	// TornTail is set when the WAL ends in a partial record, as left by a
+	// crash mid-append. Replay ignores it.
+	TornTail bool `json:"torn_tail"`
+}
+
+// TableCheck describes one table file.
+type TableCheck struct {
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Entries  int    `json:"entries"`
+	Smallest []byte `json:"smallest"`
+	Largest  []byte `json:"largest"`
+	MinSeq   uint64 `json:"min_seq"`
+	MaxSeq   uint64 `json:"max_seq"`
+	Digest   uint64 `json:"digest"`
+	Err      string `json:"error,omitempty"`
+}
+
+// SeqCheck relates the sequence numbers held by the tables and the WAL.
+// A table keeps only the newest write of each key, so its sequence numbers
+// are not contiguous; continuity is checked where the tables end and the
+// WAL takes over, and within the WAL.
+type SeqCheck struct {
+	// TableMaxSeq is the highest sequence number in any table.
+	TableMaxSeq uint64 `json:"table_max_seq"`
+	// Windows are the sequence numbers above TableMaxSeq that no WAL
+	// record holds. Writes made with DisableWAL that were lost in a crash
+	// leave windows too, so a window is a lost write only if no such
+	// writes were made.
+	Windows []SeqRange `json:"windows"`
+}
+
+// SeqRange is the inclusive range of sequence numbers [First, Last].
+type SeqRange struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+// Check validates the store described by config without opening it: the
+// identity file, every WAL record's checksum and sequence order, every
+// listed table's file digest against its footer and TABLES, block
+// checksums and key order, and that the tables hold every sequence number
+// the WAL metadata records as flushed. It must
+// not run while the store is open. The returned error is set only when
+// the check itself could not run.
+func Check(config *metrics.Config) (*CheckReport, error) {
+	if config == nil {
+		config = metrics.DefaultConfig()
+	}
+	if _, err := os.Stat(config.DataDir); err != nil {
+		return nil, err
+	}
+	r := &CheckReport{Tables: []TableCheck{}, Seq: SeqCheck{Windows: []SeqRange{}}, Problems: []string{}}
+
+	data, err := os.ReadFile(filepath.Join(config.DataDir, identityFileName))
+	switch {
+	case os.IsNotExist(err):
+		r.problem("%s is missing", identityFileName)
+	case err != nil:
+		return nil, err
+	default:
+		if id, err := parseIdentity(data); err != nil {
+			r.problem("%s: %v", identityFileName, err)
+		} else {
+			r.DBID = id.ID
+		}
+	}
+
+	if err := r.checkWAL(config.WALDir); err != nil {
+		return nil, err
+	}
+
+	// Tables missing from TABLES are not part of the store; see
+	// tableListFileName.
+	digests, exists, err := readTableList(config.DataDir)
+	if err != nil {
+		r.problem("%s: %v", tableListFileName, err)
+	}
+	nums, err := tableFiles(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && len(nums) > 0 {
+		r.problem("%s is missing", tableListFileName)
+	}
+	slices.Sort(nums)
+	for _, n := range nums {
+		digest, listed := digests[n]
+		if !listed {
+			continue
+		}
+		delete(digests, n)
+		path := sstable.TableFileName(config.DataDir, n)
+		tc := checkTable(path)
+		switch {
+		case tc.Err != "":
+			r.problem("%s: %s", tc.File, tc.Err)
+		case tc.Digest != digest:
+			r.problem("%s: digest %016x, %s records %016x", tc.File, tc.Digest, tableListFileName, digest)
+		}
+		r.Tables = append(r.Tables, tc)
+		r.Seq.TableMaxSeq = max(r.Seq.TableMaxSeq, tc.MaxSeq)
+	}
+	for _, n := range slices.Sorted(maps.Keys(digests)) {
+		r.problem("%s lists %s, which is missing", tableListFileName, filepath.Base(sstable.TableFileName(config.DataDir, n)))
+	}
+	r.checkSeq()
+	return r, nil
+}
+
+// checkSeq finds the window, if any, between the newest table sequence
+// number and the WAL, and flags flushed sequence numbers no table holds.
+// The windows inside the WAL were collected by checkWAL.
+func (r *CheckReport) checkSeq() {
+	top := r.Seq.TableMaxSeq
+	if r.WAL.FlushedSeq > top {
+		r.problem("%s records sequence %d as flushed, but the tables end at %d", wal.MetaFileName, r.WAL.FlushedSeq, top)
+	}
+	var head *SeqRange
+	switch {
+	case r.WAL.Records > 0 && r.WAL.FirstSeq > top+1:
+		head = &SeqRange{top + 1, r.WAL.FirstSeq - 1}
+	case r.WAL.Records == 0 && r.WAL.FlushedSeq > top:
+		head = &SeqRange{top + 1, r.WAL.FlushedSeq}
+	}
+	if head != nil {
+		r.Seq.Windows = append([]SeqRange{*head}, r.Seq.Windows...)
+	}
+}
+
+func (r *CheckReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// checkWAL reads every record of the WAL in dir.
+func (r *CheckReport) checkWAL(dir string) error {
+	meta, err := wal.ReadMeta(dir)
+	if err != nil {
+		r.problem("%s: %v", wal.MetaFileName, err)
+	}
+	r.WAL.FlushedSeq = meta.FlushedSeq
+
+	rd, err := wal.NewReader(filepath.Join(dir, walFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	for {
+		rec, err := rd.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			r.WAL.TornTail = true
+			return nil
+		}
+		if err != nil {
+			r.problem("%s: record %d: %v", walFileName, r.WAL.Records, err)
+			return nil
+		}
+		switch {
+		case r.WAL.Records == 0:
+			r.WAL.FirstSeq = rec.SeqNum
+		case rec.SeqNum <= r.WAL.LastSeq:
+			r.problem("%s: record %d: sequence %d after %d", walFileName, r.WAL.Records, rec.SeqNum, r.WAL.LastSeq)
+		case rec.SeqNum > r.WAL.LastSeq+1:
+			r.WAL.Gaps++
+			r.Seq.Windows = append(r.Seq.Windows, SeqRange{r.WAL.LastSeq + 1, rec.SeqNum - 1})
+		}
+		if rec.Type == wal.RecordBatch {
+			if _, err := rec.BatchOps(); err != nil {
+				r.problem("%s: record %d: %v", walFileName, r.WAL.Records, err)
+			}
+		}
+		if rec.LastSeq() > r.WAL.LastSeq {
+			r.WAL.LastSeq = rec.LastSeq()
+		}
+		r.WAL.Records++
+	}
+}
+
+// checkTable verifies the table at path against its file digest, then
+// every block, that its keys are strictly increasing and that every value
+// decodes, noting the range of sequence numbers.
+func checkTable(path string) TableCheck {
+	tc := TableCheck{File: filepath.Base(path)}
+	f, err := os.Open(path)
+	if err != nil {
+		tc.Err = err.Error()
+		return tc
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		tc.Err = err.Error()
+		return tc
+	}
+	tc.Size = st.Size()
+
+	if err := sstable.VerifyFile(path); err != nil {
+		tc.Err = err.Error()
+		return tc
+	}
+	t, err := sstable.NewReader(f, tc.Size, sstable.ReaderOptions{Name: path, Paranoid: true})
+	if err != nil {
+		tc.Err = err.Error()
+		return tc
+	}
+	tc.Digest = t.Digest()
+	it := t.NewIterator(sstable.ReadOptions{})
+	defer it.Close()
+	for it.SeekGE(nil); it.Valid(); it.Next() {
+		if tc.Entries > 0 && bytes.Compare(it.Key(), tc.Largest) <= 0 {
+			tc.Err = fmt.Sprintf("key %q out of order after %q", it.Key(), tc.Largest)
+			return tc
+		}
+		_, seq, _, err := decodeTableValue(it.Value())
+		if err != nil {
+			tc.Err = fmt.Sprintf("key %q: %v", it.Key(), err)
+			return tc
+		}
+		if tc.Entries == 0 {
+			tc.Smallest = append([]byte(nil), it.Key()...)
+			tc.MinSeq = seq
+		}
+		tc.Largest = append(tc.Largest[:0], it.Key()...)
+		tc.MinSeq = min(tc.MinSeq, seq)
+		tc.MaxSeq = max(tc.MaxSeq, seq)
+		tc.Entries++
+	}
+	if err := it.Err(); err != nil {
+		tc.Err = err.Error()
+	}
+	return tc
+}
@@ -0,0 +1,56 @@
+package tinyrocks
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec encodes application values to bytes and back.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// GobCodec encodes values with encoding/gob.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// GetTyped reads key and decodes its value with c.
+func GetTyped[T any](s *Store, c Codec, key []byte, opts *ReadOptions) (T, bool, error) {
+	var out T
+	data, ok, err := s.Get(key, opts)
+	if err != nil || !ok {
+		return out, ok, err
+	}
+	if err := c.Unmarshal(data, &out); err != nil {
+		return out, false, err
+	}
+	return out, true, nil
+}
+
+// PutTyped encodes v with c and stores it under key.
+func PutTyped[T any](s *Store, c Codec, key []byte, v T, opts *WriteOptions) error {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(key, data, opts)
+}
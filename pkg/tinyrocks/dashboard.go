@@ -0,0 +1,64 @@
+package tinyrocks
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// The dashboard is a single page served next to pprof on Config.PprofAddr.
+// It polls metrics.json, so operators can watch a store without setting up
+// a metrics pipeline.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardRoutes returns the handlers for the dashboard page and its data.
+func (s *Store) dashboardRoutes() map[string]http.Handler {
+	return map[string]http.Handler{
+		"GET /{$}": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(dashboardHTML)
+		}),
+		"GET /metrics.json": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.dashboardData())
+		}),
+	}
+}
+
+// dashboardData is the document behind the dashboard: the store's metrics
+// plus state that lives outside them.
+type dashboardData struct {
+	DBID               string                     `json:"db_id"`
+	Build              string                     `json:"build"`
+	Metrics            map[string]json.RawMessage `json:"metrics"`
+	BlockCache         blockCacheStats            `json:"block_cache"`
+	Tables             int                        `json:"tables"`
+	ImmutableMemtables int                        `json:"immutable_memtables"`
+	WriteAmp           float64                    `json:"write_amp"`
+	BackgroundError    string                     `json:"background_error"`
+}
+
+type blockCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Bytes  int64 `json:"bytes"`
+}
+
+func (s *Store) dashboardData() dashboardData {
+	d := dashboardData{
+		DBID:               s.identity.ID,
+		Metrics:            s.metrics.Snapshot(),
+		Tables:             len(s.liveTables()),
+		ImmutableMemtables: s.mem.ImmutableCount(),
+		WriteAmp:           s.metrics.WriteAmplification(),
+	}
+	d.Build, _ = s.GetProperty(PropertyBuildInfo)
+	d.BackgroundError, _ = s.GetProperty(PropertyBackgroundError)
+	if s.bcache != nil {
+		d.BlockCache.Hits, d.BlockCache.Misses = s.bcache.Stats()
+		d.BlockCache.Bytes = s.bcache.Size()
+	}
+	return d
+}
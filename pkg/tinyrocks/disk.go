@@ -0,0 +1,65 @@
+package tinyrocks
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDiskFull is returned by writes and flushes while free disk space is
+// below Config.ReservedDiskMB. Unlike ErrBackgroundError it clears by
+// itself: writes succeed again, and paused flushes restart, once space is
+// freed.
+var ErrDiskFull = errors.New("tinyrocks: free disk space below the reserved headroom")
+
+// diskCheckBytes is how much may be written between free space checks
+// while space is known to be sufficient.
+const diskCheckBytes = 1 << 20
+
+// checkDisk returns ErrDiskFull if writing need more bytes would leave
+// less than the reserved headroom free in the data or WAL directory.
+// Filesystems whose free space cannot be read are not checked.
+func (s *Store) checkDisk(need int64) error {
+	if s.config.ReservedDiskMB <= 0 {
+		return nil
+	}
+	reserved := uint64(s.config.ReservedDiskMB) << 20
+	dirs := []string{s.config.DataDir}
+	if s.config.WALDir != s.config.DataDir {
+		dirs = append(dirs, s.config.WALDir)
+	}
+	for _, dir := range dirs {
+		free, err := s.freeSpace(dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if free < reserved+uint64(need) {
+			return fmt.Errorf("%w: %s has %d MB free, %d MB reserved, %d MB needed",
+				ErrDiskFull, dir, free>>20, s.config.ReservedDiskMB, need>>20)
+		}
+	}
+	return nil
+}
+
+// preflightWrite checks free space before a write of n bytes: on every
+// write while space is short, otherwise once per diskCheckBytes written.
+// While a flush is paused for space, the write must also leave room for
+// it. Callers hold s.mu.
+func (s *Store) preflightWrite(n int64) error {
+	if s.config.ReservedDiskMB <= 0 {
+		return nil
+	}
+	if s.diskFull == nil && s.sinceDiskCheck+n < diskCheckBytes {
+		s.sinceDiskCheck += n
+		return nil
+	}
+	s.sinceDiskCheck = 0
+	s.diskFull = s.checkDisk(n + s.flushNeed.Load())
+	if s.diskFull == nil {
+		// Flushes paused for space can run again.
+		s.maybeScheduleFlush()
+	}
+	return s.diskFull
+}
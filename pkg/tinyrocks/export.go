@@ -0,0 +1,132 @@
+package tinyrocks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Export streams are independent of the store's file formats:
+//
+//	[magic:8][version:1]
+//	[key_len:4][key][val_len:4][val]   once per entry, in key order
+//	[0xFFFFFFFF][count:8][crc:4]       crc covers every entry byte
+//
+// Values are exported as readers see them, without TTL timestamps.
+const (
+	exportMagic   = "KIVIDUMP"
+	exportVersion = 1
+	exportEnd     = 0xFFFFFFFF
+)
+
+// ErrBadExport is returned by Import for a stream that is not a complete
+// export.
+var ErrBadExport = errors.New("tinyrocks: malformed export stream")
+
+// importBatchOps bounds the size of the batches Import writes.
+const importBatchOps = 1000
+
+// Export writes every live entry to w and returns how many it wrote. Writes
+// made during the export may or may not be included.
+func (s *Store) Export(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(exportMagic)
+	bw.WriteByte(exportVersion)
+
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+	var n int64
+	var hdr [4]byte
+	err := s.ScanCallback(nil, nil, func(key, value []byte) error {
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(key)))
+		out.Write(hdr[:])
+		out.Write(key)
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(value)))
+		out.Write(hdr[:])
+		_, err := out.Write(value)
+		n++
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+
+	var tail [4 + 8 + 4]byte
+	binary.BigEndian.PutUint32(tail[0:], exportEnd)
+	binary.BigEndian.PutUint64(tail[4:], uint64(n))
+	binary.BigEndian.PutUint32(tail[12:], crc.Sum32())
+	bw.Write(tail[:])
+	return n, bw.Flush()
+}
+
+// Import writes every entry of an export stream to the store and returns
+// how many it wrote. Entries are written in batches as they are read, so
+// a stream found to be damaged part way leaves the entries before the
+// damage in place.
+func (s *Store) Import(r io.Reader, opts *WriteOptions) (int64, error) {
+	br := bufio.NewReader(r)
+	var head [len(exportMagic) + 1]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil || string(head[:len(exportMagic)]) != exportMagic {
+		return 0, ErrBadExport
+	}
+	if head[len(exportMagic)] != exportVersion {
+		return 0, ErrBadExport
+	}
+
+	crc := crc32.NewIEEE()
+	in := io.TeeReader(br, crc)
+	field := func() ([]byte, bool, error) {
+		var hdr [4]byte
+		if _, err := io.ReadFull(in, hdr[:]); err != nil {
+			return nil, false, ErrBadExport
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n == exportEnd {
+			return nil, true, nil
+		}
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, in, int64(n)); err != nil {
+			return nil, false, ErrBadExport
+		}
+		return buf.Bytes(), false, nil
+	}
+
+	b := NewWriteBatch()
+	var n int64
+	for {
+		sum := crc.Sum32() // before the end marker is read
+		key, end, err := field()
+		if err != nil {
+			return n, err
+		}
+		if end {
+			var tail [8 + 4]byte
+			if _, err := io.ReadFull(br, tail[:]); err != nil {
+				return n, ErrBadExport
+			}
+			if binary.BigEndian.Uint64(tail[0:]) != uint64(n+int64(b.Count())) || binary.BigEndian.Uint32(tail[8:]) != sum {
+				return n, ErrBadExport
+			}
+			break
+		}
+		val, end, err := field()
+		if err != nil || end {
+			return n, ErrBadExport
+		}
+		b.Put(key, val)
+		if b.Count() == importBatchOps {
+			if err := s.Write(b, opts); err != nil {
+				return n, err
+			}
+			n += int64(b.Count())
+			b.Clear()
+		}
+	}
+	if err := s.Write(b, opts); err != nil {
+		return n, err
+	}
+	return n + int64(b.Count()), nil
+}
@@ -0,0 +1,143 @@
+package tinyrocks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/sstable"
+)
+
+// formatFileName is the name of the file inside Config.DataDir recording
+// the on-disk format version.
+const formatFileName = "FORMAT"
+
+// FormatVersion is the on-disk format written by this version. Data
+// directories from before the FORMAT file existed are version 1.
+//
+//	1: original layout
+//	2: table footers carry a whole-file digest
+//	3: TABLES lists the live tables and their digests
+const FormatVersion = 3
+
+var (
+	// ErrFormatTooNew is returned by Open and Upgrade for a data directory
+	// written by a newer version of the store.
+	ErrFormatTooNew = errors.New("tinyrocks: data directory format is newer than this version supports")
+
+	errBadFormat = errors.New("tinyrocks: malformed FORMAT file")
+)
+
+// migration upgrades a data directory from version from to from+1.
+type migration struct {
+	from int
+	desc string
+	run  func(config *metrics.Config) error
+}
+
+// migrations is every upgrade step, in order. A step must be safe to run
+// again if it was interrupted before the new version was recorded.
+var migrations = []migration{
+	{
+		from: 1,
+		desc: "add a whole-file digest to every table footer",
+		run: func(config *metrics.Config) error {
+			nums, err := tableFiles(config.DataDir)
+			if err != nil {
+				return err
+			}
+			for _, n := range nums {
+				if _, err := sstable.UpgradeFooter(sstable.TableFileName(config.DataDir, n)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		from: 2,
+		desc: "list the tables and their digests in TABLES",
+		run: func(config *metrics.Config) error {
+			nums, err := tableFiles(config.DataDir)
+			if err != nil {
+				return err
+			}
+			digests := map[uint64]uint64{}
+			for _, n := range nums {
+				if digests[n], err = tableDigest(sstable.TableFileName(config.DataDir, n)); err != nil {
+					return err
+				}
+			}
+			return writeTableList(config.DataDir, digests)
+		},
+	},
+}
+
+// readFormat returns the format version of the data directory in dir. A
+// directory without a FORMAT file is version 1 if it already holds a
+// store, and a new store otherwise.
+func readFormat(dir string) (version int, exists bool, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, formatFileName))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(filepath.Join(dir, identityFileName)); err == nil {
+			return 1, true, nil
+		}
+		return FormatVersion, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || v < 1 {
+		return 0, false, errBadFormat
+	}
+	return v, true, nil
+}
+
+// writeFormat atomically and durably records version in dir.
+func writeFormat(dir string, version int) error {
+	return replaceFile(dir, formatFileName, []byte(fmt.Sprintf("%d\n", version)))
+}
+
+// Upgrade brings the data directory described by config to FormatVersion,
+// one migration at a time, recording the version after each step. It
+// returns the versions before and after. It must not run while the store
+// is open; Open calls it itself.
+func Upgrade(config *metrics.Config) (from, to int, err error) {
+	if config == nil {
+		config = metrics.DefaultConfig()
+	}
+	version, exists, err := readFormat(config.DataDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	if version > FormatVersion {
+		return version, version, ErrFormatTooNew
+	}
+	from = version
+	for _, m := range migrations {
+		if m.from != version {
+			continue
+		}
+		if err := m.run(config); err != nil {
+			return from, version, fmt.Errorf("tinyrocks: upgrade from format %d (%s): %w", m.from, m.desc, err)
+		}
+		version++
+		if err := writeFormat(config.DataDir, version); err != nil {
+			return from, version - 1, err
+		}
+	}
+	if version != FormatVersion {
+		return from, version, fmt.Errorf("tinyrocks: no upgrade from format %d", version)
+	}
+	if !exists {
+		if err := writeFormat(config.DataDir, version); err != nil {
+			return from, version, err
+		}
+	}
+	return from, version, nil
+}
@@ -0,0 +1,113 @@
+package tinyrocks
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// identityFileName is the name of the identity file inside Config.DataDir.
+const identityFileName = "IDENTITY"
+
+var (
+	// ErrTTLMismatch is returned by Open when Config.TTLSeconds enables or
+	// disables TTL unlike the opens that wrote the data directory: values
+	// written with TTL carry a timestamp that reads must strip.
+	ErrTTLMismatch = errors.New("tinyrocks: TTL setting does not match the data directory")
+
+	errBadIdentity = errors.New("tinyrocks: malformed IDENTITY file")
+)
+
+// TTL modes recorded in IDENTITY.
+const (
+	identityTTL   = "ttl"
+	identityNoTTL = "nottl"
+)
+
+// identity names a database: a UUID generated when it is created and an
+// incarnation counting how many times it has been opened. Files from
+// different databases can be told apart by the UUID. TTL is the TTL mode
+// the database was created with, empty for files from before it was
+// recorded.
+type identity struct {
+	ID          string
+	Incarnation uint64
+	TTL         string
+}
+
+// openIdentity reads the identity in dir, creating one for a new database,
+// and persists it with the incarnation advanced for this open. It returns
+// ErrTTLMismatch if the recorded TTL mode is not ttl; a file without one
+// takes ttl.
+func openIdentity(dir string, ttl bool) (identity, error) {
+	mode := identityNoTTL
+	if ttl {
+		mode = identityTTL
+	}
+	path := filepath.Join(dir, identityFileName)
+	var id identity
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if id.ID, err = newUUID(); err != nil {
+			return identity{}, err
+		}
+	case err != nil:
+		return identity{}, err
+	default:
+		if id, err = parseIdentity(data); err != nil {
+			return identity{}, err
+		}
+		if id.TTL != "" && id.TTL != mode {
+			return identity{}, fmt.Errorf("%w: written with %s, opened with %s", ErrTTLMismatch, id.TTL, mode)
+		}
+	}
+	id.Incarnation++
+	id.TTL = mode
+
+	body := fmt.Sprintf("%s\n%d\n%s\n", id.ID, id.Incarnation, id.TTL)
+	if err := replaceFile(dir, identityFileName, []byte(body)); err != nil {
+		return identity{}, err
+	}
+	return id, nil
+}
+
+// parseIdentity decodes "<uuid>\n<incarnation>\n<ttl mode>\n". Files
+// holding only the UUID are accepted with incarnation 0, and files without
+// the TTL mode with an empty one.
+func parseIdentity(data []byte) (identity, error) {
+	lines := strings.Fields(string(data))
+	if len(lines) == 0 || len(lines) > 3 || len(lines[0]) != 36 {
+		return identity{}, errBadIdentity
+	}
+	id := identity{ID: lines[0]}
+	if len(lines) >= 2 {
+		n, err := strconv.ParseUint(lines[1], 10, 64)
+		if err != nil {
+			return identity{}, errBadIdentity
+		}
+		id.Incarnation = n
+	}
+	if len(lines) == 3 {
+		if lines[2] != identityTTL && lines[2] != identityNoTTL {
+			return identity{}, errBadIdentity
+		}
+		id.TTL = lines[2]
+	}
+	return id, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
@@ -0,0 +1,111 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Operator combines an existing value with a sequence of merge operands.
+type Operator interface {
+	// Name identifies the operator; it is persisted so a store is never
+	// reopened with an incompatible operator.
+	Name() string
+	// FullMerge applies operands, oldest first, to existing. existing is nil
+	// when the key has no base value.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// ErrBadOperand is returned when an operand or existing value has the wrong
+// encoding for the operator.
+var ErrBadOperand = errors.New("merge: malformed operand")
+
+// UInt64Add treats values as 8-byte big-endian counters and adds operands.
+type UInt64Add struct{}
+
+// EncodeUint64 encodes v in the format UInt64Add expects.
+func EncodeUint64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+// DecodeUint64 decodes a value produced by EncodeUint64.
+func DecodeUint64(buf []byte) (uint64, error) {
+	if len(buf) != 8 {
+		return 0, ErrBadOperand
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+func (UInt64Add) Name() string { return "uint64add" }
+
+func (UInt64Add) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum uint64
+	if existing != nil {
+		v, err := DecodeUint64(existing)
+		if err != nil {
+			return nil, err
+		}
+		sum = v
+	}
+	for _, op := range operands {
+		v, err := DecodeUint64(op)
+		if err != nil {
+			return nil, err
+		}
+		sum += v
+	}
+	return EncodeUint64(sum), nil
+}
+
+// StringAppend appends operands to the existing value, separated by Sep.
+type StringAppend struct {
+	Sep []byte
+}
+
+func (StringAppend) Name() string { return "stringappend" }
+
+func (a StringAppend) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	parts := make([][]byte, 0, len(operands)+1)
+	if existing != nil {
+		parts = append(parts, existing)
+	}
+	parts = append(parts, operands...)
+	return bytes.Join(parts, a.Sep), nil
+}
+
+// Max keeps the bytewise largest of the existing value and operands.
+type Max struct{}
+
+func (Max) Name() string { return "max" }
+
+func (Max) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return pick(existing, operands, 1), nil
+}
+
+// Min keeps the bytewise smallest of the existing value and operands.
+type Min struct{}
+
+func (Min) Name() string { return "min" }
+
+func (Min) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return pick(existing, operands, -1), nil
+}
+
+// pick returns the value v for which bytes.Compare(v, other) == want holds
+// against every other candidate.
+func pick(existing []byte, operands [][]byte, want int) []byte {
+	best := existing
+	for _, op := range operands {
+		if best == nil || bytes.Compare(op, best) == want {
+			best = op
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	out := make([]byte, len(best))
+	copy(out, best)
+	return out
+}
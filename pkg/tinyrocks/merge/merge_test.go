@@ -0,0 +1,48 @@
+package merge
+
+import "testing"
+
+func TestUInt64Add(t *testing.T) {
+	var op UInt64Add
+	out, err := op.FullMerge([]byte("k"), EncodeUint64(5), [][]byte{EncodeUint64(1), EncodeUint64(10)})
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if v, _ := DecodeUint64(out); v != 16 {
+		t.Fatalf("expected 16, got %d", v)
+	}
+
+	out, _ = op.FullMerge([]byte("k"), nil, [][]byte{EncodeUint64(3)})
+	if v, _ := DecodeUint64(out); v != 3 {
+		t.Fatalf("expected 3 without base value, got %d", v)
+	}
+
+	if _, err := op.FullMerge([]byte("k"), []byte("bad"), nil); err == nil {
+		t.Fatalf("expected error on malformed existing value")
+	}
+}
+
+func TestStringAppend(t *testing.T) {
+	op := StringAppend{Sep: []byte(",")}
+	out, _ := op.FullMerge([]byte("k"), []byte("a"), [][]byte{[]byte("b"), []byte("c")})
+	if string(out) != "a,b,c" {
+		t.Fatalf("expected a,b,c got %q", out)
+	}
+	out, _ = op.FullMerge([]byte("k"), nil, [][]byte{[]byte("x")})
+	if string(out) != "x" {
+		t.Fatalf("expected x got %q", out)
+	}
+}
+
+func TestMaxMin(t *testing.T) {
+	ops := [][]byte{[]byte("b"), []byte("d"), []byte("a")}
+	if out, _ := (Max{}).FullMerge(nil, []byte("c"), ops); string(out) != "d" {
+		t.Fatalf("max: got %q", out)
+	}
+	if out, _ := (Min{}).FullMerge(nil, []byte("c"), ops); string(out) != "a" {
+		t.Fatalf("min: got %q", out)
+	}
+	if out, _ := (Max{}).FullMerge(nil, nil, nil); out != nil {
+		t.Fatalf("max of nothing should be nil, got %q", out)
+	}
+}
@@ -0,0 +1,31 @@
+package tinyrocks
+
+// ReadOptions control a single read operation.
+type ReadOptions struct {
+	// DontFillCache keeps blocks read for this operation out of the block
+	// cache, e.g. for a one-off scan that would evict hot blocks.
+	DontFillCache bool
+	// VerifyChecksums forces checksum verification of every block read.
+	VerifyChecksums bool
+	// IterateUpperBound, if set, is an exclusive upper bound for iterators.
+	// It is combined with the end key passed to NewIterator.
+	IterateUpperBound []byte
+}
+
+// WriteOptions control a single write operation.
+type WriteOptions struct {
+	// Sync fsyncs the WAL before the write is acknowledged.
+	Sync bool
+	// DisableWAL skips the WAL; the write is lost on crash until flushed.
+	DisableWAL bool
+}
+
+// DefaultReadOptions returns the read options used when nil is passed.
+func DefaultReadOptions() *ReadOptions {
+	return &ReadOptions{}
+}
+
+// DefaultWriteOptions returns the write options used when nil is passed.
+func DefaultWriteOptions() *WriteOptions {
+	return &WriteOptions{}
+}
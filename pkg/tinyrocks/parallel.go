@@ -0,0 +1,130 @@
+package tinyrocks
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelScan calls fn for every live entry in [start, end), splitting the
+// range at table block boundaries and scanning the pieces on up to
+// parallelism goroutines. A non-positive parallelism uses GOMAXPROCS.
+//
+// fn is called concurrently and must be safe for that; keys arrive in order
+// within a piece but not across pieces. The pieces are scanned
+// independently, so writes made during the scan may be seen by some and
+// not others. The first error from fn stops the scan and is returned,
+// except ErrStopScan, which stops it and returns nil.
+func (s *Store) ParallelScan(start, end []byte, parallelism int, fn func(key, value []byte) error) error {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	bounds, err := s.splitPoints(start, end, parallelism)
+	if err != nil {
+		return err
+	}
+
+	// Piece i covers [bounds[i-1], bounds[i]), with start and end at the
+	// ends.
+	pieces := make(chan int, len(bounds)+1)
+	for i := 0; i <= len(bounds); i++ {
+		pieces <- i
+	}
+	close(pieces)
+
+	var (
+		stopped  atomic.Bool
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	stop := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+		stopped.Store(true)
+	}
+	for w := 0; w < parallelism && w <= len(bounds); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pieces {
+				if stopped.Load() {
+					return
+				}
+				lo, hi := start, end
+				if i > 0 {
+					lo = bounds[i-1]
+				}
+				if i < len(bounds) {
+					hi = bounds[i]
+				}
+				err := s.ScanCallback(lo, hi, func(key, value []byte) error {
+					if stopped.Load() {
+						return ErrStopScan
+					}
+					err := fn(key, value)
+					if err != nil {
+						stop(err)
+					}
+					return err
+				})
+				if err != nil {
+					stop(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr == ErrStopScan {
+		return nil
+	}
+	return firstErr
+}
+
+// splitPoints returns up to about 4*n sorted keys strictly inside
+// (start, end), taken from the index separators of the live tables, that
+// divide the range into pieces holding similar numbers of blocks. Data
+// still in the memtable is not considered.
+func (s *Store) splitPoints(start, end []byte, n int) ([][]byte, error) {
+	var keys [][]byte
+	for _, fileNum := range s.liveTables() {
+		r, release, err := s.tcache.Acquire(fileNum)
+		if err != nil {
+			return nil, err
+		}
+		ks, err := r.IndexKeys()
+		release()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ks {
+			if bytes.Compare(k, start) > 0 && (end == nil || bytes.Compare(k, end) < 0) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	// A few pieces per worker evens out uneven pieces.
+	want := 4*n - 1
+	if len(keys) <= want {
+		return dedupe(keys), nil
+	}
+	picked := make([][]byte, 0, want)
+	for i := 1; i <= want; i++ {
+		picked = append(picked, keys[i*len(keys)/(want+1)])
+	}
+	return dedupe(picked), nil
+}
+
+// dedupe removes adjacent duplicates from sorted keys.
+func dedupe(keys [][]byte) [][]byte {
+	out := keys[:0]
+	for _, k := range keys {
+		if len(out) == 0 || !bytes.Equal(out[len(out)-1], k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package tinyrocks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arthurzhang/kivi/internal/metrics"
+)
+
+// Property names accepted by GetProperty.
+const (
+	// PropertyDBID is the database UUID, fixed when the store is created.
+	PropertyDBID = "tinyrocks.db-id"
+	// PropertyIncarnation counts how many times the store has been opened.
+	PropertyIncarnation = "tinyrocks.incarnation"
+	// PropertyNumImmutableMemtables is the number of memtables awaiting flush.
+	PropertyNumImmutableMemtables = "tinyrocks.num-immutable-mem-table"
+	// PropertyMemtablesPerGet is the mean number of memtables each Get
+	// consulted.
+	PropertyMemtablesPerGet = "tinyrocks.memtables-per-get"
+	// PropertyMemtableBloomFalsePositives counts memtable bloom filter checks
+	// that passed for an absent key.
+	PropertyMemtableBloomFalsePositives = "tinyrocks.memtable-bloom-false-positives"
+	// PropertyUptime is the number of seconds since the store was opened.
+	PropertyUptime = "tinyrocks.uptime"
+	// PropertyBuildInfo is the version, VCS revision and Go version of the
+	// binary, as "version=... revision=... go=...".
+	PropertyBuildInfo = "tinyrocks.build-info"
+	// PropertyFeatures lists the on-disk format and the optional features
+	// in effect, as comma-separated name=value pairs.
+	PropertyFeatures = "tinyrocks.features"
+	// PropertyBackgroundError is the flush failure that stopped the store,
+	// or empty while it is accepting writes. See ErrBackgroundError.
+	PropertyBackgroundError = "tinyrocks.background-error"
+)
+
+// GetProperty returns the value of the named property and whether the
+// property is known.
+func (s *Store) GetProperty(name string) (string, bool) {
+	switch name {
+	case PropertyDBID:
+		return s.identity.ID, true
+	case PropertyIncarnation:
+		return strconv.FormatUint(s.identity.Incarnation, 10), true
+	case PropertyNumImmutableMemtables:
+		return strconv.Itoa(s.mem.ImmutableCount()), true
+	case PropertyMemtablesPerGet:
+		return strconv.FormatFloat(s.metrics.MemtablesPerGet(), 'f', 2, 64), true
+	case PropertyMemtableBloomFalsePositives:
+		return strconv.FormatInt(s.metrics.MemtableBloomFalsePositives.Value(), 10), true
+	case PropertyUptime:
+		return strconv.FormatInt(int64(s.metrics.Uptime().Seconds()), 10), true
+	case PropertyBuildInfo:
+		b := metrics.Build()
+		return fmt.Sprintf("version=%s revision=%s go=%s", b.Version, b.Revision, b.GoVersion), true
+	case PropertyFeatures:
+		return s.features(), true
+	case PropertyBackgroundError:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.bgErr == nil {
+			return "", true
+		}
+		return s.bgErr.Error(), true
+	}
+	return "", false
+}
+
+// features describes the format and optional features of the store.
+// Compression and encryption are listed, though neither is implemented
+// yet, so fleet tooling can rely on the keys being present.
+func (s *Store) features() string {
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+	return strings.Join([]string{
+		"format=" + strconv.Itoa(FormatVersion),
+		"compression=none",
+		"encryption=none",
+		"ttl=" + onOff(s.ttlEnabled()),
+		"memtable_bloom=" + onOff(s.config.MemtableBloomBitsPerMB > 0),
+		"block_cache=" + onOff(s.bcache != nil),
+		"warm_block_cache=" + onOff(s.config.WarmBlockCache),
+	}, ",")
+}
@@ -1,47 +1,700 @@
 package tinyrocks
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/cache"
+	"github.com/arthurzhang/kivi/internal/clock"
+	"github.com/arthurzhang/kivi/internal/fsutil"
+	"github.com/arthurzhang/kivi/internal/invariants"
+	"github.com/arthurzhang/kivi/internal/memtable"
 	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/profiling"
+	"github.com/arthurzhang/kivi/internal/sstable"
+	"github.com/arthurzhang/kivi/internal/vlog"
+	"github.com/arthurzhang/kivi/internal/wal"
+)
+
+// walFileName is the name of the WAL file inside Config.WALDir.
+const walFileName = "wal.log"
+
+// valueLogDir is the directory of the value log inside Config.DataDir.
+const valueLogDir = "vlog"
+
+var (
+	// ErrClosed is returned by operations on a closed store.
+	ErrClosed = errors.New("tinyrocks: store closed")
+
+	// ErrBackgroundError is returned by writes and flushes once a flush has
+	// failed. The store stays readable; Resume retries the flush and
+	// accepts writes again once it succeeds.
+	ErrBackgroundError = errors.New("tinyrocks: stopped by a background error")
 )
 
 // Store represents the TinyRocks key-value store.
 type Store struct {
-	config  *metrics.Config
-	metrics *metrics.Metrics
-	// TODO: Add actual storage structures
+	config   *metrics.Config
+	metrics  *metrics.Metrics
+	identity identity
+
+	// mu serializes writes so sequence numbers are applied to the WAL and
+	// the memtable in the same order.
+	mu     sync.Mutex
+	wal    *wal.WAL
+	mem    *memtable.Memtable
+	seq    uint64
+	closed bool
+
+	// appliedSeq is the last sequence number applied to the memtable,
+	// tracked only in invariants builds.
+	appliedSeq uint64
+
+	// walFlipAt is the WAL size at which the memtable is next flipped
+	// because of Config.MaxWALSizeMB.
+	walFlipAt int64
+
+	// clock drives TTL expiry, the WAL group commit window and profiling.
+	clock clock.Clock
+
+	// Flushed tables. tables lists file numbers newest first; the slice is
+	// replaced, never modified, so readers may keep a snapshot of it.
+	tablesMu sync.RWMutex
+	tables   []uint64
+	tcache   *sstable.TableCache
+	bcache   *cache.Cache // nil if BlockCacheMB is 0
+
+	// vlog holds values moved out of flushed tables. It is opened when
+	// Config.EnableValueLog is set, or when an earlier open left one that
+	// tables may still point into; only the former moves new values.
+	vlog *vlog.Log
+
+	// flushMu serializes flushes; nextFileNum is guarded by it. bgErr is
+	// guarded by mu; it is the first flush failure, and while it is set the
+	// store refuses writes. bg tracks flushes in progress so Close can wait
+	// for them.
+	flushMu     sync.Mutex
+	nextFileNum uint64
+	digests     map[uint64]uint64 // of the tables, as listed in TABLES; guarded by flushMu
+	bgErr       error
+	bg          sync.WaitGroup
+
+	// gcMu is held while the value log is collected. readEpochs tracks
+	// the reads GC must wait out before deleting a file; see vloggc.go.
+	gcMu       sync.Mutex
+	readEpochs *readEpochs
+
+	// schedMu guards flushing, which is set while a background flush runs.
+	// It is separate from mu because writes stall holding mu, waiting for
+	// that flush to pop a memtable; the flush must be able to decide
+	// whether to stop without mu. Lock order: mu, then schedMu.
+	schedMu  sync.Mutex
+	flushing bool
+
+	// Free space preflight for Config.ReservedDiskMB. diskFull is the
+	// last check's ErrDiskFull, or nil; it and sinceDiskCheck are guarded
+	// by mu. flushNeed is the size of a flush paused for space, which
+	// writes must leave room for. freeSpace is fsutil.FreeSpace outside
+	// tests.
+	diskFull       error
+	sinceDiskCheck int64
+	flushNeed      atomic.Int64
+	freeSpace      func(dir string) (uint64, error)
+
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{}
+
+	pprofSrv *http.Server
+	profiler *profiling.Watcher
+}
+
+// Open opens the store described by config, creating its directories if
+// necessary and replaying the WAL into a fresh memtable.
+func Open(config *metrics.Config) (*Store, error) {
+	return OpenWithClock(config, clock.Real())
 }
 
-// NewStore creates a new TinyRocks store.
-func NewStore(config *metrics.Config) *Store {
+// OpenWithClock is Open with time read from clk, so tests can drive TTL
+// expiry and the group commit window without sleeping.
+func OpenWithClock(config *metrics.Config, clk clock.Clock) (*Store, error) {
 	if config == nil {
 		config = metrics.DefaultConfig()
 	}
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.WALDir, 0755); err != nil {
+		return nil, err
+	}
 
-	return &Store{
+	if _, _, err := Upgrade(config); err != nil {
+		return nil, err
+	}
+
+	m := metrics.NewMetrics()
+	s := &Store{
 		config:  config,
-		metrics: metrics.GlobalMetrics,
+		metrics: m,
+		// StallWhenFull starts off: replay has no flush to wait for.
+		// maybeScheduleFlush turns it on for config.StallWritesWhenFull
+		// while a flush runs.
+		mem: memtable.NewMemtableWithOptions(memtable.Options{
+			Threshold:    config.MemtableMB << 20,
+			MaxImmutable: config.MaxImmutableMemtables,
+			OnStall: func(d time.Duration) {
+				m.WriteStallMicros.Add(d.Microseconds())
+			},
+			BloomBits: config.MemtableMB * config.MemtableBloomBitsPerMB,
+			Observer:  m,
+		}),
+		clock:      clk,
+		freeSpace:  fsutil.FreeSpace,
+		readEpochs: newReadEpochs(),
+	}
+
+	id, err := openIdentity(config.DataDir, config.TTLSeconds > 0)
+	if err != nil {
+		return nil, err
+	}
+	s.identity = id
+
+	if err := s.openTables(); err != nil {
+		return nil, err
+	}
+	if config.BlockCacheMB > 0 {
+		s.bcache = cache.New(int64(config.BlockCacheMB) << 20)
+	}
+	s.tcache = sstable.NewTableCache(config.DataDir, config.MaxOpenFiles, sstable.ReaderOptions{Cache: s.bcache})
+	if err := s.openValueLog(); err != nil {
+		return nil, err
+	}
+	s.metrics.DiskLiveBytes.Set(s.liveBytes())
+
+	walPath := filepath.Join(config.WALDir, walFileName)
+	if err := s.replay(walPath); err != nil {
+		return nil, err
+	}
+
+	opts := wal.DefaultOptions()
+	opts.GroupCommit = config.WALGroupCommitMS > 0
+	opts.GroupCommitMS = config.WALGroupCommitMS
+	opts.TargetLatency = time.Duration(config.WALTargetP99US) * time.Microsecond
+	opts.Observer = s.metrics
+	opts.Clock = clk
+	w, err := wal.OpenWithOptions(walPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = w
+	s.walFlipAt = s.nextWALFlip()
+
+	if config.PublishMetrics {
+		if err := m.Publish(config.MetricsPrefix); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	if err := s.startProfiling(); err != nil {
+		m.Unpublish()
+		w.Close()
+		return nil, err
+	}
+
+	// Replay may have filled more than one memtable.
+	s.mu.Lock()
+	s.maybeScheduleFlush()
+	s.mu.Unlock()
+	s.startWarmup()
+	return s, nil
+}
+
+// Metrics returns the store's metrics.
+func (s *Store) Metrics() *metrics.Metrics { return s.metrics }
+
+// openValueLog opens the value log as described at Store.vlog.
+func (s *Store) openValueLog() error {
+	dir := filepath.Join(s.config.DataDir, valueLogDir)
+	if !s.config.EnableValueLog {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	opts := vlog.DefaultOptions()
+	if s.config.ValueLogFileMB > 0 {
+		opts.MaxFileSize = int64(s.config.ValueLogFileMB) << 20
+	}
+	l, err := vlog.OpenWithOptions(dir, opts)
+	if err != nil {
+		return err
+	}
+	s.vlog = l
+	return nil
+}
+
+// startProfiling starts the pprof endpoint, with the dashboard, and the
+// automatic profile capture configured in s.config.
+func (s *Store) startProfiling() error {
+	if s.config.PprofAddr != "" {
+		srv, err := profiling.Serve(s.config.PprofAddr, s.dashboardRoutes())
+		if err != nil {
+			return err
+		}
+		s.pprofSrv = srv
+	}
+	if s.config.ProfileOnStallMS > 0 || s.config.ProfileOnCompactionBacklog > 0 {
+		s.profiler = profiling.NewWatcher(s.metrics, profiling.WatcherOptions{
+			Dir:              filepath.Join(s.config.DataDir, "profiles"),
+			StallThreshold:   time.Duration(s.config.ProfileOnStallMS) * time.Millisecond,
+			BacklogThreshold: int64(s.config.ProfileOnCompactionBacklog),
+			Clock:            s.clock,
+		})
+		s.profiler.Start()
+	}
+	return nil
+}
+
+// replay applies every complete record of the WAL at path to the memtable,
+// skipping records the WAL metadata marks as already flushed.
+func (s *Store) replay(path string) error {
+	meta, err := wal.ReadMeta(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if meta.FlushedSeq > s.seq {
+		s.seq = meta.FlushedSeq
+	}
+
+	r, err := wal.NewReader(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// The memtable copies keys and values, so records may borrow the
+	// reader's buffer.
+	return r.ReplayBorrowed(func(rec *wal.Record) error {
+		ops := []*wal.Record{rec}
+		if rec.Type == wal.RecordBatch {
+			var err error
+			if ops, err = rec.BatchOps(); err != nil {
+				return err
+			}
+		}
+		// A flush may have taken only the start of a batch.
+		for _, op := range ops {
+			if op.SeqNum <= meta.FlushedSeq {
+				continue
+			}
+			if op.SeqNum > s.seq {
+				s.seq = op.SeqNum
+			}
+			if err := s.apply(op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get retrieves a value by key. A nil opts uses DefaultReadOptions.
+func (s *Store) Get(key []byte, opts *ReadOptions) ([]byte, bool, error) {
+	start := time.Now()
+	defer func() { s.metrics.RecordOp("get", time.Since(start)) }()
+
+	if opts == nil {
+		opts = DefaultReadOptions()
+	}
+	epoch := s.readEpochs.begin()
+	v, deleted, found := s.mem.Lookup(key)
+	var err error
+	if !found {
+		v, deleted, found, err = s.getFromTables(key, opts)
+	}
+	s.readEpochs.end(epoch)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found || deleted {
+		return nil, false, nil
+	}
+	ok := true
+	if s.ttlEnabled() {
+		v, ok = s.stripTTL(v)
+	}
+	return v, ok, nil
+}
+
+// Put stores a key-value pair. A nil opts uses DefaultWriteOptions.
+func (s *Store) Put(key, val []byte, opts *WriteOptions) error {
+	start := time.Now()
+	defer func() { s.metrics.RecordOp("put", time.Since(start)) }()
+
+	if s.ttlEnabled() {
+		val = s.stampTTL(val)
+	}
+	return s.write(opts, &wal.Record{Type: wal.RecordPut, Key: key, Value: val})
+}
+
+// Delete removes a key. A nil opts uses DefaultWriteOptions.
+func (s *Store) Delete(key []byte, opts *WriteOptions) error {
+	start := time.Now()
+	defer func() { s.metrics.RecordOp("del", time.Since(start)) }()
+
+	return s.write(opts, &wal.Record{Type: wal.RecordDelete, Key: key})
+}
+
+// write assigns consecutive sequence numbers to recs, logs them unless the
+// WAL is disabled for this write, and applies them to the memtable. Several
+// records are logged as one batch record, so recovery finds all of them or
+// none, and nothing is applied or uses up sequence numbers until the WAL has
+// accepted the write.
+func (s *Store) write(opts *WriteOptions, recs ...*wal.Record) error {
+	if opts == nil {
+		opts = DefaultWriteOptions()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.commit(opts, recs); err != nil {
+		return err
+	}
+	var n int64
+	for _, rec := range recs {
+		op := EventPut
+		if rec.Type == wal.RecordDelete {
+			op = EventDelete
+		}
+		s.notify(rec.Key, op, rec.SeqNum)
+		n += int64(len(rec.Key) + len(rec.Value))
+		if op == EventPut {
+			s.metrics.ValueSizes.Observe(int64(len(rec.Value)))
+		}
+	}
+	s.metrics.RecordUserWrite(n)
+	s.metrics.RecordWriteBatch(len(recs), n)
+	return nil
+}
+
+// commit is the part of write shared with value-log GC rewrites, which
+// are not user writes: it neither notifies watchers nor records write
+// metrics. Callers hold s.mu.
+func (s *Store) commit(opts *WriteOptions, recs []*wal.Record) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.bgErr != nil {
+		return s.backgroundError()
+	}
+
+	var size int64
+	for _, rec := range recs {
+		if err := rec.Validate(); err != nil {
+			return err
+		}
+		size += int64(len(rec.Key) + len(rec.Value))
+	}
+	if err := s.preflightWrite(size); err != nil {
+		return err
 	}
+	for i, rec := range recs {
+		rec.SeqNum = s.seq + 1 + uint64(i)
+	}
+	if !opts.DisableWAL {
+		log := recs[0]
+		if len(recs) > 1 {
+			log = wal.BatchRecord(recs)
+		}
+		if err := s.wal.Append(log); err != nil {
+			return err
+		}
+	}
+	s.seq += uint64(len(recs))
+	if !opts.DisableWAL {
+		if opts.Sync {
+			if err := s.wal.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rec := range recs {
+		if err := s.apply(rec); err != nil {
+			return err
+		}
+		// A batch may fill more than one memtable; the next op can only
+		// stall for room once a flush is running.
+		s.maybeScheduleFlush()
+	}
+	s.maybeFlipForWAL()
+	s.maybeScheduleFlush()
+	return nil
 }
 
-// Get retrieves a value by key.
-func (s *Store) Get(key []byte) ([]byte, bool, error) {
-	// TODO: Implement
-	return nil, false, nil
+// maybeFlipForWAL flips the memtable once the WAL has grown past walFlipAt.
+// Overwrite-heavy workloads can grow the WAL far beyond the memtable; the
+// flush of the flipped table is what lets the WAL be truncated, bounding
+// recovery time. Callers hold s.mu.
+func (s *Store) maybeFlipForWAL() {
+	if s.config.MaxWALSizeMB <= 0 || s.wal.Size() < s.walFlipAt {
+		return
+	}
+	s.mem.Flip()
+	s.walFlipAt = s.nextWALFlip()
 }
 
-// Put stores a key-value pair.
-func (s *Store) Put(key, val []byte) error {
-	// TODO: Implement
+func (s *Store) nextWALFlip() int64 {
+	return s.wal.Size() + int64(s.config.MaxWALSizeMB)<<20
+}
+
+// apply inserts rec into the memtable.
+func (s *Store) apply(rec *wal.Record) error {
+	if invariants.Enabled {
+		if rec.SeqNum <= s.appliedSeq {
+			invariants.Violation("store: applying seq %d (key %q) after seq %d", rec.SeqNum, rec.Key, s.appliedSeq)
+		}
+		s.appliedSeq = rec.SeqNum
+	}
+	switch rec.Type {
+	case wal.RecordPut:
+		return s.mem.Put(rec.Key, rec.Value, rec.SeqNum)
+	case wal.RecordDelete:
+		return s.mem.Delete(rec.Key, rec.SeqNum)
+	}
 	return nil
 }
 
-// Delete removes a key.
-func (s *Store) Delete(key []byte) error {
-	// TODO: Implement
+// maybeScheduleFlush starts a background flush if the memtable has
+// immutable tables and none is running. While it runs, writes that find
+// the immutable queue full stall if Config.StallWritesWhenFull is set.
+// Callers hold s.mu.
+func (s *Store) maybeScheduleFlush() {
+	if s.closed || s.bgErr != nil || s.diskFull != nil {
+		return
+	}
+	s.schedMu.Lock()
+	defer s.schedMu.Unlock()
+	if s.flushing || s.mem.ImmutableCount() == 0 {
+		return
+	}
+	s.flushing = true
+	s.mem.SetStallWhenFull(s.config.StallWritesWhenFull)
+	s.bg.Add(1)
+	go func() {
+		defer s.bg.Done()
+		err := s.flushImmutables()
+		for err == nil && !s.stopFlushing() {
+			err = s.flushImmutables()
+		}
+		if err != nil {
+			s.schedMu.Lock()
+			s.flushing = false
+			s.mem.SetStallWhenFull(false)
+			s.schedMu.Unlock()
+			s.mu.Lock()
+			s.setBackgroundError(err)
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// stopFlushing ends the background flush unless a write filled another
+// memtable since it last looked, and stops stalling writes, which would
+// otherwise wait for a flush that is not running.
+func (s *Store) stopFlushing() bool {
+	s.schedMu.Lock()
+	defer s.schedMu.Unlock()
+	if s.mem.ImmutableCount() > 0 {
+		return false
+	}
+	s.flushing = false
+	s.mem.SetStallWhenFull(false)
+	return true
+}
+
+// setBackgroundError records err, if it is the first flush failure, and
+// stops the store taking writes. A flush refused for lack of space only
+// pauses flushing until a write finds enough space again. Callers hold
+// s.mu.
+func (s *Store) setBackgroundError(err error) {
+	if errors.Is(err, ErrDiskFull) {
+		s.diskFull = err
+		return
+	}
+	if err != nil && s.bgErr == nil {
+		s.bgErr = err
+	}
+}
+
+// backgroundError returns the error reported for operations refused
+// because of bgErr. Callers hold s.mu.
+func (s *Store) backgroundError() error {
+	return fmt.Errorf("%w: %w", ErrBackgroundError, s.bgErr)
+}
+
+// Resume retries the flushes a background error interrupted, e.g. after
+// the operator has freed disk space, and lets writes through again if they
+// succeed. It returns nil if the store is not stopped.
+func (s *Store) Resume() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if s.bgErr == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.bg.Add(1)
+	s.mu.Unlock()
+	defer s.bg.Done()
+
+	err := s.flushImmutables()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.bgErr = err
+		return s.backgroundError()
+	}
+	s.bgErr = nil
 	return nil
 }
 
-// Iterator provides range scans.
+// Flush writes the memtable to an SSTable so its records no longer need
+// the WAL. With wait, Flush returns once every write made before the call
+// is in a table; otherwise the flush runs in the background. A failed
+// flush, waited for or not, stops the store as described at
+// ErrBackgroundError.
+func (s *Store) Flush(wait bool) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if s.bgErr != nil {
+		defer s.mu.Unlock()
+		return s.backgroundError()
+	}
+	flipped := s.mem.Flip()
+	s.bg.Add(1)
+	s.mu.Unlock()
+
+	if !wait {
+		go func() {
+			defer s.bg.Done()
+			err := s.flushAll(flipped)
+			s.mu.Lock()
+			s.setBackgroundError(err)
+			s.mu.Unlock()
+		}()
+		return nil
+	}
+	defer s.bg.Done()
+	err := s.flushAll(flipped)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setBackgroundError(err)
+	if s.bgErr != nil {
+		return s.backgroundError()
+	}
+	return err
+}
+
+// flushAll flushes the immutable tables and, if the flip that preceded it
+// could not happen because the queue was full, flips and flushes again.
+func (s *Store) flushAll(flipped bool) error {
+	if err := s.flushImmutables(); err != nil {
+		return err
+	}
+	if flipped {
+		return nil
+	}
+	s.mu.Lock()
+	again := s.mem.Flip()
+	s.mu.Unlock()
+	if !again {
+		return nil
+	}
+	return s.flushImmutables()
+}
+
+// FlushWAL writes buffered WAL records to the OS and, if sync is set, fsyncs
+// them. Writes made with DisableWAL are not covered.
+func (s *Store) FlushWAL(sync bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if sync {
+		return s.wal.Sync()
+	}
+	return s.wal.Flush()
+}
+
+// DropBlockCache empties the block cache, so following reads go to the
+// table files. Benchmarks use it to start from a cold cache.
+func (s *Store) DropBlockCache() {
+	if s.bcache != nil {
+		s.bcache.Clear()
+	}
+}
+
+// Close cancels all watches and flushes and closes the WAL. Writes made
+// with DisableWAL that have not reached an SSTable are lost.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	// Flushes use the WAL; let them finish before closing it. Value-log
+	// GC may be waiting for iterators the caller never closes.
+	s.readEpochs.close()
+	s.bg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveWarmBlocks()
+	s.tcache.Close()
+	s.stopWatchers()
+	if s.profiler != nil {
+		s.profiler.Stop()
+	}
+	if s.pprofSrv != nil {
+		s.pprofSrv.Close()
+	}
+	s.metrics.Unpublish()
+	if s.vlog != nil {
+		s.vlog.Close()
+	}
+	return s.wal.Close()
+}
+
+// Iterator provides range scans. Next must be called before the first
+// Key/Value; Seek repositions so the following Next lands on the first key
+// >= the target.
+//
+// The slices returned by Key and Value are owned by the iterator and are
+// only valid until the next call to Next, Seek or Close. Callers that keep
+// them must copy. ScanCallback offers the same contract without an
+// Iterator for consumers that only stream entries.
+//
+// Close releases the tables the iterator reads from and returns any error
+// met while reading them; every iterator must be closed.
 type Iterator interface {
 	Seek(key []byte)
 	Next() bool
@@ -50,8 +703,148 @@ type Iterator interface {
 	Close() error
 }
 
-// NewIterator creates a new iterator.
-func (s *Store) NewIterator(start, end []byte) Iterator {
-	// TODO: Implement
-	return nil
+// NewIterator creates a new iterator over [start, end). A nil start or end
+// leaves that side unbounded. A nil opts uses DefaultReadOptions.
+func (s *Store) NewIterator(start, end []byte, opts *ReadOptions) Iterator {
+	if opts == nil {
+		opts = DefaultReadOptions()
+	}
+	if opts.IterateUpperBound != nil && (end == nil || bytes.Compare(opts.IterateUpperBound, end) < 0) {
+		end = opts.IterateUpperBound
+	}
+
+	s.metrics.ScanCount.Add(1)
+	it := &storeIterator{src: s.newMergingIter(opts), start: start, end: end}
+	if s.ttlEnabled() {
+		it.filter = s.stripTTL
+	}
+	it.Seek(start)
+	return it
+}
+
+// ErrStopScan can be returned by a ScanCallback function to end the scan
+// early; ScanCallback then returns nil.
+var ErrStopScan = errors.New("tinyrocks: stop scan")
+
+// ScanCallback calls fn for every live entry in [start, end) in key order.
+// A nil start or end leaves that side unbounded. key and value are only
+// valid for the duration of the call; fn must copy them to retain them.
+// Returning a non-nil error stops the scan, and ScanCallback returns that
+// error unless it is ErrStopScan.
+func (s *Store) ScanCallback(start, end []byte, fn func(key, value []byte) error) error {
+	s.metrics.ScanCount.Add(1)
+	ttl := s.ttlEnabled()
+
+	var prev []byte // last key passed to fn, kept only in invariants builds
+	var err error
+	it := s.newMergingIter(DefaultReadOptions())
+	for it.SeekGE(start); it.Valid(); it.Next() {
+		key := it.Key()
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if invariants.Enabled {
+			if prev != nil && bytes.Compare(key, prev) <= 0 {
+				invariants.Violation("scan: key %q after %q", key, prev)
+			}
+			prev = append(prev[:0:0], key...)
+		}
+		val := it.Value()
+		if ttl {
+			v, ok := s.stripTTL(val)
+			if !ok {
+				continue
+			}
+			val = v
+		}
+		if err = fn(key, val); err != nil {
+			break
+		}
+	}
+	closeErr := it.Close()
+	if err != nil && !errors.Is(err, ErrStopScan) {
+		return err
+	}
+	return closeErr
 }
+
+// storeIterator adapts the merged memtable and table view to the Iterator
+// interface.
+type storeIterator struct {
+	src     *mergingIter
+	start   []byte
+	end     []byte
+	pending bool // src is positioned on an entry not yet returned by Next
+
+	// filter, if set, maps stored values to user values and hides entries
+	// for which it reports false.
+	filter func(stored []byte) ([]byte, bool)
+	value  []byte
+
+	// prevKey is the last key returned since the last Seek, kept only in
+	// invariants builds.
+	prevKey []byte
+	hasPrev bool
+}
+
+func (it *storeIterator) Seek(key []byte) {
+	if it.start != nil && bytes.Compare(key, it.start) < 0 {
+		key = it.start
+	}
+	it.src.SeekGE(key)
+	it.pending = true
+	it.hasPrev = false
+}
+
+func (it *storeIterator) Next() bool {
+	ok := it.next()
+	if invariants.Enabled && ok {
+		it.checkOrder()
+	}
+	return ok
+}
+
+// checkOrder asserts that keys are returned in strictly increasing order
+// and within [start, end).
+func (it *storeIterator) checkOrder() {
+	key := it.src.Key()
+	if it.hasPrev && bytes.Compare(key, it.prevKey) <= 0 {
+		invariants.Violation("iterator: key %q after %q", key, it.prevKey)
+	}
+	if (it.start != nil && bytes.Compare(key, it.start) < 0) || (it.end != nil && bytes.Compare(key, it.end) >= 0) {
+		invariants.Violation("iterator: key %q outside [%q, %q)", key, it.start, it.end)
+	}
+	it.prevKey = append(it.prevKey[:0], key...)
+	it.hasPrev = true
+}
+
+func (it *storeIterator) next() bool {
+	if it.pending {
+		it.pending = false
+	} else if it.src.Valid() {
+		it.src.Next()
+	}
+	for it.valid() {
+		if it.filter == nil {
+			it.value = it.src.Value()
+			return true
+		}
+		if v, ok := it.filter(it.src.Value()); ok {
+			it.value = v
+			return true
+		}
+		it.src.Next()
+	}
+	return false
+}
+
+func (it *storeIterator) valid() bool {
+	if !it.src.Valid() {
+		return false
+	}
+	return it.end == nil || bytes.Compare(it.src.Key(), it.end) < 0
+}
+
+func (it *storeIterator) Key() []byte   { return it.src.Key() }
+func (it *storeIterator) Value() []byte { return it.value }
+func (it *storeIterator) Close() error  { return it.src.Close() }
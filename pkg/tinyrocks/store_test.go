@@ -0,0 +1,1622 @@
+package tinyrocks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/clock"
+	"github.com/arthurzhang/kivi/internal/memtable"
+	"github.com/arthurzhang/kivi/internal/metrics"
+	"github.com/arthurzhang/kivi/internal/sstable"
+	"github.com/arthurzhang/kivi/internal/testutil"
+	"github.com/arthurzhang/kivi/internal/wal"
+)
+
+// testConfig returns a config rooted in dir.
+func testConfig(dir string) *metrics.Config {
+	cfg := metrics.DefaultConfig()
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.WALDir = filepath.Join(dir, "wal")
+	cfg.PublishMetrics = false
+	return cfg
+}
+
+func mustOpen(t *testing.T, cfg *metrics.Config) *Store {
+	t.Helper()
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return s
+}
+
+func TestStorePutGetDelete(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := mustOpen(t, testConfig(dir))
+	defer s.Close()
+
+	if err := s.Put([]byte("k"), []byte("v"), nil); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	v, ok, err := s.Get([]byte("k"), nil)
+	if err != nil || !ok || string(v) != "v" {
+		t.Fatalf("get: v=%q ok=%v err=%v", v, ok, err)
+	}
+	if err := s.Delete([]byte("k"), nil); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok, _ := s.Get([]byte("k"), nil); ok {
+		t.Fatalf("expected key deleted")
+	}
+}
+
+func TestStoreRecoverFromWAL(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	_ = s.Put([]byte("a"), []byte("1"), nil)
+	_ = s.Put([]byte("b"), []byte("2"), nil)
+	_ = s.Delete([]byte("a"), nil)
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if _, ok, _ := s.Get([]byte("a"), nil); ok {
+		t.Fatalf("delete not recovered")
+	}
+	if v, ok, _ := s.Get([]byte("b"), nil); !ok || string(v) != "2" {
+		t.Fatalf("put not recovered: %q ok=%v", v, ok)
+	}
+	// New writes must get sequence numbers above the recovered ones.
+	_ = s.Put([]byte("a"), []byte("3"), nil)
+	if v, ok, _ := s.Get([]byte("a"), nil); !ok || string(v) != "3" {
+		t.Fatalf("write after recovery hidden: %q ok=%v", v, ok)
+	}
+}
+
+func TestStoreReplaySkipsFlushed(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	_ = s.Put([]byte("a"), []byte("1"), nil) // seq 1
+	_ = s.Put([]byte("b"), []byte("2"), nil) // seq 2
+	s.Close()
+
+	if err := wal.WriteMeta(cfg.WALDir, wal.Meta{FlushedSeq: 1}); err != nil {
+		t.Fatalf("write meta: %v", err)
+	}
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if _, ok, _ := s.Get([]byte("a"), nil); ok {
+		t.Fatalf("record at or below FlushedSeq should not be replayed")
+	}
+	if _, ok, _ := s.Get([]byte("b"), nil); !ok {
+		t.Fatalf("record above FlushedSeq lost")
+	}
+}
+
+func TestStoreDisableWAL(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	_ = s.Put([]byte("logged"), []byte("1"), &WriteOptions{Sync: true})
+	_ = s.Put([]byte("unlogged"), []byte("2"), &WriteOptions{DisableWAL: true})
+	if _, ok, _ := s.Get([]byte("unlogged"), nil); !ok {
+		t.Fatalf("DisableWAL write should be readable before close")
+	}
+	if err := s.FlushWAL(true); err != nil {
+		t.Fatalf("flush wal: %v", err)
+	}
+	s.Close()
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if _, ok, _ := s.Get([]byte("logged"), nil); !ok {
+		t.Fatalf("logged write lost")
+	}
+	if _, ok, _ := s.Get([]byte("unlogged"), nil); ok {
+		t.Fatalf("DisableWAL write should not be replayed")
+	}
+}
+
+func TestStoreFlipOnWALSize(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.MaxWALSizeMB = 1
+
+	s := mustOpen(t, cfg)
+	defer s.Close()
+
+	// Overwriting one key keeps the memtable tiny while the WAL grows.
+	val := make([]byte, 4096)
+	for i := 0; i < 300; i++ {
+		_ = s.Put([]byte("hot"), val, nil)
+	}
+	if !s.mem.HasImmutable() {
+		t.Fatalf("expected memtable flip after the WAL passed MaxWALSizeMB")
+	}
+}
+
+func TestStoreIterator(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := mustOpen(t, testConfig(dir))
+	defer s.Close()
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		_ = s.Put([]byte(k), []byte("v"+k), nil)
+	}
+
+	it := s.NewIterator([]byte("b"), []byte("e"), &ReadOptions{IterateUpperBound: []byte("d")})
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	it.Seek([]byte("c"))
+	if !it.Next() || string(it.Key()) != "c" || string(it.Value()) != "vc" {
+		t.Fatalf("seek mismatch")
+	}
+}
+
+func TestStoreScanCallback(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := mustOpen(t, testConfig(dir))
+	defer s.Close()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_ = s.Put([]byte(k), []byte("v"+k), nil)
+	}
+
+	var got []string
+	err := s.ScanCallback([]byte("b"), nil, func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		if string(key) == "c" {
+			return ErrStopScan
+		}
+		return nil
+	})
+	if err != nil || len(got) != 2 || got[0] != "b=vb" || got[1] != "c=vc" {
+		t.Fatalf("scan: %v err=%v", got, err)
+	}
+
+	boom := errors.New("boom")
+	if err := s.ScanCallback(nil, []byte("b"), func(key, value []byte) error { return boom }); err != boom {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	stop := fmt.Errorf("done at a: %w", ErrStopScan)
+	if err := s.ScanCallback(nil, nil, func(key, value []byte) error { return stop }); err != nil {
+		t.Fatalf("wrapped ErrStopScan: %v", err)
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.TTLSeconds = 60
+
+	clk := clock.NewManual(time.Unix(1000, 0))
+	s, err := OpenWithClock(cfg, clk)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+
+	_ = s.Put([]byte("old"), []byte("1"), nil)
+	clk.Advance(30 * time.Second)
+	_ = s.Put([]byte("new"), []byte("2"), nil)
+
+	if v, ok, _ := s.Get([]byte("old"), nil); !ok || string(v) != "1" {
+		t.Fatalf("unexpired key: %q ok=%v", v, ok)
+	}
+
+	clk.Advance(31 * time.Second)
+	if _, ok, _ := s.Get([]byte("old"), nil); ok {
+		t.Fatalf("expected old key expired")
+	}
+	it := s.NewIterator(nil, nil, nil)
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key())+"="+string(it.Value()))
+	}
+	if len(keys) != 1 || keys[0] != "new=2" {
+		t.Fatalf("iterator should hide expired keys, got %v", keys)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := mustOpen(t, testConfig(dir))
+	defer s.Close()
+
+	events, cancel := s.Watch([]byte("user/"))
+	_ = s.Put([]byte("user/1"), []byte("a"), nil)
+	_ = s.Put([]byte("other"), []byte("b"), nil)
+	_ = s.Delete([]byte("user/1"), nil)
+
+	want := []Event{{Key: []byte("user/1"), Op: EventPut}, {Key: []byte("user/1"), Op: EventDelete}}
+	var lastSeq uint64
+	for i, w := range want {
+		select {
+		case ev := <-events:
+			if string(ev.Key) != string(w.Key) || ev.Op != w.Op || ev.Seq <= lastSeq {
+				t.Fatalf("event %d: got %+v", i, ev)
+			}
+			lastSeq = ev.Seq
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestTypedCodecs(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := mustOpen(t, testConfig(dir))
+	defer s.Close()
+
+	type user struct {
+		Name string
+		Age  int
+	}
+	for _, c := range []Codec{JSONCodec{}, GobCodec{}} {
+		in := user{Name: "ada", Age: 36}
+		if err := PutTyped(s, c, []byte("u"), in, nil); err != nil {
+			t.Fatalf("%T put: %v", c, err)
+		}
+		out, ok, err := GetTyped[user](s, c, []byte("u"), nil)
+		if err != nil || !ok || out != in {
+			t.Fatalf("%T get: %+v ok=%v err=%v", c, out, ok, err)
+		}
+	}
+
+	if _, ok, err := GetTyped[user](s, JSONCodec{}, []byte("missing"), nil); ok || err != nil {
+		t.Fatalf("missing key: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestStoreIdentity(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	id, ok := s.GetProperty(PropertyDBID)
+	if !ok || len(id) != 36 {
+		t.Fatalf("db id %q ok=%v", id, ok)
+	}
+	if n, _ := s.GetProperty(PropertyIncarnation); n != "1" {
+		t.Fatalf("incarnation %s, want 1", n)
+	}
+	s.Close()
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if again, _ := s.GetProperty(PropertyDBID); again != id {
+		t.Fatalf("db id changed across reopen: %s -> %s", id, again)
+	}
+	if n, _ := s.GetProperty(PropertyIncarnation); n != "2" {
+		t.Fatalf("incarnation %s, want 2", n)
+	}
+	if _, ok := s.GetProperty("tinyrocks.no-such-property"); ok {
+		t.Fatalf("unknown property reported as known")
+	}
+	if up, _ := s.GetProperty(PropertyUptime); up != "0" {
+		t.Fatalf("uptime %s just after open, want 0", up)
+	}
+	if bi, _ := s.GetProperty(PropertyBuildInfo); !strings.Contains(bi, "go=go1.") {
+		t.Fatalf("build info %q lacks the Go version", bi)
+	}
+	if f, _ := s.GetProperty(PropertyFeatures); !strings.HasPrefix(f, fmt.Sprintf("format=%d,", FormatVersion)) || !strings.Contains(f, "ttl=off") {
+		t.Fatalf("features %q", f)
+	}
+
+	other := mustOpen(t, testConfig(filepath.Join(dir, "other")))
+	defer other.Close()
+	if oid, _ := other.GetProperty(PropertyDBID); oid == id {
+		t.Fatalf("two databases share id %s", id)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = s.Put([]byte(k), []byte("v"), nil)
+	}
+	_ = s.Put([]byte("skipped"), []byte("v"), &WriteOptions{DisableWAL: true})
+	_ = s.Put([]byte("d"), []byte("v"), nil)
+	s.Close()
+
+	f, err := os.Create(filepath.Join(cfg.DataDir, "000001.sst"))
+	if err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	w := sstable.NewWriter(f, sstable.DefaultWriterOptions())
+	_ = w.Add([]byte("k1"), encodeTableValue(nil, memtable.Entry{Value: []byte("v1"), Seq: 2}))
+	_ = w.Add([]byte("k2"), encodeTableValue(nil, memtable.Entry{Value: []byte("v2"), Seq: 1}))
+	if err := w.Finish(); err != nil {
+		t.Fatalf("finish table: %v", err)
+	}
+	f.Close()
+	_ = writeTableList(cfg.DataDir, map[uint64]uint64{1: w.Digest()})
+
+	r, err := Check(cfg)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !r.OK() {
+		t.Fatalf("unexpected problems: %v", r.Problems)
+	}
+	if r.WAL.Records != 4 || r.WAL.FirstSeq != 1 || r.WAL.LastSeq != 5 || r.WAL.Gaps != 1 {
+		t.Fatalf("wal report %+v", r.WAL)
+	}
+	if len(r.Tables) != 1 || r.Tables[0].Entries != 2 || string(r.Tables[0].Largest) != "k2" {
+		t.Fatalf("table report %+v", r.Tables)
+	}
+	if tc := r.Tables[0]; tc.MinSeq != 1 || tc.MaxSeq != 2 {
+		t.Fatalf("table seqs %d..%d, want 1..2", tc.MinSeq, tc.MaxSeq)
+	}
+	if fmt.Sprint(r.Seq.Windows) != "[{4 4}]" { // the DisableWAL write
+		t.Fatalf("seq windows %v", r.Seq.Windows)
+	}
+
+	// A table other than the one TABLES recorded.
+	_ = writeTableList(cfg.DataDir, map[uint64]uint64{1: w.Digest() + 1})
+	if r, _ := Check(cfg); r.OK() {
+		t.Fatalf("digest differing from TABLES not reported")
+	}
+	_ = writeTableList(cfg.DataDir, map[uint64]uint64{1: w.Digest()})
+
+	// Corrupt the first data block of the table.
+	path := filepath.Join(cfg.DataDir, "000001.sst")
+	data, _ := os.ReadFile(path)
+	data[0] ^= 0xff
+	_ = os.WriteFile(path, data, 0644)
+	if r, _ := Check(cfg); r.OK() || r.Tables[0].Err == "" {
+		t.Fatalf("corrupt table not reported: %+v", r)
+	}
+}
+
+func TestCheckSequences(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = s.Put([]byte(k), []byte("v"), nil)
+	}
+	_ = s.Flush(true)
+	_ = s.Put([]byte("d"), []byte("v"), nil)
+	s.Close()
+
+	r, err := Check(cfg)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !r.OK() || r.Seq.TableMaxSeq != 3 || len(r.Seq.Windows) != 0 {
+		t.Fatalf("consistent store: problems %v, table max seq %d, windows %v", r.Problems, r.Seq.TableMaxSeq, r.Seq.Windows)
+	}
+
+	// Losing the only table leaves the flushed writes nowhere.
+	tables, _ := filepath.Glob(filepath.Join(cfg.DataDir, "*.sst"))
+	for _, name := range tables {
+		_ = os.Remove(name)
+	}
+	r, err = Check(cfg)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if r.OK() || fmt.Sprint(r.Seq.Windows) != "[{1 3}]" {
+		t.Fatalf("lost table: problems %v, windows %v", r.Problems, r.Seq.Windows)
+	}
+}
+
+func TestStoreIsolatedMetrics(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	cfgA := testConfig(filepath.Join(dir, "a"))
+	cfgA.PublishMetrics, cfgA.MetricsPrefix = true, "test_isolated_a_"
+	cfgB := testConfig(filepath.Join(dir, "b"))
+	cfgB.PublishMetrics, cfgB.MetricsPrefix = true, "test_isolated_b_"
+
+	a := mustOpen(t, cfgA)
+	b := mustOpen(t, cfgB)
+	defer b.Close()
+	_ = a.Put([]byte("k"), []byte("v"), nil)
+
+	if n := a.Metrics().PutCount.Value(); n != 1 {
+		t.Fatalf("store a puts %d, want 1", n)
+	}
+	if n := b.Metrics().PutCount.Value(); n != 0 {
+		t.Fatalf("store b saw store a's put: %d", n)
+	}
+	if v := expvar.Get("test_isolated_a_ops_put"); v == nil || v.String() != "1" {
+		t.Fatalf("published put count %v", v)
+	}
+
+	if _, err := Open(cfgA); err == nil {
+		t.Fatalf("expected error opening a second store under a published prefix")
+	}
+	// Closing releases the prefix for a reopen.
+	a.Close()
+	a = mustOpen(t, cfgA)
+	a.Close()
+}
+
+func TestStoreDefaultConfigTwice(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "b"} {
+		cfg := metrics.DefaultConfig()
+		cfg.DataDir = filepath.Join(dir, name, "data")
+		cfg.WALDir = filepath.Join(dir, name, "wal")
+		s := mustOpen(t, cfg)
+		defer s.Close()
+	}
+}
+
+func scanKeys(t *testing.T, s *Store) []string {
+	t.Helper()
+	it := s.NewIterator(nil, nil, nil)
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	return keys
+}
+
+func TestStoreFlush(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	for _, k := range []string{"a", "b", "c", "d", "x"} {
+		_ = s.Put([]byte(k), []byte("1"), nil)
+	}
+	_ = s.Delete([]byte("b"), nil)
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if n := len(s.liveTables()); n != 1 {
+		t.Fatalf("%d tables after flush, want 1", n)
+	}
+	if s.wal.Size() != 0 {
+		t.Fatalf("flushed records left in the WAL: %d bytes", s.wal.Size())
+	}
+	if d, n := s.metrics.FlushQueueDepth.Load(), s.metrics.FlushQueueWait.Count(); d != 0 || n != 1 {
+		t.Fatalf("flush queue depth %d with %d waits, want 0 and 1", d, n)
+	}
+
+	// Memtable tombstones hide table values, and tables written later hide
+	// earlier ones.
+	_ = s.Delete([]byte("c"), nil)
+	_ = s.Put([]byte("d"), []byte("2"), nil)
+	_ = s.Delete([]byte("x"), nil)
+	_ = s.Put([]byte("e"), []byte("2"), nil)
+	check := func(stage string) {
+		t.Helper()
+		for k, want := range map[string]string{"a": "1", "b": "", "c": "", "d": "2", "e": "2", "x": ""} {
+			v, ok, err := s.Get([]byte(k), nil)
+			if err != nil || ok != (want != "") || string(v) != want {
+				t.Fatalf("%s: get %s = %q ok=%v err=%v, want %q", stage, k, v, ok, err, want)
+			}
+		}
+		want := []string{"a=1", "d=2", "e=2"}
+		if got := scanKeys(t, s); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: scan %v, want %v", stage, got, want)
+		}
+	}
+	check("memtable over table")
+
+	_ = s.Flush(true)
+	check("two tables")
+	_ = s.Put([]byte("z"), []byte("3"), nil) // left in the WAL
+	s.Close()
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if n := len(s.liveTables()); n != 2 {
+		t.Fatalf("%d tables after reopen, want 2", n)
+	}
+	if v, ok, _ := s.Get([]byte("z"), nil); !ok || string(v) != "3" {
+		t.Fatalf("unflushed write lost: %q ok=%v", v, ok)
+	}
+	_ = s.Delete([]byte("z"), nil)
+	check("reopened")
+}
+
+func TestStoreBackgroundFlush(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.MemtableMB = 1
+
+	s := mustOpen(t, cfg)
+	val := make([]byte, 300<<10)
+	for i := 0; i < 8; i++ {
+		_ = s.Put([]byte(fmt.Sprintf("k%d", i)), val, nil)
+	}
+	// Crossing the memtable size flushes without an explicit Flush.
+	err := testutil.WaitFor(func() bool { return len(s.liveTables()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("no table written after filling the memtable")
+	}
+
+	_ = s.Put([]byte("last"), []byte("v"), nil)
+	if err := s.Flush(false); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	s.Close() // waits for the background flush
+	m := s.Metrics()
+	if d := m.FlushQueueDepth.Load(); d != 0 {
+		t.Fatalf("flush queue depth %d after every flush ran", d)
+	}
+	if n, flushes := m.FlushQueueWait.Count(), m.FlushCount.Value(); n != flushes || n < 2 {
+		t.Fatalf("%d queue waits for %d flushes", n, flushes)
+	}
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if s.wal.Size() != 0 {
+		t.Fatalf("background flush left %d WAL bytes", s.wal.Size())
+	}
+	for i := 0; i < 8; i++ {
+		if v, ok, _ := s.Get([]byte(fmt.Sprintf("k%d", i)), nil); !ok || len(v) != len(val) {
+			t.Fatalf("k%d lost: len=%d ok=%v", i, len(v), ok)
+		}
+	}
+	if _, ok, _ := s.Get([]byte("last"), nil); !ok {
+		t.Fatalf("last write lost")
+	}
+	if s.Metrics().FlushCount.Value() != 0 {
+		t.Fatalf("metrics of a fresh store should start at zero")
+	}
+}
+
+func TestStoreBackgroundError(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	defer s.Close()
+	_ = s.Put([]byte("a"), []byte("1"), nil)
+
+	// Without its data directory the flush cannot create the table.
+	moved := cfg.DataDir + ".moved"
+	if err := os.Rename(cfg.DataDir, moved); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Flush(false)
+	err := testutil.WaitFor(func() bool {
+		msg, _ := s.GetProperty(PropertyBackgroundError)
+		return msg != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed background flush not reported")
+	}
+	if err := s.Put([]byte("b"), []byte("2"), nil); !errors.Is(err, ErrBackgroundError) {
+		t.Fatalf("put after background error: %v, want ErrBackgroundError", err)
+	}
+	if err := s.Flush(true); !errors.Is(err, ErrBackgroundError) {
+		t.Fatalf("flush after background error: %v, want ErrBackgroundError", err)
+	}
+	if v, ok, err := s.Get([]byte("a"), nil); err != nil || !ok || string(v) != "1" {
+		t.Fatalf("get after background error: %q ok=%v err=%v", v, ok, err)
+	}
+	if err := s.Resume(); !errors.Is(err, ErrBackgroundError) {
+		t.Fatalf("resume before the fix: %v, want ErrBackgroundError", err)
+	}
+
+	if err := os.Rename(moved, cfg.DataDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Resume(); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if msg, _ := s.GetProperty(PropertyBackgroundError); msg != "" {
+		t.Fatalf("background error %q left after resume", msg)
+	}
+	if len(s.liveTables()) != 1 {
+		t.Fatalf("resume did not flush the stranded memtable")
+	}
+	if err := s.Put([]byte("b"), []byte("2"), nil); err != nil {
+		t.Fatalf("put after resume: %v", err)
+	}
+	if got := scanKeys(t, s); fmt.Sprint(got) != "[a=1 b=2]" {
+		t.Fatalf("scan %v after resume", got)
+	}
+}
+
+func TestStoreReservedDisk(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.ReservedDiskMB = 10
+
+	s := mustOpen(t, cfg)
+	defer s.Close()
+	var free atomic.Uint64
+	free.Store(100 << 20)
+	s.freeSpace = func(string) (uint64, error) { return free.Load(), nil }
+
+	if err := s.Put([]byte("a"), make([]byte, 1<<20), nil); err != nil {
+		t.Fatalf("put with space to spare: %v", err)
+	}
+
+	// The flush needs room for the memtable on top of the reservation.
+	free.Store(10<<20 + 512<<10)
+	if err := s.Flush(true); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("flush into the reservation: %v, want ErrDiskFull", err)
+	}
+	if len(s.liveTables()) != 0 {
+		t.Fatalf("flush wrote a table despite the reservation")
+	}
+	if err := s.Put([]byte("b"), []byte("2"), nil); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("put into the reservation: %v, want ErrDiskFull", err)
+	}
+	if msg, _ := s.GetProperty(PropertyBackgroundError); msg != "" {
+		t.Fatalf("running short of space stopped the store: %s", msg)
+	}
+
+	// Freed space lets writes through and restarts the paused flush.
+	free.Store(100 << 20)
+	if err := s.Put([]byte("b"), []byte("2"), nil); err != nil {
+		t.Fatalf("put after space was freed: %v", err)
+	}
+	err := testutil.WaitFor(func() bool { return len(s.liveTables()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("paused flush did not restart")
+	}
+	if got := len(scanKeys(t, s)); got != 2 {
+		t.Fatalf("scan saw %d keys, want 2", got)
+	}
+
+	// Where free space cannot be read, writes are not checked.
+	s.freeSpace = func(string) (uint64, error) { return 0, errors.ErrUnsupported }
+	if err := s.Put([]byte("c"), []byte("3"), nil); err != nil {
+		t.Fatalf("put with free space unknown: %v", err)
+	}
+}
+
+func TestWriteBatchSavepoint(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	s := mustOpen(t, testConfig(dir))
+	defer s.Close()
+
+	_ = s.Put([]byte("gone"), []byte("v"), nil)
+
+	b := NewWriteBatch()
+	b.Put([]byte("a"), []byte("1"))
+	b.SetSavepoint()
+	b.Put([]byte("b"), []byte("2"))
+	b.SetSavepoint()
+	b.Put([]byte("c"), []byte("3"))
+	if err := b.RollbackToSavepoint(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	b.Delete([]byte("gone"))
+	if err := b.PopSavepoint(); err != nil {
+		t.Fatalf("pop: %v", err)
+	}
+	if err := b.RollbackToSavepoint(); err != ErrNoSavepoint {
+		t.Fatalf("rollback without savepoint: %v, want ErrNoSavepoint", err)
+	}
+	if b.Count() != 3 {
+		t.Fatalf("count = %d, want 3", b.Count())
+	}
+
+	seq := s.seq
+	if err := s.Write(b, nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if s.seq != seq+3 {
+		t.Fatalf("batch used seqs %d..%d, want 3", seq+1, s.seq)
+	}
+	if n, largest := s.Metrics().WriteBatchOps.Count(), s.Metrics().WriteBatchOps.Percentile(100); n != 2 || largest != 3 {
+		t.Fatalf("%d batches recorded, largest %d ops; want 2 and 3", n, largest)
+	}
+	if n := s.Metrics().ValueSizes.Count(); n != 3 {
+		t.Fatalf("%d value sizes recorded, want 3 puts", n)
+	}
+	if w, p := s.Metrics().WriteCount.Value(), s.Metrics().PutCount.Value(); w != 1 || p != 1 {
+		t.Fatalf("%d writes and %d puts recorded, want the batch apart from the put", w, p)
+	}
+	want := []string{"a=1", "b=2"}
+	if got := scanKeys(t, s); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("scan %v, want %v", got, want)
+	}
+}
+
+func TestStoreSeqOrderAcrossRecovery(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(filepath.Join(dir, "live"))
+
+	const writers, batches, perBatch = 8, 50, 5
+	s := mustOpen(t, cfg)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				id := fmt.Sprintf("w%d-b%02d", w, i)
+				b := NewWriteBatch()
+				for j := 0; j < perBatch; j++ {
+					b.Put([]byte(fmt.Sprintf("%s-%d", id, j)), []byte(id))
+				}
+				if err := s.Write(b, &WriteOptions{Sync: true}); err != nil {
+					t.Errorf("write %s: %v", id, err)
+				}
+				if w == 0 && i == batches/2 {
+					_ = s.Flush(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every write was synced, so a copy taken now is what a crash leaves.
+	if err := os.CopyFS(filepath.Join(dir, "crash"), os.DirFS(filepath.Join(dir, "live"))); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	s.Close()
+	cfg = testConfig(filepath.Join(dir, "crash"))
+	s = mustOpen(t, cfg)
+	defer s.Close()
+
+	const total = writers * batches * perBatch
+	if s.seq != total {
+		t.Fatalf("recovered seq %d, want %d", s.seq, total)
+	}
+	if n := len(scanKeys(t, s)); n != total {
+		t.Fatalf("recovered %d keys, want %d", n, total)
+	}
+
+	// Every batch is one WAL record, its ops on consecutive sequence
+	// numbers.
+	rd, err := wal.NewReader(filepath.Join(cfg.WALDir, walFileName))
+	if err != nil {
+		t.Fatalf("wal reader: %v", err)
+	}
+	defer rd.Close()
+	var last uint64
+	err = rd.Replay(func(rec *wal.Record) error {
+		if rec.SeqNum <= last {
+			t.Fatalf("wal seq %d after %d", rec.SeqNum, last)
+		}
+		last = rec.LastSeq()
+		ops, err := rec.BatchOps()
+		if err != nil || len(ops) != perBatch {
+			t.Fatalf("wal record at seq %d: %d ops, err %v; want a batch of %d", rec.SeqNum, len(ops), err, perBatch)
+		}
+		for _, op := range ops {
+			if string(op.Value) != string(ops[0].Value) {
+				t.Fatalf("wal record at seq %d mixes batches %s and %s", rec.SeqNum, ops[0].Value, op.Value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	seq := s.seq
+	_ = s.Put([]byte("after"), []byte("v"), nil)
+	if s.seq != seq+1 {
+		t.Fatalf("write after recovery got seq %d, want %d", s.seq, seq+1)
+	}
+}
+
+// recordingHandler collects the operations passed to it by Iterate.
+type recordingHandler struct{ ops []string }
+
+func (h *recordingHandler) Put(key, val []byte) error {
+	h.ops = append(h.ops, "put "+string(key)+"="+string(val))
+	return nil
+}
+
+func (h *recordingHandler) Delete(key []byte) error {
+	h.ops = append(h.ops, "del "+string(key))
+	return nil
+}
+
+func TestWriteBatchEncode(t *testing.T) {
+	b := NewWriteBatch()
+	b.Put([]byte("a"), []byte("1"))
+	b.Delete([]byte("b"))
+	b.Put([]byte("c"), nil)
+
+	data := b.Encode()
+	got, err := DecodeWriteBatch(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var h recordingHandler
+	if err := got.Iterate(&h); err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	want := []string{"put a=1", "del b", "put c="}
+	if fmt.Sprint(h.ops) != fmt.Sprint(want) {
+		t.Fatalf("decoded ops %q, want %q", h.ops, want)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := DecodeWriteBatch(data[:i]); err == nil {
+			t.Fatalf("decoded batch truncated to %d bytes", i)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	bad[0] = 99
+	if _, err := DecodeWriteBatch(bad); err != ErrBatchVersion {
+		t.Fatalf("unknown version: %v, want ErrBatchVersion", err)
+	}
+}
+
+func TestStoreWarmBlockCache(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.BlockCacheMB = 1
+	cfg.WarmBlockCache = true
+
+	s := mustOpen(t, cfg)
+	for i := 0; i < 1000; i++ {
+		_ = s.Put([]byte(fmt.Sprintf("key%04d", i)), []byte("value"), nil)
+	}
+	_ = s.Flush(true)
+	_, _, _ = s.Get([]byte("key0500"), nil)
+	cached := s.bcache.Size()
+	if cached == 0 {
+		t.Fatalf("get did not fill the block cache")
+	}
+	s.Close()
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	err := testutil.WaitFor(func() bool { return s.bcache.Size() == cached }, 5*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("warmed cache holds %d bytes, want %d", s.bcache.Size(), cached)
+	}
+}
+
+func TestStoreBigScan(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.BlockSizeKB = 1
+	cfg.BlockCacheMB = 1
+	cfg.BigScanBlocks = 4
+
+	s := mustOpen(t, cfg)
+	defer s.Close()
+	val := make([]byte, 100)
+	for i := 0; i < 1000; i++ {
+		_ = s.Put([]byte(fmt.Sprintf("key%04d", i)), val, nil)
+	}
+	_ = s.Flush(true)
+
+	// Reads with DontFillCache leave the cache alone.
+	_, _, _ = s.Get([]byte("key0500"), &ReadOptions{DontFillCache: true})
+	base := s.bcache.Size() // the index block, cached on open
+	if n := len(s.bcache.Keys()); n != 1 {
+		t.Fatalf("%d blocks cached after a no-fill get, want only the index", n)
+	}
+
+	n := len(scanKeys(t, s))
+	if n != 1000 {
+		t.Fatalf("scan saw %d keys", n)
+	}
+	if got := s.Metrics().BigScans.Value(); got != 1 {
+		t.Fatalf("big scans = %d, want 1", got)
+	}
+	if blocks := len(s.bcache.Keys()) - 1; blocks > cfg.BigScanBlocks+1 {
+		t.Fatalf("big scan cached %d blocks (%d bytes over the index)", blocks, s.bcache.Size()-base)
+	}
+
+	s.DropBlockCache()
+	if s.bcache.Size() != 0 {
+		t.Fatalf("%d bytes cached after DropBlockCache", s.bcache.Size())
+	}
+	if v, ok, err := s.Get([]byte("key0500"), nil); err != nil || !ok || len(v) != 100 {
+		t.Fatalf("get after DropBlockCache: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestStoreParallelScan(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.BlockSizeKB = 1
+
+	s := mustOpen(t, cfg)
+	defer s.Close()
+	for i := 0; i < 3000; i++ {
+		_ = s.Put([]byte(fmt.Sprintf("key%04d", i)), []byte("v"), nil)
+		if i == 1000 || i == 2000 {
+			_ = s.Flush(true)
+		}
+	}
+	_ = s.Delete([]byte("key0500"), nil)
+
+	bounds, _ := s.splitPoints(nil, nil, 4)
+	if len(bounds) < 2 {
+		t.Fatalf("%d split points, want several", len(bounds))
+	}
+
+	var mu sync.Mutex
+	var got []string
+	err := s.ParallelScan([]byte("key0100"), []byte("key2900"), 4, func(key, value []byte) error {
+		mu.Lock()
+		got = append(got, string(key))
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parallel scan: %v", err)
+	}
+	sort.Strings(got)
+	var want []string
+	for i := 100; i < 2900; i++ {
+		if i != 500 {
+			want = append(want, fmt.Sprintf("key%04d", i))
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("parallel scan saw %d keys, want %d", len(got), len(want))
+	}
+
+	boom := errors.New("boom")
+	err = s.ParallelScan(nil, nil, 4, func(key, value []byte) error { return boom })
+	if err != boom {
+		t.Fatalf("callback error: %v, want boom", err)
+	}
+	err = s.ParallelScan(nil, nil, 4, func(key, value []byte) error { return ErrStopScan })
+	if err != nil {
+		t.Fatalf("stop scan: %v", err)
+	}
+}
+
+func TestStoreFormatUpgrade(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	_ = s.Put([]byte("a"), []byte("1"), nil)
+	_ = s.Flush(true)
+	s.Close()
+	if v, exists, _ := readFormat(cfg.DataDir); !exists || v != FormatVersion {
+		t.Fatalf("new store at format %d (exists=%v), want %d", v, exists, FormatVersion)
+	}
+
+	// A directory from before FORMAT existed is upgraded stepwise. Its
+	// tables end in [index_handle:16]["tinyrock"] and it has no TABLES.
+	os.Remove(filepath.Join(cfg.DataDir, formatFileName))
+	os.Remove(filepath.Join(cfg.DataDir, tableListFileName))
+	path := sstable.TableFileName(cfg.DataDir, 1)
+	data, _ := os.ReadFile(path)
+	data = append(data[:len(data)-16], "tinyrock"...)
+	_ = os.WriteFile(path, data, 0644)
+
+	from, to, err := Upgrade(cfg)
+	if err != nil || from != 1 || to != FormatVersion {
+		t.Fatalf("upgrade: %d -> %d, %v", from, to, err)
+	}
+	if from, to, _ = Upgrade(cfg); from != to {
+		t.Fatalf("second upgrade moved %d -> %d", from, to)
+	}
+	if r, err := Check(cfg); err != nil || !r.OK() {
+		t.Fatalf("upgraded store: %v %v", r.Problems, err)
+	}
+	s = mustOpen(t, cfg)
+	v, ok, err := s.Get([]byte("a"), nil)
+	s.Close()
+	if err != nil || !ok || string(v) != "1" {
+		t.Fatalf("get from upgraded table: %q ok=%v err=%v", v, ok, err)
+	}
+
+	_ = writeFormat(cfg.DataDir, FormatVersion+1)
+	if _, err := Open(cfg); err != ErrFormatTooNew {
+		t.Fatalf("open of a newer format: %v, want ErrFormatTooNew", err)
+	}
+}
+
+func TestStoreExportImport(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	src := mustOpen(t, testConfig(filepath.Join(dir, "src")))
+	defer src.Close()
+	for i := 0; i < 2500; i++ {
+		_ = src.Put([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("v%d", i)), nil)
+		if i == 1000 {
+			_ = src.Flush(true)
+		}
+	}
+	_ = src.Delete([]byte("key0042"), nil)
+
+	var buf bytes.Buffer
+	n, err := src.Export(&buf)
+	if err != nil || n != 2499 {
+		t.Fatalf("export: %d entries, %v", n, err)
+	}
+
+	dst := mustOpen(t, testConfig(filepath.Join(dir, "dst")))
+	defer dst.Close()
+	if n, err := dst.Import(bytes.NewReader(buf.Bytes()), nil); err != nil || n != 2499 {
+		t.Fatalf("import: %d entries, %v", n, err)
+	}
+	if got, want := scanKeys(t, dst), scanKeys(t, src); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("imported store differs: %d keys, want %d", len(got), len(want))
+	}
+
+	data := buf.Bytes()
+	for _, cut := range []int{0, 5, len(data) / 2, len(data) - 1} {
+		if _, err := dst.Import(bytes.NewReader(data[:cut]), nil); err != ErrBadExport {
+			t.Fatalf("import of %d/%d bytes: %v, want ErrBadExport", cut, len(data), err)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	bad[20] ^= 0xff
+	if _, err := dst.Import(bytes.NewReader(bad), nil); err != ErrBadExport {
+		t.Fatalf("import of damaged stream: %v, want ErrBadExport", err)
+	}
+}
+
+func TestStoreDashboard(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.PprofAddr = "127.0.0.1:0"
+	s := mustOpen(t, cfg)
+	defer s.Close()
+	if err := s.Put([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + s.pprofSrv.Addr
+
+	resp, err := http.Get(base + "/metrics.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d struct {
+		DBID    string                     `json:"db_id"`
+		Metrics map[string]json.RawMessage `json:"metrics"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode metrics.json: %v", err)
+	}
+	if id, _ := s.GetProperty(PropertyDBID); d.DBID != id {
+		t.Fatalf("db id %q, want %q", d.DBID, id)
+	}
+	if puts := string(d.Metrics["ops_put"]); puts != "1" {
+		t.Fatalf("ops_put %s, want 1", puts)
+	}
+
+	resp, err = http.Get(base + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("dashboard content type %q", ct)
+	}
+	if resp, err = http.Get(base + "/nope"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("unknown path returned %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestStoreValueLog(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.EnableValueLog = true
+
+	s := mustOpen(t, cfg)
+	big := strings.Repeat("v", 1024)
+	_ = s.Put([]byte("a"), []byte(big), nil)
+	_ = s.Put([]byte("b"), []byte("small"), nil)
+	_ = s.Put([]byte("c"), []byte(big), nil)
+	_ = s.Delete([]byte("c"), nil)
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(cfg.DataDir, valueLogDir, "*.vlog"))
+	if len(files) != 1 {
+		t.Fatalf("value log files %v, want one", files)
+	}
+	// Only a's value is at least MinBlobSizeBytes: one entry of a 12-byte
+	// header, the key and the value.
+	st, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() != int64(12+1+len(big)) {
+		t.Fatalf("value log holds %d bytes, want only a's value", st.Size())
+	}
+	check := func(stage string) {
+		t.Helper()
+		for k, want := range map[string]string{"a": big, "b": "small", "c": ""} {
+			v, ok, err := s.Get([]byte(k), nil)
+			if err != nil || ok != (want != "") || string(v) != want {
+				t.Fatalf("%s: get %s = %d bytes ok=%v err=%v, want %d bytes", stage, k, len(v), ok, err, len(want))
+			}
+		}
+		if got, want := scanKeys(t, s), []string{"a=" + big, "b=small"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: scan returned %d entries, want a and b", stage, len(got))
+		}
+	}
+	check("flushed")
+	s.Close()
+
+	// Tables keep pointing into the log after it is disabled.
+	cfg.EnableValueLog = false
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	check("reopened without the value log")
+}
+
+func TestStoreDiskUsage(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.EnableValueLog = true
+
+	s := mustOpen(t, cfg)
+	for i := 0; i < 10; i++ {
+		_ = s.Put([]byte(fmt.Sprintf("k%d", i)), bytes.Repeat([]byte("v"), 512), nil)
+	}
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	_ = s.Put([]byte("k0"), []byte("unflushed"), nil)
+	if err := s.FlushWAL(true); err != nil {
+		t.Fatalf("flush wal: %v", err)
+	}
+
+	u, err := s.EstimateDiskUsage()
+	if err != nil {
+		t.Fatalf("estimate: %v", err)
+	}
+	table, err := os.Stat(sstable.TableFileName(cfg.DataDir, s.liveTables()[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.SSTBytes != table.Size() || u.WALBytes != s.wal.Size() || u.ValueLogBytes < 10*512 {
+		t.Fatalf("usage %+v, want sst %d, wal %d and the values in the value log", u, table.Size(), s.wal.Size())
+	}
+	// Nothing is overwritten yet: the table and the whole value log are live.
+	live := table.Size() + u.ValueLogBytes
+	m := s.Metrics()
+	if m.DiskLiveBytes.Value() != live {
+		t.Fatalf("live bytes %d, want the table's and the value log's %d", m.DiskLiveBytes.Value(), live)
+	}
+	if sa, want := m.SpaceAmplification(), float64(u.Total())/float64(live); sa != want {
+		t.Fatalf("space amplification %.2f, want %.2f", sa, want)
+	}
+	s.Close()
+
+	// Reopening counts the existing tables and values as live.
+	s = mustOpen(t, cfg)
+	if n := s.Metrics().DiskLiveBytes.Value(); n != live {
+		t.Fatalf("live bytes %d after reopen, want %d", n, live)
+	}
+	s.Close()
+
+	// A WAL directory inside the data directory is counted once.
+	cfg.WALDir = filepath.Join(cfg.DataDir, "wal")
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	_ = s.Put([]byte("k0"), []byte("unflushed"), nil)
+	if err := s.FlushWAL(true); err != nil {
+		t.Fatalf("flush wal: %v", err)
+	}
+	if u, err = s.EstimateDiskUsage(); err != nil {
+		t.Fatalf("estimate: %v", err)
+	}
+	if u.WALBytes != s.wal.Size() {
+		t.Fatalf("nested wal counted as %d bytes, want %d", u.WALBytes, s.wal.Size())
+	}
+}
+
+func TestStoreReadOptions(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	defer s.Close()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_ = s.Put([]byte(k), []byte("value-of-"+k), nil)
+	}
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// An upper bound alone limits a scan of the table.
+	it := s.NewIterator(nil, nil, &ReadOptions{IterateUpperBound: []byte("c")})
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Close(); err != nil || fmt.Sprint(keys) != "[a b]" {
+		t.Fatalf("bounded scan %v err=%v, want [a b]", keys, err)
+	}
+
+	// Flip a byte of b's value on disk. Only checksummed reads notice.
+	path := sstable.TableFileName(cfg.DataDir, s.liveTables()[0])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("value-of-b"))
+	data[i] = 'V'
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.DropBlockCache()
+
+	if _, _, err := s.Get([]byte("b"), &ReadOptions{VerifyChecksums: true, DontFillCache: true}); err == nil {
+		t.Fatalf("checksummed read of a corrupt block succeeded")
+	}
+	if v, ok, err := s.Get([]byte("b"), &ReadOptions{DontFillCache: true}); err != nil || !ok || string(v) != "Value-of-b" {
+		t.Fatalf("unchecked read = %q ok=%v err=%v", v, ok, err)
+	}
+	if n := s.bcache.Size(); n != 0 {
+		t.Fatalf("reads with DontFillCache cached %d bytes", n)
+	}
+	if _, _, err := s.Get([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.bcache.Size() == 0 {
+		t.Fatalf("default read did not fill the cache")
+	}
+}
+
+func TestStoreFlushRangeTombstone(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	for _, k := range []string{"a", "b", "m"} {
+		_ = s.Put([]byte(k), []byte("1"), nil)
+	}
+	_ = s.Flush(true)
+
+	// The store has no DeleteRange yet; a tombstone can only come from the
+	// memtable directly.
+	s.mu.Lock()
+	s.seq++
+	_ = s.mem.DeleteRange([]byte("a"), []byte("c"), s.seq)
+	s.mu.Unlock()
+	_ = s.Put([]byte("a"), []byte("2"), nil)
+	_ = s.Put([]byte("bb"), []byte("2"), nil)
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	want := []string{"a=2", "bb=2", "m=1"}
+	if got := scanKeys(t, s); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("after flush: %v, want %v", got, want)
+	}
+	if _, ok, _ := s.Get([]byte("b"), nil); ok {
+		t.Fatalf("range-deleted key visible after flush")
+	}
+	if err := s.Put([]byte("z"), []byte("1"), nil); err != nil {
+		t.Fatalf("put after flush: %v", err)
+	}
+	s.Close()
+
+	// The range delete reached the tables, not just the memtable.
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	want = append(want, "z=1")
+	if got := scanKeys(t, s); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("after reopen: %v, want %v", got, want)
+	}
+}
+
+func TestStoreWriteStall(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	cfg := testConfig(dir)
+	cfg.MemtableMB = 1
+	cfg.MaxImmutableMemtables = 1
+	cfg.StallWritesWhenFull = true
+	s := mustOpen(t, cfg)
+	defer s.Close()
+
+	// Hold flushes so the first full memtable stays queued.
+	s.flushMu.Lock()
+	val := bytes.Repeat([]byte("v"), 600<<10)
+	_ = s.Put([]byte("a"), val, nil)
+	_ = s.Put([]byte("b"), val, nil) // fills the queue
+
+	done := make(chan error, 1)
+	go func() { done <- s.Put([]byte("c"), val, nil) }()
+	select {
+	case err := <-done:
+		s.flushMu.Unlock()
+		t.Fatalf("write should stall while a flush is queued, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.flushMu.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("stalled put: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("write still stalled after the flush ran")
+	}
+	if us := s.metrics.WriteStallMicros.Value(); us < (20 * time.Millisecond).Microseconds() {
+		t.Fatalf("write_stall_us = %d", us)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if v, ok, err := s.Get([]byte(k), nil); err != nil || !ok || len(v) != len(val) {
+			t.Fatalf("get %s: ok=%v err=%v", k, ok, err)
+		}
+	}
+}
+
+func TestWriteBatchTornRecovery(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	_ = s.Put([]byte("a"), []byte("1"), nil)
+	b := NewWriteBatch()
+	b.Put([]byte("b"), []byte("2"))
+	b.Delete([]byte("a"))
+	b.Put([]byte("c"), []byte("3"))
+	if err := s.Write(b, nil); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s.Close()
+
+	// Cut the log inside the batch record, past its first op, as a crash
+	// part way through writing it would.
+	path := filepath.Join(cfg.WALDir, walFileName)
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := os.Truncate(path, st.Size()-4); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	if s.seq != 1 {
+		t.Fatalf("recovered seq %d, want 1", s.seq)
+	}
+	want := []string{"a=1"}
+	if got := scanKeys(t, s); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("recovered %v, want %v and none of the batch", got, want)
+	}
+}
+
+func TestStoreTableList(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+
+	s := mustOpen(t, cfg)
+	_ = s.Put([]byte("a"), []byte("1"), nil)
+	_ = s.Flush(true)
+	_ = s.Put([]byte("b"), []byte("2"), nil)
+	_ = s.Flush(true)
+	s.Close()
+
+	digests, exists, err := readTableList(cfg.DataDir)
+	if err != nil || !exists || len(digests) != 2 {
+		t.Fatalf("table list %v exists=%v err=%v", digests, exists, err)
+	}
+	for n, want := range digests {
+		if got, err := tableDigest(sstable.TableFileName(cfg.DataDir, n)); err != nil || got != want {
+			t.Fatalf("table %d digest %016x err=%v, TABLES records %016x", n, got, err, want)
+		}
+	}
+
+	// A table file a flush wrote but never listed is dropped on open; its
+	// records are still in the WAL.
+	orphan := sstable.TableFileName(cfg.DataDir, 7)
+	data, _ := os.ReadFile(sstable.TableFileName(cfg.DataDir, 1))
+	_ = os.WriteFile(orphan, data, 0644)
+	if r, _ := Check(cfg); !r.OK() || len(r.Tables) != 2 {
+		t.Fatalf("check with an unlisted table: %v, %d tables", r.Problems, len(r.Tables))
+	}
+	s = mustOpen(t, cfg)
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("unlisted table kept: %v", err)
+	}
+	_ = s.Put([]byte("c"), []byte("3"), nil)
+	_ = s.Flush(true)
+	if n := s.nextFileNum; n != 4 {
+		t.Fatalf("next file number %d, want 4", n)
+	}
+	s.Close()
+
+	// Without TABLES the store cannot tell live tables from leftovers.
+	os.Remove(filepath.Join(cfg.DataDir, tableListFileName))
+	if _, err := Open(cfg); err != errNoTableList {
+		t.Fatalf("open without TABLES: %v, want errNoTableList", err)
+	}
+}
+
+func TestStoreValueLogGC(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.EnableValueLog = true
+	cfg.ValueLogFileMB = 1
+
+	val := func(gen byte) string { return strings.Repeat(string('a'+gen), 64<<10) }
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%02d", i)) }
+	s := mustOpen(t, cfg)
+	for i := 0; i < 20; i++ {
+		_ = s.Put(key(i), []byte(val(0)), nil)
+	}
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// The first file holds k00-k15. Overwrite or delete most of them.
+	for i := 0; i < 10; i++ {
+		_ = s.Put(key(i), []byte(val(1)), nil)
+	}
+	_ = s.Delete(key(10), nil)
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			want := val(0)
+			switch {
+			case i < 10:
+				want = val(1)
+			case i == 10:
+				want = ""
+			}
+			v, ok, err := s.Get(key(i), nil)
+			if err != nil || ok != (want != "") || string(v) != want {
+				t.Fatalf("%s: get %s = %d bytes ok=%v err=%v", stage, key(i), len(v), ok, err)
+			}
+		}
+		if got := scanKeys(t, s); len(got) != 19 {
+			t.Fatalf("%s: scan returned %d entries, want 19", stage, len(got))
+		}
+	}
+
+	// An iterator opened before the collection keeps the file readable.
+	it := s.NewIterator(nil, nil, nil)
+	done := make(chan error, 1)
+	go func() {
+		s.gcMu.Lock()
+		defer s.gcMu.Unlock()
+		done <- s.collectValueLog()
+	}()
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err := it.Close(); err != nil || n != 19 {
+		t.Fatalf("iterator during gc: %d entries, err %v", n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	first := filepath.Join(cfg.DataDir, valueLogDir, "000001.vlog")
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("first value log file not collected: %v", err)
+	}
+	if s.Metrics().VLogGCFiles.Value() == 0 {
+		t.Fatalf("no value log file counted as collected")
+	}
+	check("collected")
+	if err := s.Flush(true); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	check("rewrites flushed")
+	s.Close()
+
+	s = mustOpen(t, cfg)
+	defer s.Close()
+	check("reopened")
+}
+
+func TestStoreTTLModeRecorded(t *testing.T) {
+	dir := testutil.MustTempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(dir)
+	cfg.TTLSeconds = 60
+
+	s := mustOpen(t, cfg)
+	s.Close()
+	cfg.TTLSeconds = 0
+	if _, err := Open(cfg); !errors.Is(err, ErrTTLMismatch) {
+		t.Fatalf("open without TTL: %v, want ErrTTLMismatch", err)
+	}
+	// Only enabling or disabling TTL changes how values are stored.
+	cfg.TTLSeconds = 120
+	s = mustOpen(t, cfg)
+	s.Close()
+
+	// A file from before the mode was recorded takes the mode of the open.
+	path := filepath.Join(cfg.DataDir, identityFileName)
+	id, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, id[:37], 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.TTLSeconds = 0
+	s = mustOpen(t, cfg)
+	s.Close()
+	cfg.TTLSeconds = 60
+	if _, err := Open(cfg); !errors.Is(err, ErrTTLMismatch) {
+		t.Fatalf("open with TTL after recording none: %v, want ErrTTLMismatch", err)
+	}
+}
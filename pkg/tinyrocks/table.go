@@ -0,0 +1,604 @@
+package tinyrocks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/arthurzhang/kivi/internal/fsutil"
+	"github.com/arthurzhang/kivi/internal/memtable"
+	"github.com/arthurzhang/kivi/internal/sstable"
+	"github.com/arthurzhang/kivi/internal/vlog"
+	"github.com/arthurzhang/kivi/internal/wal"
+)
+
+// Table values are stored as [kind:1][seq:8][value], so a delete flushed
+// from the memtable keeps hiding older tables. A blob's value is the
+// encoded vlog.Pointer of a value moved to the value log on flush.
+const (
+	tableKindDelete byte = 0
+	tableKindPut    byte = 1
+	tableKindBlob   byte = 2
+
+	tableValueHeader = 9
+)
+
+var (
+	errBadTableValue = errors.New("tinyrocks: malformed table value")
+	errNoValueLog    = errors.New("tinyrocks: table value is in the value log, which is not open")
+)
+
+func encodeTableValue(dst []byte, e memtable.Entry) []byte {
+	kind := tableKindPut
+	if e.Deleted {
+		kind = tableKindDelete
+	}
+	dst = append(dst, kind)
+	dst = binary.BigEndian.AppendUint64(dst, e.Seq)
+	if !e.Deleted {
+		dst = append(dst, e.Value...)
+	}
+	return dst
+}
+
+func encodeBlobValue(dst []byte, seq uint64, ptr vlog.Pointer) []byte {
+	dst = append(dst, tableKindBlob)
+	dst = binary.BigEndian.AppendUint64(dst, seq)
+	return append(dst, ptr.Encode()...)
+}
+
+func decodeTableValue(v []byte) (val []byte, seq uint64, kind byte, err error) {
+	if len(v) < tableValueHeader || v[0] > tableKindBlob {
+		return nil, 0, 0, errBadTableValue
+	}
+	return v[tableValueHeader:], binary.BigEndian.Uint64(v[1:]), v[0], nil
+}
+
+// resolveTableValue decodes a stored table value, reading blobs from the
+// value log.
+func (s *Store) resolveTableValue(v []byte) (val []byte, deleted bool, err error) {
+	val, _, kind, err := decodeTableValue(v)
+	if err != nil || kind != tableKindBlob {
+		return val, kind == tableKindDelete, err
+	}
+	if s.vlog == nil {
+		return nil, false, errNoValueLog
+	}
+	ptr, err := vlog.DecodePointer(val)
+	if err != nil {
+		return nil, false, err
+	}
+	val, err = s.vlog.Read(ptr)
+	return val, false, err
+}
+
+// openTables lists the tables in DataDir, newest first, and sets the next
+// file number past the newest.
+func (s *Store) openTables() error {
+	digests, exists, err := readTableList(s.config.DataDir)
+	if err != nil {
+		return err
+	}
+	files, err := tableFiles(s.config.DataDir)
+	if err != nil {
+		return err
+	}
+	if !exists && len(files) > 0 {
+		return errNoTableList
+	}
+	// Unlisted tables are left by unfinished flushes; see tableListFileName.
+	for _, n := range files {
+		if _, ok := digests[n]; !ok {
+			if err := os.Remove(sstable.TableFileName(s.config.DataDir, n)); err != nil {
+				return err
+			}
+		}
+	}
+	nums := slices.Collect(maps.Keys(digests))
+	sort.Slice(nums, func(i, j int) bool { return nums[i] > nums[j] })
+	s.tables = nums
+	s.digests = digests
+	s.nextFileNum = 1
+	if len(nums) > 0 {
+		s.nextFileNum = nums[0] + 1
+	}
+	return nil
+}
+
+// liveTables returns the file numbers of the flushed tables, newest first.
+func (s *Store) liveTables() []uint64 {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+	return s.tables
+}
+
+func tableReadOptions(opts *ReadOptions) sstable.ReadOptions {
+	return sstable.ReadOptions{VerifyChecksums: opts.VerifyChecksums, DontFillCache: opts.DontFillCache}
+}
+
+// getFromTables returns the newest state of key in the flushed tables.
+func (s *Store) getFromTables(key []byte, opts *ReadOptions) (val []byte, deleted, found bool, err error) {
+	raw, found, err := s.tableValue(key, opts)
+	if err != nil || !found {
+		return nil, false, false, err
+	}
+	val, deleted, err = s.resolveTableValue(raw)
+	return val, deleted, true, err
+}
+
+// tableValue returns the stored value of key in the newest table that
+// holds it, without reading blobs.
+func (s *Store) tableValue(key []byte, opts *ReadOptions) ([]byte, bool, error) {
+	for _, fileNum := range s.liveTables() {
+		r, release, err := s.tcache.Acquire(fileNum)
+		if err != nil {
+			return nil, false, err
+		}
+		raw, ok, err := r.Get(key, tableReadOptions(opts))
+		release()
+		if err != nil || ok {
+			return raw, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// flushImmutables writes every immutable memtable to a table, oldest first.
+// Each is installed before it leaves the memtable, so reads always find its
+// data in one place or the other.
+func (s *Store) flushImmutables() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	for {
+		imm := s.mem.OldestImmutable()
+		if imm == nil {
+			return nil
+		}
+		s.metrics.RecordFlushDequeued(time.Since(imm.Sealed()))
+		if err := s.flushTable(imm); err != nil {
+			// imm stays queued for Resume to retry.
+			s.metrics.RecordFlushQueued()
+			return err
+		}
+		s.mem.PopImmutable()
+		s.maybeScheduleGC()
+	}
+}
+
+// flushTable writes imm to a new table, installs it, records its highest
+// sequence number as flushed and drops the covered WAL records. Once the
+// table is installed, the blobs it shadows are discarded in the value log;
+// if the flush fails, so are the values it moved there, as the retry moves
+// them again. Callers hold flushMu.
+func (s *Store) flushTable(imm *memtable.Skiplist) (err error) {
+	start := time.Now()
+	entries, err := s.applyRangeDeletes(imm, imm.Entries())
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	var maxSeq uint64
+	var need int64
+	for _, e := range entries {
+		if e.Seq > maxSeq {
+			maxSeq = e.Seq
+		}
+		need += int64(len(e.Key) + len(e.Value))
+	}
+	if err := s.checkDisk(need); err != nil {
+		s.flushNeed.Store(need)
+		return err
+	}
+	s.flushNeed.Store(0)
+
+	blobs, err := s.separateValues(entries)
+	defer func() {
+		if err != nil {
+			s.discardBlobs(blobs)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	shadowed, err := s.shadowedBlobs(entries)
+	if err != nil {
+		return err
+	}
+	fileNum := s.nextFileNum
+	size, digest, err := s.writeTable(sstable.TableFileName(s.config.DataDir, fileNum), entries, blobs)
+	if err != nil {
+		return err
+	}
+	s.nextFileNum++
+	digests := maps.Clone(s.digests)
+	digests[fileNum] = digest
+	if err := writeTableList(s.config.DataDir, digests); err != nil {
+		return err
+	}
+	s.digests = digests
+
+	s.tablesMu.Lock()
+	s.tables = append([]uint64{fileNum}, s.tables...)
+	s.tablesMu.Unlock()
+	s.discardBlobs(shadowed)
+	appended := blobs
+	blobs = nil // installed: a later failure must not discard them
+
+	if err := wal.WriteMeta(s.config.WALDir, wal.Meta{FlushedSeq: maxSeq}); err != nil {
+		return err
+	}
+	if err := s.wal.Truncate(maxSeq); err != nil {
+		return err
+	}
+	s.metrics.RecordFlush(time.Since(start), size)
+	s.metrics.DiskLiveBytes.Add(size + blobBytes(appended) - blobBytes(shadowed))
+	return nil
+}
+
+// applyRangeDeletes adds to entries, the entries of imm, a point tombstone
+// for every key in the tables that a range tombstone of imm covers. Tables
+// cannot hold range tombstones, and every key in them is older than imm,
+// so deleting the keys the ranges cover there keeps their effect. Keys imm
+// holds itself are left as entries has them.
+func (s *Store) applyRangeDeletes(imm *memtable.Skiplist, entries []memtable.Entry) ([]memtable.Entry, error) {
+	ranges := imm.RangeTombstones()
+	if len(ranges) == 0 {
+		return entries, nil
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[string(e.Key)] = true
+	}
+	for _, fileNum := range s.liveTables() {
+		r, release, err := s.tcache.Acquire(fileNum)
+		if err != nil {
+			return nil, err
+		}
+		it := r.NewIterator(sstable.ReadOptions{DontFillCache: true})
+		for _, rt := range ranges {
+			for it.SeekGE(rt.Start); it.Valid() && bytes.Compare(it.Key(), rt.End) < 0; it.Next() {
+				if !seen[string(it.Key())] {
+					seen[string(it.Key())] = true
+					entries = append(entries, memtable.Entry{Key: bytes.Clone(it.Key()), Seq: rt.Seq, Deleted: true})
+				}
+			}
+		}
+		err = it.Err()
+		if cerr := it.Close(); err == nil {
+			err = cerr
+		}
+		release()
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(entries, func(a, b memtable.Entry) int { return bytes.Compare(a.Key, b.Key) })
+	return entries, nil
+}
+
+// shadowedBlobs returns the blobs in the installed tables that entries
+// overwrite or delete.
+func (s *Store) shadowedBlobs(entries []memtable.Entry) ([]vlog.Pointer, error) {
+	if s.vlog == nil {
+		return nil, nil
+	}
+	var ptrs []vlog.Pointer
+	for _, e := range entries {
+		ptr, ok, err := s.tableBlob(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ptrs = append(ptrs, ptr)
+		}
+	}
+	return ptrs, nil
+}
+
+// discardBlobs marks the non-zero pointers in ptrs as discarded in the
+// value log.
+func (s *Store) discardBlobs(ptrs []vlog.Pointer) {
+	for _, ptr := range ptrs {
+		if ptr != (vlog.Pointer{}) {
+			s.vlog.MarkDiscarded(ptr)
+		}
+	}
+}
+
+// blobBytes returns the value-log bytes ptrs point at.
+func blobBytes(ptrs []vlog.Pointer) int64 {
+	var n int64
+	for _, ptr := range ptrs {
+		n += int64(ptr.Len)
+	}
+	return n
+}
+
+// separateValues moves the values of entries of at least
+// Config.MinBlobSizeBytes to the value log, if it is enabled, and returns
+// their pointers by entry index; the zero Pointer marks values that stay
+// in the table. On error, the pointers returned are those appended. The log is synced before returning, so
+// the table never points at a value that could be lost.
+func (s *Store) separateValues(entries []memtable.Entry) ([]vlog.Pointer, error) {
+	if s.vlog == nil || !s.config.EnableValueLog {
+		return nil, nil
+	}
+	blobs := make([]vlog.Pointer, len(entries))
+	for i, e := range entries {
+		if e.Deleted || len(e.Value) < s.config.MinBlobSizeBytes {
+			continue
+		}
+		ptr, err := s.vlog.Append(e.Key, e.Value)
+		if err != nil {
+			return blobs, err
+		}
+		blobs[i] = ptr
+	}
+	return blobs, s.vlog.Sync()
+}
+
+// writeTable writes entries to a table at path through a temporary file and
+// returns its size and digest. Entries with a non-zero pointer in blobs, which may be
+// nil, are written as blobs. The table and its directory entry are synced.
+func (s *Store) writeTable(path string, entries []memtable.Entry, blobs []vlog.Pointer) (int64, uint64, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmp) // no-op once renamed
+
+	bw := bufio.NewWriter(f)
+	w := sstable.NewWriter(bw, sstable.WriterOptions{
+		BlockSize:       s.config.BlockSizeKB << 10,
+		RestartInterval: s.config.RestartInterval,
+	})
+	var buf []byte
+	for i, e := range entries {
+		if blobs != nil && blobs[i] != (vlog.Pointer{}) {
+			buf = encodeBlobValue(buf[:0], e.Seq, blobs[i])
+		} else {
+			buf = encodeTableValue(buf[:0], e)
+		}
+		if err = w.Add(e.Key, buf); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Finish()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	var size int64
+	if st, serr := f.Stat(); err == nil && serr == nil {
+		size = st.Size()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, err
+	}
+	return size, w.Digest(), fsutil.SyncDir(filepath.Dir(path))
+}
+
+// iterSource is one sorted input of a mergingIter. Deleted reports a
+// tombstone that hides the key in older sources.
+type iterSource interface {
+	SeekGE(key []byte)
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Deleted() bool
+	Next()
+}
+
+// tableSource adapts a table iterator, decoding stored values. Blobs are
+// only read by load, once the merge has picked the entry: older tables
+// may point into value-log files collected since their keys were
+// overwritten.
+type tableSource struct {
+	store   *Store
+	it      *sstable.Iterator
+	release func()
+	val     []byte
+	blob    bool
+	deleted bool
+	err     error
+}
+
+func (t *tableSource) SeekGE(key []byte) { t.it.SeekGE(key); t.decode() }
+func (t *tableSource) Next()             { t.it.Next(); t.decode() }
+func (t *tableSource) Valid() bool       { return t.err == nil && t.it.Valid() }
+func (t *tableSource) Key() []byte       { return t.it.Key() }
+func (t *tableSource) Value() []byte     { return t.val }
+func (t *tableSource) Deleted() bool     { return t.deleted }
+
+func (t *tableSource) decode() {
+	if !t.it.Valid() {
+		if err := t.it.Err(); err != nil && t.err == nil {
+			t.err = err
+		}
+		return
+	}
+	val, _, kind, err := decodeTableValue(t.it.Value())
+	if err != nil {
+		t.err = err
+		return
+	}
+	t.val, t.blob, t.deleted = val, kind == tableKindBlob, kind == tableKindDelete
+}
+
+// load reads the current value from the value log if it is a blob.
+func (t *tableSource) load() error {
+	if !t.blob {
+		return nil
+	}
+	val, _, err := t.store.resolveTableValue(t.it.Value())
+	if err != nil {
+		return err
+	}
+	t.val, t.blob = val, false
+	return nil
+}
+
+func (t *tableSource) Close() error {
+	err := t.it.Close()
+	t.release()
+	if t.err != nil {
+		return t.err
+	}
+	return err
+}
+
+// mergingIter merges the memtable with the flushed tables, yielding the
+// newest live entry of every key in order. Sources are ordered newest first.
+type mergingIter struct {
+	srcs   []iterSource
+	tables []*tableSource // the table sources in srcs, to close
+	cur    int            // source holding the current key, or -1
+	skip   []byte         // copy of a key being skipped
+	err    error
+
+	// bigScanAt is Config.BigScanBlocks; once the tables have read more
+	// blocks than that, big is set and they stop filling the cache.
+	bigScanAt int
+	big       bool
+	store     *Store
+
+	// epoch is the read epoch held until Close, so value-log GC does not
+	// delete files the tables point into; closed guards a second Close.
+	epoch  uint64
+	closed bool
+}
+
+// newMergingIter snapshots the memtable and the live tables.
+func (s *Store) newMergingIter(opts *ReadOptions) *mergingIter {
+	m := &mergingIter{cur: -1, bigScanAt: s.config.BigScanBlocks, store: s}
+	m.epoch = s.readEpochs.begin()
+	m.srcs = append(m.srcs, s.mem.NewIteratorWithTombstones())
+	for _, fileNum := range s.liveTables() {
+		r, release, err := s.tcache.Acquire(fileNum)
+		if err != nil {
+			m.err = err
+			break
+		}
+		t := &tableSource{store: s, it: r.NewIterator(tableReadOptions(opts)), release: release}
+		m.srcs = append(m.srcs, t)
+		m.tables = append(m.tables, t)
+	}
+	return m
+}
+
+func (m *mergingIter) SeekGE(key []byte) {
+	if m.err != nil {
+		return
+	}
+	for _, src := range m.srcs {
+		src.SeekGE(key)
+	}
+	m.settle()
+	m.checkBigScan()
+}
+
+func (m *mergingIter) Next() {
+	if m.cur < 0 {
+		return
+	}
+	m.advancePast(m.srcs[m.cur].Key())
+	m.settle()
+	m.checkBigScan()
+}
+
+// checkBigScan stops the tables filling the block cache once they have
+// read more than bigScanAt blocks between them.
+func (m *mergingIter) checkBigScan() {
+	if m.bigScanAt <= 0 || m.big {
+		return
+	}
+	n := 0
+	for _, t := range m.tables {
+		n += t.it.BlocksRead()
+	}
+	if n <= m.bigScanAt {
+		return
+	}
+	m.big = true
+	for _, t := range m.tables {
+		t.it.SetFillCache(false)
+	}
+	m.store.metrics.BigScans.Add(1)
+}
+
+func (m *mergingIter) Valid() bool   { return m.err == nil && m.cur >= 0 }
+func (m *mergingIter) Key() []byte   { return m.srcs[m.cur].Key() }
+func (m *mergingIter) Value() []byte { return m.srcs[m.cur].Value() }
+
+// Close releases the tables and returns the first error met while reading.
+func (m *mergingIter) Close() error {
+	err := m.err
+	for _, t := range m.tables {
+		if cerr := t.Close(); err == nil {
+			err = cerr
+		}
+	}
+	m.tables = nil
+	if !m.closed {
+		m.closed = true
+		m.store.readEpochs.end(m.epoch)
+	}
+	return err
+}
+
+// settle points cur at the smallest key among the sources, preferring the
+// newest source, skips keys whose newest state is a tombstone and reads the
+// value it lands on if that is a blob.
+func (m *mergingIter) settle() {
+	for {
+		m.cur = -1
+		for i, src := range m.srcs {
+			if src.Valid() && (m.cur < 0 || bytes.Compare(src.Key(), m.srcs[m.cur].Key()) < 0) {
+				m.cur = i
+			}
+		}
+		if m.cur < 0 {
+			return
+		}
+		if !m.srcs[m.cur].Deleted() {
+			break
+		}
+		m.advancePast(m.srcs[m.cur].Key())
+	}
+	if t, ok := m.srcs[m.cur].(*tableSource); ok {
+		if err := t.load(); err != nil {
+			m.err = err
+			m.cur = -1
+		}
+	}
+}
+
+// advancePast moves every source positioned on key to its next entry.
+func (m *mergingIter) advancePast(key []byte) {
+	m.skip = append(m.skip[:0], key...)
+	for _, src := range m.srcs {
+		if src.Valid() && bytes.Equal(src.Key(), m.skip) {
+			src.Next()
+		}
+	}
+}
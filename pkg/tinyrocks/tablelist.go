@@ -0,0 +1,133 @@
+package tinyrocks
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/arthurzhang/kivi/internal/fsutil"
+	"github.com/arthurzhang/kivi/internal/sstable"
+)
+
+// tableListFileName is the name of the file inside Config.DataDir listing
+// the live tables, one "<file number> <digest>" line each, the digest in
+// hex as recorded in the table's footer. A flush lists its table before
+// the WAL gives up the records in it, so a table file missing from the
+// list was left by a flush that did not finish and holds nothing the WAL
+// does not.
+const tableListFileName = "TABLES"
+
+var (
+	errBadTableList = errors.New("tinyrocks: malformed TABLES file")
+	errNoTableList  = errors.New("tinyrocks: TABLES is missing but the data directory holds tables")
+)
+
+// readTableList returns the digest of every table listed in dir, by file
+// number. A missing list yields an empty map and exists false.
+func readTableList(dir string) (digests map[uint64]uint64, exists bool, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, tableListFileName))
+	if os.IsNotExist(err) {
+		return map[uint64]uint64{}, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	digests = map[uint64]uint64{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		num, digest, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, true, errBadTableList
+		}
+		n, err := strconv.ParseUint(num, 10, 64)
+		if err != nil {
+			return nil, true, errBadTableList
+		}
+		d, err := strconv.ParseUint(digest, 16, 64)
+		if err != nil {
+			return nil, true, errBadTableList
+		}
+		digests[n] = d
+	}
+	return digests, true, nil
+}
+
+// writeTableList atomically replaces the table list in dir with digests.
+func writeTableList(dir string, digests map[uint64]uint64) error {
+	var b strings.Builder
+	for _, n := range slices.Sorted(maps.Keys(digests)) {
+		fmt.Fprintf(&b, "%d %016x\n", n, digests[n])
+	}
+
+	return replaceFile(dir, tableListFileName, []byte(b.String()))
+}
+
+// replaceFile atomically replaces the file name in dir with data, syncing
+// the data before the rename and the directory after it, so a crash leaves
+// either the old contents or the new.
+func replaceFile(dir, name string, data []byte) error {
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op once renamed
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return fsutil.SyncDir(dir)
+}
+
+// tableFiles returns the file numbers of the table files in dir, listed or
+// not, in no particular order.
+func tableFiles(dir string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint64
+	for _, name := range names {
+		n, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".sst"), 10, 64)
+		if err != nil {
+			continue // not a table written by the store
+		}
+		nums = append(nums, n)
+	}
+	return nums, nil
+}
+
+// tableDigest returns the digest in the footer of the table at path.
+func tableDigest(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	t, err := sstable.NewReader(f, st.Size(), sstable.ReaderOptions{Name: path})
+	if err != nil {
+		return 0, err
+	}
+	return t.Digest(), nil
+}
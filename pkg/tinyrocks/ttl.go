@@ -0,0 +1,34 @@
+package tinyrocks
+
+import "encoding/binary"
+
+// ttlSuffixSize is the size of the write timestamp appended to every value
+// when the store runs with a db-wide TTL.
+const ttlSuffixSize = 8
+
+// ttlEnabled reports whether the store runs with a db-wide TTL.
+func (s *Store) ttlEnabled() bool {
+	return s.config.TTLSeconds > 0
+}
+
+// stampTTL returns val with the current unix time appended.
+func (s *Store) stampTTL(val []byte) []byte {
+	out := make([]byte, len(val)+ttlSuffixSize)
+	copy(out, val)
+	binary.BigEndian.PutUint64(out[len(val):], uint64(s.clock.Now().Unix()))
+	return out
+}
+
+// stripTTL removes the write timestamp from a stored value. It reports false
+// if the value has expired or is too short to carry a timestamp.
+func (s *Store) stripTTL(stored []byte) ([]byte, bool) {
+	if len(stored) < ttlSuffixSize {
+		return nil, false
+	}
+	n := len(stored) - ttlSuffixSize
+	written := int64(binary.BigEndian.Uint64(stored[n:]))
+	if s.clock.Now().Unix()-written >= int64(s.config.TTLSeconds) {
+		return nil, false
+	}
+	return stored[:n], true
+}
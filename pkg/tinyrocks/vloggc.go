@@ -0,0 +1,174 @@
+package tinyrocks
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/arthurzhang/kivi/internal/vlog"
+	"github.com/arthurzhang/kivi/internal/wal"
+)
+
+// Value-log GC. Flushes mark the blobs they shadow as discarded; after a
+// flush, files with at least Config.ValueLogGCRatio of their bytes
+// discarded are collected. Tables are never rewritten, so a live value is
+// collected by writing it again: it goes through the WAL and memtable like
+// any put, and its next flush moves it back to the head of the log. The
+// file is deleted once no read that started before the rewrites is left.
+
+// readEpochs counts the reads in flight per epoch. A read holds its epoch
+// from before it looks at the memtable until it has read every value it
+// found in the tables, so once the reads of earlier epochs have ended no
+// read can still follow a pointer that was live before a new one began.
+type readEpochs struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	cur    uint64
+	reads  map[uint64]int
+	closed bool
+}
+
+func newReadEpochs() *readEpochs {
+	e := &readEpochs{reads: make(map[uint64]int)}
+	e.cond.L = &e.mu
+	return e
+}
+
+// begin registers a read and returns its epoch, to be passed to end.
+func (e *readEpochs) begin() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reads[e.cur]++
+	return e.cur
+}
+
+func (e *readEpochs) end(epoch uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.reads[epoch]--; e.reads[epoch] == 0 {
+		delete(e.reads, epoch)
+		e.cond.Broadcast()
+	}
+}
+
+// wait starts a new epoch and waits for the reads of the earlier ones to
+// end. It returns false if close interrupted it.
+func (e *readEpochs) wait() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cur++
+	for !e.closed && e.readsBefore(e.cur) {
+		e.cond.Wait()
+	}
+	return !e.closed
+}
+
+func (e *readEpochs) readsBefore(epoch uint64) bool {
+	for ep := range e.reads {
+		if ep < epoch {
+			return true
+		}
+	}
+	return false
+}
+
+// close stops waits for good: an iterator that is never closed would
+// otherwise hold one up past Close.
+func (e *readEpochs) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	e.cond.Broadcast()
+}
+
+// maybeScheduleGC starts collecting the value log in the background unless
+// a collection is already running. Callers are flushes, which keep bg
+// above zero, so the Add cannot race Close's Wait.
+func (s *Store) maybeScheduleGC() {
+	if s.vlog == nil || s.config.ValueLogGCRatio <= 0 || !s.gcMu.TryLock() {
+		return
+	}
+	s.bg.Add(1)
+	go func() {
+		defer s.bg.Done()
+		defer s.gcMu.Unlock()
+		if err := s.collectValueLog(); err != nil && !errors.Is(err, ErrClosed) {
+			s.metrics.VLogGCErrors.Add(1)
+		}
+	}()
+}
+
+// collectValueLog collects value-log files while one has at least
+// Config.ValueLogGCRatio of its bytes discarded. A failed collection
+// leaves the file in place for a later one. Callers hold gcMu.
+func (s *Store) collectValueLog() error {
+	for {
+		fileNum, ok := s.vlog.GCCandidate(s.config.ValueLogGCRatio)
+		if !ok {
+			return nil
+		}
+		n, err := s.vlog.GC(fileNum, valueLogGC{s})
+		if err != nil {
+			return err
+		}
+		s.metrics.VLogGCFiles.Add(1)
+		s.metrics.VLogGCBytes.Add(n)
+		s.metrics.DiskLiveBytes.Set(s.liveBytes())
+	}
+}
+
+// valueLogGC is the vlog.GCHandler of a store.
+type valueLogGC struct{ s *Store }
+
+func (g valueLogGC) IsLive(key []byte, ptr vlog.Pointer) (bool, error) {
+	return g.s.blobIsLive(key, ptr)
+}
+
+// Rewrite puts val back unless key changed since IsLive; the check and
+// the write happen under s.mu so no write slips between them. val is
+// written as stored, so a TTL stamp keeps its expiry.
+func (g valueLogGC) Rewrite(key, val []byte, old vlog.Pointer) error {
+	s := g.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live, err := s.blobIsLive(key, old)
+	if err != nil || !live {
+		return err
+	}
+	return s.commit(DefaultWriteOptions(), []*wal.Record{{Type: wal.RecordPut, Key: key, Value: val}})
+}
+
+func (g valueLogGC) Commit() error {
+	s := g.s
+	if err := s.FlushWAL(true); err != nil {
+		return err
+	}
+	if !s.readEpochs.wait() {
+		return ErrClosed
+	}
+	return nil
+}
+
+// blobIsLive reports whether the newest state of key is a table blob at
+// ptr. Any state in the memtable is newer than every table.
+func (s *Store) blobIsLive(key []byte, ptr vlog.Pointer) (bool, error) {
+	if _, _, found := s.mem.Lookup(key); found {
+		return false, nil
+	}
+	cur, ok, err := s.tableBlob(key)
+	return ok && cur == ptr, err
+}
+
+// tableBlob returns the pointer held by the newest state of key in the
+// tables, if that state is a blob.
+func (s *Store) tableBlob(key []byte) (vlog.Pointer, bool, error) {
+	raw, found, err := s.tableValue(key, &ReadOptions{DontFillCache: true})
+	if err != nil || !found {
+		return vlog.Pointer{}, false, err
+	}
+	val, _, kind, err := decodeTableValue(raw)
+	if err != nil || kind != tableKindBlob {
+		return vlog.Pointer{}, false, err
+	}
+	ptr, err := vlog.DecodePointer(val)
+	return ptr, err == nil, err
+}
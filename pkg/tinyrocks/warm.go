@@ -0,0 +1,77 @@
+package tinyrocks
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	"github.com/arthurzhang/kivi/internal/cache"
+)
+
+// warmFileName lists the blocks cached at the last Close, most recently
+// used first, as [fileNum:8][offset:8] pairs.
+const warmFileName = "WARM_BLOCKS"
+
+// saveWarmBlocks records the cached blocks of live tables for the next
+// Open. It is best effort: a missing or stale list only costs a cold cache.
+func (s *Store) saveWarmBlocks() {
+	if !s.config.WarmBlockCache || s.bcache == nil {
+		return
+	}
+	live := make(map[uint64]bool)
+	for _, fileNum := range s.liveTables() {
+		live[fileNum] = true
+	}
+	var buf []byte
+	for _, k := range s.bcache.Keys() {
+		if live[k.FileNum] {
+			buf = binary.BigEndian.AppendUint64(buf, k.FileNum)
+			buf = binary.BigEndian.AppendUint64(buf, k.Offset)
+		}
+	}
+	path := filepath.Join(s.config.DataDir, warmFileName)
+	if err := os.WriteFile(path+".tmp", buf, 0644); err != nil {
+		return
+	}
+	os.Rename(path+".tmp", path)
+}
+
+// startWarmup reloads the blocks listed by the previous Close in the
+// background. Blocks are loaded coldest first so the hottest end up most
+// recently used if they no longer all fit.
+func (s *Store) startWarmup() {
+	if !s.config.WarmBlockCache || s.bcache == nil {
+		return
+	}
+	buf, err := os.ReadFile(filepath.Join(s.config.DataDir, warmFileName))
+	if err != nil {
+		return
+	}
+	keys := make([]cache.Key, len(buf)/16)
+	for i := range keys {
+		keys[i] = cache.Key{
+			FileNum: binary.BigEndian.Uint64(buf[16*i:]),
+			Offset:  binary.BigEndian.Uint64(buf[16*i+8:]),
+		}
+	}
+
+	s.bg.Add(1)
+	go func() {
+		defer s.bg.Done()
+		for i := len(keys) - 1; i >= 0; i-- {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			// Tables may have gone since the list was written; skip them.
+			r, release, err := s.tcache.Acquire(keys[i].FileNum)
+			if err != nil {
+				continue
+			}
+			r.LoadBlock(keys[i].Offset)
+			release()
+		}
+	}()
+}
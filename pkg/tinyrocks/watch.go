@@ -0,0 +1,131 @@
+package tinyrocks
+
+import (
+	"bytes"
+	"sync"
+)
+
+// EventOp is the kind of write reported by Watch.
+type EventOp int
+
+const (
+	EventPut EventOp = iota
+	EventDelete
+)
+
+// Event describes a committed write.
+type Event struct {
+	Key []byte
+	Op  EventOp
+	Seq uint64
+}
+
+// watcher buffers events for one Watch subscriber so a slow consumer never
+// blocks the write path.
+type watcher struct {
+	prefix []byte
+	ch     chan Event
+
+	mu     sync.Mutex
+	queue  []Event
+	signal chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Watch returns a channel delivering, in commit order, an event for every
+// write whose key starts with prefix, and a function that cancels the
+// subscription and closes the channel. Events are queued without bound, so
+// consumers should keep up or cancel.
+func (s *Store) Watch(prefix []byte) (<-chan Event, func()) {
+	w := &watcher{
+		prefix: append([]byte(nil), prefix...),
+		ch:     make(chan Event),
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+
+	s.watchMu.Lock()
+	if s.watchers == nil {
+		s.watchers = make(map[*watcher]struct{})
+	}
+	s.watchers[w] = struct{}{}
+	s.watchMu.Unlock()
+
+	cancel := func() {
+		s.watchMu.Lock()
+		delete(s.watchers, w)
+		s.watchMu.Unlock()
+		w.stop()
+	}
+	return w.ch, cancel
+}
+
+// notify queues an event for every watcher whose prefix matches key. It is
+// called with s.mu held so events are queued in sequence order.
+func (s *Store) notify(key []byte, op EventOp, seq uint64) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for w := range s.watchers {
+		if bytes.HasPrefix(key, w.prefix) {
+			w.push(Event{Key: append([]byte(nil), key...), Op: op, Seq: seq})
+		}
+	}
+}
+
+// stopWatchers cancels every subscription; used by Close.
+func (s *Store) stopWatchers() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for w := range s.watchers {
+		w.stop()
+		delete(s.watchers, w)
+	}
+}
+
+func (w *watcher) push(ev Event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, ev)
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) stop() {
+	w.once.Do(func() { close(w.done) })
+}
+
+func (w *watcher) run() {
+	defer close(w.ch)
+
+	for {
+		select {
+		case <-w.signal:
+		case <-w.done:
+			return
+		}
+
+		for {
+			w.mu.Lock()
+			if len(w.queue) == 0 {
+				w.mu.Unlock()
+				break
+			}
+			ev := w.queue[0]
+			w.queue = w.queue[1:]
+			w.mu.Unlock()
+
+			select {
+			case w.ch <- ev:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}